@@ -0,0 +1,6 @@
+// +k8s:deepcopy-gen=package
+// +groupName=mirror.linkerd.io
+
+// Package v1alpha1 contains the GlobalService API, which declares what mirrored services get aggregated
+// into a global service and how the global service should look like.
+package v1alpha1
@@ -0,0 +1,127 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ServiceType is how the global service gets exposed.
+type ServiceType string
+
+const (
+	// ServiceTypeHeadless creates global service without cluster ip, each endpoint gets its own A record.
+	ServiceTypeHeadless ServiceType = "Headless"
+	// ServiceTypeClusterIP creates global service with cluster ip, load balanced across all the endpoints.
+	ServiceTypeClusterIP ServiceType = "ClusterIP"
+)
+
+// Condition types reported on GlobalService status.
+const (
+	// ConditionReady is true when global service is in sync and has endpoints.
+	ConditionReady = "Ready"
+	// ConditionDegraded is true when some of the mirrored services or endpointslices couldn't be aggregated.
+	ConditionDegraded = "Degraded"
+)
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// GlobalService declares a logical service whose mirrored services, x-target1, x-target2...
+// are aggregated into single global service.
+type GlobalService struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec GlobalServiceSpec `json:"spec"`
+	// +optional
+	Status GlobalServiceStatus `json:"status,omitempty"`
+}
+
+// GlobalServiceSpec is the spec of GlobalService.
+type GlobalServiceSpec struct {
+	// Service is the logical name of the service, i.e. x for mirrored services x-target1, x-target2.
+	Service string `json:"service"`
+
+	// Clusters only includes mirrored services from these target clusters, all clusters when empty.
+	// +optional
+	Clusters []string `json:"clusters,omitempty"`
+
+	// ExcludeClusters excludes mirrored services from these target clusters.
+	// +optional
+	ExcludeClusters []string `json:"excludeClusters,omitempty"`
+
+	// Name overrides the name of generated global service, defaults to <service>-global, or what --global-name-template of the operator generates.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Ports overrides the ports of generated global service, defaults to ports of all the mirrored services.
+	// +optional
+	Ports []corev1.ServicePort `json:"ports,omitempty"`
+
+	// Type of generated global service, defaults to Headless.
+	// +optional
+	Type ServiceType `json:"type,omitempty"`
+
+	// Failover only puts endpoints of the highest priority cluster having ready endpoints into global service,
+	// instead of endpoints of all the clusters.
+	// +optional
+	Failover *Failover `json:"failover,omitempty"`
+}
+
+// Failover is the priority of clusters, in failover mode.
+type Failover struct {
+	// Clusters in order of priority. Clusters which aren't listed come after them, sorted by name.
+	Clusters []string `json:"clusters"`
+
+	// FailbackDelay is how long higher priority cluster has to have ready endpoints, before global service switches
+	// back to it. Defaults to --failback-delay of the operator.
+	// +optional
+	FailbackDelay *metav1.Duration `json:"failbackDelay,omitempty"`
+}
+
+// GlobalServiceStatus is the health of aggregation, as last observed by the operator.
+type GlobalServiceStatus struct {
+	// ObservedGeneration is the generation of spec this status is for.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions are Ready and Degraded.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// ClustersContributing are the target clusters with endpoints in global service.
+	// +optional
+	ClustersContributing []string `json:"clustersContributing,omitempty"`
+
+	// EndpointCount is the number of endpoints in global service, across all clusters.
+	// +optional
+	EndpointCount int32 `json:"endpointCount,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// GlobalServiceList is a list of GlobalService.
+type GlobalServiceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []GlobalService `json:"items"`
+}
+
+// IncludesCluster reports if mirrored services from the target cluster are part of this GlobalService.
+func (gs *GlobalService) IncludesCluster(cluster string) bool {
+	for _, excluded := range gs.Spec.ExcludeClusters {
+		if excluded == cluster {
+			return false
+		}
+	}
+	if len(gs.Spec.Clusters) == 0 {
+		return true
+	}
+	for _, included := range gs.Spec.Clusters {
+		if included == cluster {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,28 @@
+#!/usr/bin/env bash
+
+# Regenerates deepcopy, clientset, listers and informers for the GlobalService API.
+set -o errexit
+set -o nounset
+set -o pipefail
+
+MODULE=github.com/rushi47/service-mirror-prototype
+# Generators must not touch go.mod.
+export GOFLAGS=-mod=readonly
+ROOT=$(cd "$(dirname "${BASH_SOURCE[0]}")/.." && pwd)
+CODEGEN_VERSION=$(cd "${ROOT}" && go list -m -f '{{.Version}}' k8s.io/client-go)
+
+OUTPUT_BASE=$(mktemp -d)
+trap 'rm -rf "${OUTPUT_BASE}"' EXIT
+
+# Download outside of the module, so go.mod is left alone.
+CODEGEN_PKG=$(cd "${OUTPUT_BASE}" && go mod download -json "k8s.io/code-generator@${CODEGEN_VERSION}" | sed -n 's/.*"Dir": "\(.*\)",/\1/p')
+
+# Generators expect GOPATH like layout, point it at the repo using symlink.
+mkdir -p "${OUTPUT_BASE}/$(dirname "${MODULE}")"
+ln -s "${ROOT}" "${OUTPUT_BASE}/${MODULE}"
+
+bash "${CODEGEN_PKG}/generate-groups.sh" deepcopy,applyconfiguration,client,lister,informer \
+  "${MODULE}/generated" "${MODULE}/apis" \
+  "mirror:v1alpha1" \
+  --output-base "${OUTPUT_BASE}" \
+  --go-header-file "${ROOT}/hack/boilerplate.go.txt"
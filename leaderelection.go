@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// LeaderElectionConfig configures the Lease based leader election between replicas.
+type LeaderElectionConfig struct {
+	LeaseName     string
+	Namespace     string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// runWithLeaderElection blocks until leadership is acquired, then runs lead until leadership is lost
+// or ctx is cancelled. lead must return once its context is done.
+func runWithLeaderElection(ctx context.Context, client kubernetes.Interface, log *logrus.Logger, cfg LeaderElectionConfig, lead func(ctx context.Context)) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("unable to get hostname for leader election identity: %w", err)
+	}
+	// Unique even when replicas share hostname.
+	identity := hostname + "_" + string(uuid.NewUUID())
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      cfg.LeaseName,
+			Namespace: cfg.Namespace,
+		},
+		Client: client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+
+	// Elector starts OnStartedLeading in a goroutine and doesn't wait for it, so track whether lead
+	// is running to wait for it once elector is done. 0: not started, 1: leading, 2: elector done.
+	var state atomic.Int32
+	leadDone := make(chan struct{})
+	// waitLead returns once lead is done, or can't start anymore.
+	waitLead := func() bool {
+		if state.CompareAndSwap(0, 2) || state.Load() == 2 {
+			return false
+		}
+		<-leadDone
+		return true
+	}
+
+	// Elector releases the lease as soon as its context is done, so it's only cancelled once lead has returned.
+	// Otherwise another replica could take over while in-flight reconciles are still writing global objects.
+	electorCtx, cancelElector := context.WithCancel(context.Background())
+	defer cancelElector()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-electorCtx.Done():
+			return
+		}
+		waitLead()
+		cancelElector()
+	}()
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: cfg.LeaseDuration,
+		RenewDeadline: cfg.RenewDeadline,
+		RetryPeriod:   cfg.RetryPeriod,
+		// Hand over the lease right away on shutdown, instead of making others wait for it to expire.
+		// Safe as electorCtx is only cancelled once lead is done.
+		ReleaseOnCancel: true,
+		Name:            cfg.LeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				if !state.CompareAndSwap(0, 1) {
+					return
+				}
+				defer close(leadDone)
+				// Lead stops when leadership is lost or on shutdown, the elector only notices the former.
+				leadCtx, cancelLead := context.WithCancel(leaderCtx)
+				defer cancelLead()
+				go func() {
+					select {
+					case <-ctx.Done():
+						cancelLead()
+					case <-leadCtx.Done():
+					}
+				}()
+				log.Infof("Acquired lease %v/%v as %v, starting reconciliation", cfg.Namespace, cfg.LeaseName, identity)
+				lead(leadCtx)
+			},
+			OnStoppedLeading: func() {
+				log.Debugf("Leader election for lease %v/%v stopped", cfg.Namespace, cfg.LeaseName)
+			},
+			OnNewLeader: func(current string) {
+				if current != identity {
+					log.Infof("Current leader is %v, waiting for lease %v/%v", current, cfg.Namespace, cfg.LeaseName)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("invalid leader election config: %w", err)
+	}
+
+	log.Infof("Waiting to acquire lease %v/%v as %v", cfg.Namespace, cfg.LeaseName, identity)
+	elector.Run(electorCtx)
+
+	// Leadership lost or ctx cancelled, let in-flight reconciles finish.
+	if waitLead() {
+		log.Infof("Not leading lease %v/%v anymore, reconciliation stopped", cfg.Namespace, cfg.LeaseName)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLeaseHeldUntilLeadReturns(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	cfg := LeaderElectionConfig{LeaseName: "global-mirror", Namespace: "default", LeaseDuration: 2 * time.Second, RenewDeadline: time.Second, RetryPeriod: 100 * time.Millisecond}
+
+	holder := func() string {
+		lease, err := client.CoordinationV1().Leases(cfg.Namespace).Get(context.Background(), cfg.LeaseName, metav1.GetOptions{})
+		if err != nil || lease.Spec.HolderIdentity == nil {
+			return ""
+		}
+		return *lease.Spec.HolderIdentity
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leading := make(chan struct{})
+	draining := make(chan struct{})
+	drained := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- runWithLeaderElection(ctx, client, log, cfg, func(leadCtx context.Context) {
+			close(leading)
+			<-leadCtx.Done()
+			// In-flight reconciles draining, until the test lets them finish.
+			close(draining)
+			<-drained
+		})
+	}()
+
+	select {
+	case <-leading:
+	case <-time.After(5 * time.Second):
+		t.Fatal("lease not acquired")
+	}
+	cancel()
+	select {
+	case <-draining:
+	case <-time.After(5 * time.Second):
+		t.Fatal("lead not cancelled along with ctx")
+	}
+	if got := holder(); got == "" {
+		t.Errorf("lease released while lead was still running")
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("runWithLeaderElection() returned while lead was still running, error = %v", err)
+	default:
+	}
+
+	close(drained)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("runWithLeaderElection() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("runWithLeaderElection() didn't return after lead did")
+	}
+	if got := holder(); got != "" {
+		t.Errorf("lease held by %v after shutdown, want it released", got)
+	}
+}
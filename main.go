@@ -2,18 +2,29 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/rushi47/service-mirror-prototype/generated/clientset/versioned"
 	globalMirrorWatcher "github.com/rushi47/service-mirror-prototype/watcher"
 	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
-	client "k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
-// Make sure all global services lies in only one namespace.
+// Default namespace of global services in single namespace mode, --globalsvc-ns changes it.
 const GLOBAL_SVC_NAMESPACE = "default"
 
 func main() {
@@ -31,22 +42,204 @@ func main() {
 	//Shows line number: Too long
 	// log.SetReportCaller(true)
 
-	log.Info("Starting Global Mirror")
-
-	var kubeconfig *string
-	if home := homedir.HomeDir(); home != "" {
-		kubeconfig = flag.String("kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
+	// `manifests` prints what it takes to deploy the operator with the rest of the flags, instead of running it.
+	printManifests := len(os.Args) > 1 && os.Args[1] == "manifests"
+	var image *string
+	var replicas *int
+	if printManifests {
+		image = flag.String("image", "", "(manifests) Image of the operator to deploy, required as nothing here builds one.")
+		replicas = flag.Int("replicas", 0, "(manifests) Number of replicas to deploy, defaults to 2 with --leader-elect and 1 otherwise.")
 	}
 
+	//Falls back to in-cluster config, when there is no kubeconfig.
+	kubeconfig := flag.String("kubeconfig", "", "(optional) absolute path to the kubeconfig file, defaults to $KUBECONFIG or ~/.kube/config.")
+	kubeContext := flag.String("context", "", "(optional) Name of the kubeconfig context to use, defaults to the current context.")
+	master := flag.String("master", "", "(optional) Address of the Kubernetes API server, overrides the one in kubeconfig.")
+
 	//Specify the NameSpace for install controller & global svc.
 	globalSvcNamespace := flag.String("globalsvc-ns", GLOBAL_SVC_NAMESPACE, "(optional) Namespace to install service mirror controller and global mirror services.")
+	namespaceMode := flag.String("namespace-mode", "single", "(optional) Where global services are created: single, all in --globalsvc-ns, or preserve, in namespace of their mirrored services.")
+	namespaceMapping := flag.String("namespace-mapping", "", "(optional) With --namespace-mode=preserve, namespaces of mirrored services to create global services in instead, i.e. team-a=team-a-global,team-b=shared.")
+
+	//Which mirrored services are watched and aggregated.
+	watchNamespaces := flag.String("watch-namespaces", "", "(optional) Namespaces mirrored services are watched in, i.e. team-a,team-b. Defaults to all namespaces.")
+	namespaceSelector := flag.String("namespace-selector", "", "(optional) Only aggregate mirrored services of namespaces with matching labels, i.e. team=a.")
+	serviceSelector := flag.String("service-selector", "", "(optional) Only aggregate mirrored services with matching labels, i.e. mirror.linkerd.io/global=true. Ones labelled mirror.linkerd.io/global=false never are.")
+
+	//Number of global services reconciled in parallel.
+	workers := flag.Int("workers", 2, "(optional) Number of workers reconciling global services in parallel.")
+
+	//Garbage collection of global objects whose mirrored sources are gone.
+	resyncPeriod := flag.Duration("resync-period", 5*time.Minute, "(optional) How often global objects are compared against mirrored ones to clean up orphans.")
+	dryRun := flag.Bool("dry-run", false, "(optional) Only report orphaned global objects which would be deleted, without deleting them.")
+
+	//Aggregate services which are not declared by any GlobalService.
+	autoAggregate := flag.Bool("auto-aggregate", false, "(optional) Aggregate every mirrored service not declared by a GlobalService into <service>-global, or what --global-name-template generates.")
+
+	//Leader election, so only one of the replicas reconciles.
+	leaderElect := flag.Bool("leader-elect", false, "(optional) Use Lease based leader election, required when running more than one replica.")
+	leaseName := flag.String("leader-election-id", "global-mirror", "(optional) Name of the Lease used for leader election.")
+	leaseNamespace := flag.String("leader-election-namespace", "", "(optional) Namespace of the Lease used for leader election, defaults to --globalsvc-ns.")
+	leaseDuration := flag.Duration("lease-duration", 15*time.Second, "(optional) How long non-leaders wait before trying to take over an unrenewed lease.")
+	renewDeadline := flag.Duration("renew-deadline", 10*time.Second, "(optional) How long the leader keeps on retrying to renew the lease before giving up on it.")
+	retryPeriod := flag.Duration("leader-election-retry-period", 2*time.Second, "(optional) How often replicas try to acquire or renew the lease.")
+
+	//Prometheus metrics.
+	metricsAddr := flag.String("metrics-addr", ":8080", "(optional) Address to serve prometheus metrics on at /metrics, empty to disable.")
+
+	//Liveness & readiness probes.
+	healthAddr := flag.String("health-probe-addr", ":8081", "(optional) Address to serve /healthz and /readyz probes on, empty to disable.")
+	stallTimeout := flag.Duration("stall-timeout", 2*time.Minute, "(optional) Liveness fails when a worker is stuck on a global service, or informers keep on failing to list and watch, for longer than this.")
+
+	//Sharding of global endpointslices.
+	maxEndpointsPerSlice := flag.Int("max-endpoints-per-slice", 100, "(optional) Most endpoints a global EndpointSlice holds, bigger mirrored EndpointSlices are spread over several of them (max 1000).")
+
+	//Naming of global services.
+	globalNameTemplate := flag.String("global-name-template", "", "(optional) Go template naming global services, i.e. {{.Service}}-all. Defaults to <service>-global.")
+	globalNameTemplateFile := flag.String("global-name-template-file", "", "(optional) File to read --global-name-template from.")
+
+	//Hostnames of endpoints in global endpointslices, i.e. per-pod DNS names.
+	hostnameMode := flag.String("hostname-mode", "suffix", "(optional) How cluster is added to hostnames of global endpoints: suffix (x-0-target1), prefix (target1-x-0), or preserve (x-0 when unique across clusters, suffix otherwise).")
+	clusterAliases := flag.String("cluster-aliases", "", "(optional) Short names used for clusters in hostnames, i.e. us-east-1=use1,eu-west-1=euw1.")
 
-	flag.Parse()
+	//Mirrored endpoints without hostname, i.e. gateway IP while Linkerd updates mirrored service.
+	gatewayIPPolicy := flag.String("gateway-ip-policy", "keep-last-known", "(optional) What happens to mirrored EndpointSlices with endpoints without hostname (gateway IP): keep-last-known global endpoints, skip-endpoints without hostname, or include-gateway endpoints without hostname.")
+	gatewayIPRetryPeriod := flag.Duration("gateway-ip-retry-period", 10*time.Second, "(optional) How soon global service is reconciled again, after its mirrored EndpointSlices had gateway IP.")
+
+	//Endpoints which aren't ready, and clusters without enough ready endpoints.
+	readinessPolicy := flag.String("readiness-policy", "publish-all", "(optional) What happens to endpoints which aren't ready: publish-all with their conditions, ready-only endpoints, or mark-not-serving endpoints as not ready and terminating.")
+	minReadyEndpoints := flag.Int("min-ready-endpoints", 0, "(optional) Ready endpoints a cluster needs, below which its endpoints are withdrawn from global services.")
+	clusterMinReadyEndpoints := flag.String("cluster-min-ready-endpoints", "", "(optional) --min-ready-endpoints of some clusters, i.e. us-east-1=3,eu-west-1=1.")
+
+	//Failover mode of global services.
+	failbackDelay := flag.Duration("failback-delay", 30*time.Second, "(optional) How long higher priority cluster of global service in failover mode has to have ready endpoints, before traffic goes back to it.")
+
+	//Type of global services.
+	globalServiceType := flag.String("global-service-type", "Headless", "(optional) Type of global services, Headless or ClusterIP, unless GlobalService or mirror.linkerd.io/global-service-type annotation of mirrored services says otherwise.")
+
+	//Traffic split next to global services.
+	trafficSplit := flag.String("traffic-split", "none", "(optional) Create ClusterIP apex service <global service>-split next to every global service, with traffic split across mirrored services by: none, smi (TrafficSplit) or httproute (Gateway API HTTPRoute).")
+
+	//Server-side apply of global objects.
+	forceConflicts := flag.Bool("force-conflicts", false, "(optional) Take over fields of global objects owned by other field managers, instead of failing to write them.")
+
+	//Graceful shutdown on SIGINT/SIGTERM.
+	shutdownGracePeriod := flag.Duration("shutdown-grace-period", 30*time.Second, "(optional) How long in-flight reconciles get to finish on shutdown, before they are cancelled.")
+
+	args := os.Args[1:]
+	if printManifests {
+		args = args[1:]
+	}
+	// Exits on invalid flags.
+	_ = flag.CommandLine.Parse(args)
+
+	if *leaseNamespace == "" {
+		*leaseNamespace = *globalSvcNamespace
+	}
+
+	if *globalNameTemplateFile != "" {
+		data, err := os.ReadFile(*globalNameTemplateFile)
+		if err != nil {
+			log.Fatalf("Unable to read global name template: %v", err)
+		}
+		// Set as flag, so manifests pass the template on instead of the local file.
+		_ = flag.Set("global-name-template", strings.TrimSpace(string(data)))
+	}
+	var naming globalMirrorWatcher.NamingStrategy
+	if *globalNameTemplate != "" {
+		templateNaming, err := globalMirrorWatcher.NewTemplateNaming(*globalNameTemplate)
+		if err != nil {
+			log.Fatalf("Unable to use global name template: %v", err)
+		}
+		naming = templateNaming
+	}
 
-	// use the current context in kubeconfig
-	config, err := client.BuildConfigFromFlags("", *kubeconfig)
+	mode, err := globalMirrorWatcher.ParseHostnameMode(*hostnameMode)
 	if err != nil {
-		log.Panicf("Probably running Inside Cluster: %v", err)
+		log.Fatalf("Invalid --hostname-mode: %v", err)
+	}
+	gatewayPolicy, err := globalMirrorWatcher.ParseGatewayIPPolicy(*gatewayIPPolicy)
+	if err != nil {
+		log.Fatalf("Invalid --gateway-ip-policy: %v", err)
+	}
+	aliases, err := parseClusterAliases(*clusterAliases)
+	if err != nil {
+		log.Fatalf("Invalid --cluster-aliases: %v", err)
+	}
+	readiness, err := globalMirrorWatcher.ParseReadinessPolicy(*readinessPolicy)
+	if err != nil {
+		log.Fatalf("Invalid --readiness-policy: %v", err)
+	}
+	clusterMinReady, err := parseClusterMinReadyEndpoints(*clusterMinReadyEndpoints)
+	if err != nil {
+		log.Fatalf("Invalid --cluster-min-ready-endpoints: %v", err)
+	}
+	splitKind, err := globalMirrorWatcher.ParseTrafficSplitKind(*trafficSplit)
+	if err != nil {
+		log.Fatalf("Invalid --traffic-split: %v", err)
+	}
+	serviceType, err := globalMirrorWatcher.ParseServiceType(*globalServiceType)
+	if err != nil {
+		log.Fatalf("Invalid --global-service-type: %v", err)
+	}
+	nsMode, err := globalMirrorWatcher.ParseNamespaceMode(*namespaceMode)
+	if err != nil {
+		log.Fatalf("Invalid --namespace-mode: %v", err)
+	}
+	nsMapping, err := parseNamespaceMapping(*namespaceMapping)
+	if err != nil {
+		log.Fatalf("Invalid --namespace-mapping: %v", err)
+	}
+	if len(nsMapping) > 0 && nsMode != globalMirrorWatcher.NamespacePreserve {
+		log.Fatalf("--namespace-mapping only applies to --namespace-mode=%v", globalMirrorWatcher.NamespacePreserve)
+	}
+	watched, err := parseWatchNamespaces(*watchNamespaces)
+	if err != nil {
+		log.Fatalf("Invalid --watch-namespaces: %v", err)
+	}
+	nsSelector, err := parseSelector(*namespaceSelector)
+	if err != nil {
+		log.Fatalf("Invalid --namespace-selector: %v", err)
+	}
+	svcSelector, err := parseSelector(*serviceSelector)
+	if err != nil {
+		log.Fatalf("Invalid --service-selector: %v", err)
+	}
+
+	if printManifests {
+		if *replicas == 0 {
+			*replicas = 1
+			if *leaderElect {
+				*replicas = 2
+			}
+		}
+		err := writeManifests(os.Stdout, ManifestConfig{
+			Image:               *image,
+			Replicas:            *replicas,
+			Namespace:           *globalSvcNamespace,
+			LeaderElect:         *leaderElect,
+			LeaseName:           *leaseName,
+			LeaseNamespace:      *leaseNamespace,
+			MetricsAddr:         *metricsAddr,
+			HealthAddr:          *healthAddr,
+			ShutdownGracePeriod: *shutdownGracePeriod,
+			TrafficSplit:        splitKind,
+			NamespaceMode:       nsMode,
+			WatchNamespaces:     watched,
+			NamespaceSelector:   nsSelector != nil,
+			Args:                operatorArgs(flag.CommandLine),
+		})
+		if err != nil {
+			log.Fatalf("Unable to generate manifests: %v", err)
+		}
+		return
+	}
+
+	log.Info("Starting Global Mirror")
+
+	config, err := buildConfig(*kubeconfig, *kubeContext, *master)
+	if err != nil {
+		log.Fatalf("Unable to load Kubernetes client config: %v", err)
 	}
 
 	// creates the clientset
@@ -55,16 +248,227 @@ func main() {
 		log.Panicf("Issue in building client from config: %v", err)
 	}
 
-	//Make sure it runs in loop.
-	stopCh := make(chan struct{})
-	defer close(stopCh)
+	// creates the clientset for GlobalServices
+	mirrorClient, err := versioned.NewForConfig(config)
+	if err != nil {
+		log.Panicf("Issue in building GlobalService client from config: %v", err)
+	}
 
-	watcher := globalMirrorWatcher.NewWatch(context.Background(), *client, log, *globalSvcNamespace)
+	// creates the client for traffic split resources
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		log.Panicf("Issue in building dynamic client from config: %v", err)
+	}
+
+	// Root context, cancelled on SIGINT/SIGTERM. Everything stops once it's done.
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		log.Info("Stopping Global Mirror")
+		// Second signal kills the process right away.
+		cancel()
+	}()
+
+	watcher := globalMirrorWatcher.NewWatch(ctx, client, mirrorClient, dynamicClient, log, globalMirrorWatcher.Options{
+		Namespace:                *globalSvcNamespace,
+		Workers:                  *workers,
+		ResyncPeriod:             *resyncPeriod,
+		DryRun:                   *dryRun,
+		AutoAggregate:            *autoAggregate,
+		StallTimeout:             *stallTimeout,
+		ShutdownGracePeriod:      *shutdownGracePeriod,
+		MaxEndpointsPerSlice:     *maxEndpointsPerSlice,
+		ForceConflicts:           *forceConflicts,
+		Naming:                   naming,
+		Hostnames:                globalMirrorWatcher.ClusterHostnames{Mode: mode, Aliases: aliases},
+		GatewayIPPolicy:          gatewayPolicy,
+		GatewayIPRetryPeriod:     *gatewayIPRetryPeriod,
+		ReadinessPolicy:          readiness,
+		MinReadyEndpoints:        *minReadyEndpoints,
+		ClusterMinReadyEndpoints: clusterMinReady,
+		FailbackDelay:            *failbackDelay,
+		TrafficSplit:             splitKind,
+		ServiceType:              serviceType,
+		NamespaceMode:            nsMode,
+		NamespaceMapping:         nsMapping,
+		WatchNamespaces:          watched,
+		NamespaceSelector:        nsSelector,
+		ServiceSelector:          svcSelector,
+	})
 
 	watcher.RegisterHandlers()
 
-	watcher.Run(stopCh)
+	var servers []*http.Server
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", watcher.MetricsHandler())
+		log.Infof("Serving metrics on %v/metrics", *metricsAddr)
+		servers = append(servers, serve(log, "Metrics", *metricsAddr, mux))
+	}
+
+	if *healthAddr != "" {
+		log.Infof("Serving health probes on %v/healthz and %v/readyz", *healthAddr, *healthAddr)
+		servers = append(servers, serve(log, "Health probe", *healthAddr, watcher.HealthHandler()))
+	}
+
+	defer func() {
+		// Workers are done by now, stop informers and then the servers.
+		cancel()
+		watcher.Shutdown()
+		for _, server := range servers {
+			shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := server.Shutdown(shutdownCtx); err != nil {
+				log.Errorf("Unable to shut down server on %v: %v", server.Addr, err)
+			}
+			cancelShutdown()
+		}
+		log.Info("Shutting down Global Mirror")
+	}()
+
+	// Every replica keeps caches warm, only the leader reconciles.
+	if err := watcher.Run(); err != nil {
+		log.Errorf("Unable to start informers: %v", err)
+		return
+	}
+
+	if !*leaderElect {
+		// Run until signalled to stop.
+		watcher.RunWorkers(ctx.Done())
+		return
+	}
+
+	err = runWithLeaderElection(ctx, client, log, LeaderElectionConfig{
+		LeaseName:     *leaseName,
+		Namespace:     *leaseNamespace,
+		LeaseDuration: *leaseDuration,
+		RenewDeadline: *renewDeadline,
+		RetryPeriod:   *retryPeriod,
+	}, func(leaderCtx context.Context) {
+		watcher.RunWorkers(leaderCtx.Done())
+	})
+	if err != nil {
+		log.Errorf("Leader election failed: %v", err)
+	}
+	// Workers can't be restarted once stopped, exit and let the replica rejoin the election on restart.
+}
+
+// buildConfig loads client config from kubeconfig, with context and API server overridden when set.
+// When there is no kubeconfig to load, it falls back to in-cluster config of the pod's service account.
+func buildConfig(kubeconfig, kubeContext, master string) (*rest.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: kubeContext}
+	overrides.ClusterInfo.Server = master
+
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	if err == nil {
+		return config, nil
+	}
+	// Kubeconfig, or context in it, was asked for explicitly, so don't silently ignore it.
+	if kubeconfig != "" || kubeContext != "" {
+		return nil, err
+	}
+
+	inClusterConfig, inClusterErr := rest.InClusterConfig()
+	if inClusterErr != nil {
+		return nil, fmt.Errorf("no usable kubeconfig (%v) and not running in cluster (%v)", err, inClusterErr)
+	}
+	if master != "" {
+		inClusterConfig.Host = master
+	}
+	return inClusterConfig, nil
+}
+
+// parseClusterAliases parses comma separated cluster=alias pairs.
+func parseClusterAliases(value string) (map[string]string, error) {
+	aliases := make(map[string]string)
+	if value == "" {
+		return aliases, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		cluster, alias, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || cluster == "" || alias == "" {
+			return nil, fmt.Errorf("%q isn't cluster=alias", pair)
+		}
+		if errs := validation.IsDNS1123Label(alias); len(errs) > 0 {
+			return nil, fmt.Errorf("alias %q of cluster %v: %v", alias, cluster, strings.Join(errs, ", "))
+		}
+		aliases[cluster] = alias
+	}
+	return aliases, nil
+}
+
+// parseClusterMinReadyEndpoints parses comma separated cluster=count pairs.
+func parseClusterMinReadyEndpoints(value string) (map[string]int, error) {
+	counts := make(map[string]int)
+	if value == "" {
+		return counts, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		cluster, count, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || cluster == "" {
+			return nil, fmt.Errorf("%q isn't cluster=count", pair)
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("count %q of cluster %v isn't a non-negative number", count, cluster)
+		}
+		counts[cluster] = n
+	}
+	return counts, nil
+}
+
+// parseNamespaceMapping parses comma separated namespace=global-namespace pairs.
+func parseNamespaceMapping(value string) (map[string]string, error) {
+	mapping := make(map[string]string)
+	if value == "" {
+		return mapping, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		namespace, global, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || namespace == "" || global == "" {
+			return nil, fmt.Errorf("%q isn't namespace=global-namespace", pair)
+		}
+		if errs := validation.IsDNS1123Label(global); len(errs) > 0 {
+			return nil, fmt.Errorf("namespace %q of %v: %v", global, namespace, strings.Join(errs, ", "))
+		}
+		mapping[namespace] = global
+	}
+	return mapping, nil
+}
+
+// parseWatchNamespaces parses comma separated namespaces, none meaning all of them.
+func parseWatchNamespaces(value string) ([]string, error) {
+	namespaces := make([]string, 0)
+	if value == "" {
+		return namespaces, nil
+	}
+	for _, namespace := range strings.Split(value, ",") {
+		namespace = strings.TrimSpace(namespace)
+		if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+			return nil, fmt.Errorf("namespace %q: %v", namespace, strings.Join(errs, ", "))
+		}
+		namespaces = append(namespaces, namespace)
+	}
+	return namespaces, nil
+}
+
+// parseSelector parses label selector, nil when empty so nothing is filtered.
+func parseSelector(value string) (labels.Selector, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	return labels.Parse(value)
+}
 
-	// Run the program indefinitely
-	<-stopCh
+// serve starts http server in background, it is stopped with Shutdown.
+func serve(log *logrus.Logger, name, addr string, handler http.Handler) *http.Server {
+	server := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("%v server stopped: %v", name, err)
+		}
+	}()
+	return server
 }
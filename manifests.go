@@ -0,0 +1,333 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	globalMirrorWatcher "github.com/rushi47/service-mirror-prototype/watcher"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/yaml"
+)
+
+// Name of every object deploying the operator.
+const manifestName = "global-mirror"
+
+// Flags which only matter to the binary running outside the cluster, not passed on to the Deployment.
+var localOnlyFlags = map[string]bool{
+	"kubeconfig": true,
+	"context":    true,
+	"master":     true,
+	"image":      true,
+	"replicas":   true,
+	// Passed on as --global-name-template.
+	"global-name-template-file": true,
+}
+
+// ManifestConfig is what the deployment manifests are generated from, it mirrors the flags operator runs with.
+type ManifestConfig struct {
+	Image               string
+	Replicas            int
+	Namespace           string
+	LeaderElect         bool
+	LeaseName           string
+	LeaseNamespace      string
+	MetricsAddr         string
+	HealthAddr          string
+	ShutdownGracePeriod time.Duration
+	// Apex services and traffic split resources are written next to mirrored services, in any namespace.
+	TrafficSplit globalMirrorWatcher.TrafficSplitKind
+	// In preserve mode global objects are written in any namespace, not only in Namespace.
+	NamespaceMode globalMirrorWatcher.NamespaceMode
+	// Mirrored services are only watched in these namespaces, in all of them when empty.
+	WatchNamespaces []string
+	// Namespaces are watched to tell which of them are selected.
+	NamespaceSelector bool
+	// Flags passed on to the operator container.
+	Args []string
+}
+
+// operatorArgs returns the flags explicitly set on the command line, the way Deployment should pass them.
+func operatorArgs(fs *flag.FlagSet) []string {
+	args := make([]string, 0)
+	fs.Visit(func(f *flag.Flag) {
+		if localOnlyFlags[f.Name] {
+			return
+		}
+		args = append(args, fmt.Sprintf("--%v=%v", f.Name, f.Value))
+	})
+	return args
+}
+
+// writeManifests prints Namespace, ServiceAccount, RBAC, Deployment and metrics Service as multi document yaml.
+func writeManifests(out io.Writer, cfg ManifestConfig) error {
+	objects, err := manifests(cfg)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return fmt.Errorf("unable to marshal %T: %w", obj, err)
+		}
+		if _, err := fmt.Fprintf(out, "---\n%s", data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func manifests(cfg ManifestConfig) ([]runtime.Object, error) {
+	if cfg.Image == "" {
+		return nil, fmt.Errorf("--image of the operator is required")
+	}
+	if cfg.Replicas > 1 && !cfg.LeaderElect {
+		return nil, fmt.Errorf("running %v replicas requires --leader-elect", cfg.Replicas)
+	}
+	if cfg.LeaseNamespace == "" {
+		cfg.LeaseNamespace = cfg.Namespace
+	}
+
+	labels := map[string]string{"app.kubernetes.io/name": manifestName}
+	meta := func(namespace string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: manifestName, Namespace: namespace, Labels: labels}
+	}
+	subjects := []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: manifestName, Namespace: cfg.Namespace}}
+
+	// Mirrored services and their endpointslices.
+	watchRules := []rbacv1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"services"}, Verbs: []string{"list", "watch"}},
+		{APIGroups: []string{"discovery.k8s.io"}, Resources: []string{"endpointslices"}, Verbs: []string{"list", "watch"}},
+	}
+	// Like global objects, apex services and traffic splits are server-side applied. They are written next to
+	// mirrored services, and watched there to only be written when they changed.
+	switch cfg.TrafficSplit {
+	case globalMirrorWatcher.TrafficSplitSMI:
+		watchRules = append(watchRules,
+			rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"services"}, Verbs: []string{"list", "watch", "create", "patch", "delete"}},
+			rbacv1.PolicyRule{APIGroups: []string{"split.smi-spec.io"}, Resources: []string{"trafficsplits"}, Verbs: []string{"list", "watch", "create", "patch", "delete"}})
+	case globalMirrorWatcher.TrafficSplitHTTPRoute:
+		watchRules = append(watchRules,
+			rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"services"}, Verbs: []string{"list", "watch", "create", "patch", "delete"}},
+			rbacv1.PolicyRule{APIGroups: []string{"gateway.networking.k8s.io"}, Resources: []string{"httproutes"}, Verbs: []string{"list", "watch", "create", "patch", "delete"}})
+	}
+	clusterRules := []rbacv1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"namespaces"}, Verbs: []string{"get", "create", "patch"}},
+	}
+	if len(cfg.WatchNamespaces) == 0 {
+		clusterRules = append(clusterRules, watchRules...)
+	}
+	if cfg.NamespaceSelector {
+		clusterRules = append(clusterRules, rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"namespaces"}, Verbs: []string{"list", "watch"}})
+	}
+
+	// Global objects, watched where they are written, and GlobalServices declaring them.
+	globalRules := []rbacv1.PolicyRule{
+		// Writes are server-side applies, which create objects which don't exist yet.
+		// Services are read before the first apply, those of users aren't taken over.
+		{APIGroups: []string{""}, Resources: []string{"services"}, Verbs: []string{"get", "list", "watch", "create", "patch", "delete"}},
+		// Global endpointslices block deletion of the global service owning them.
+		{APIGroups: []string{""}, Resources: []string{"services/finalizers"}, Verbs: []string{"update"}},
+		{APIGroups: []string{"discovery.k8s.io"}, Resources: []string{"endpointslices"}, Verbs: []string{"list", "watch", "create", "patch", "delete"}},
+		{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: []string{"create", "patch"}},
+		{APIGroups: []string{"mirror.linkerd.io"}, Resources: []string{"globalservices"}, Verbs: []string{"list", "watch"}},
+		{APIGroups: []string{"mirror.linkerd.io"}, Resources: []string{"globalservices/status"}, Verbs: []string{"patch"}},
+	}
+	if cfg.NamespaceMode == globalMirrorWatcher.NamespacePreserve {
+		// Global objects are next to mirrored services, in any namespace.
+		clusterRules = append(clusterRules, globalRules...)
+	}
+
+	objects := []runtime.Object{
+		&corev1.Namespace{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+			ObjectMeta: metav1.ObjectMeta{Name: cfg.Namespace, Labels: labels},
+		},
+		&corev1.ServiceAccount{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+			ObjectMeta: meta(cfg.Namespace),
+		},
+		// Mirrored services are watched cluster wide, unless limited to some namespaces.
+		// Global namespace is created when missing, apex services and traffic splits are written next to mirrored services.
+		&rbacv1.ClusterRole{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRole"},
+			ObjectMeta: meta(""),
+			Rules:      clusterRules,
+		},
+		&rbacv1.ClusterRoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRoleBinding"},
+			ObjectMeta: meta(""),
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: manifestName},
+			Subjects:   subjects,
+		},
+	}
+	if cfg.NamespaceMode != globalMirrorWatcher.NamespacePreserve {
+		// Global objects are only ever written in the global namespace.
+		objects = append(objects,
+			&rbacv1.Role{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "Role"},
+				ObjectMeta: meta(cfg.Namespace),
+				Rules:      globalRules,
+			},
+			&rbacv1.RoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "RoleBinding"},
+				ObjectMeta: meta(cfg.Namespace),
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: manifestName},
+				Subjects:   subjects,
+			},
+		)
+	}
+
+	for _, namespace := range cfg.WatchNamespaces {
+		watchMeta := metav1.ObjectMeta{Name: manifestName + "-watch", Namespace: namespace, Labels: labels}
+		objects = append(objects,
+			&rbacv1.Role{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "Role"},
+				ObjectMeta: watchMeta,
+				Rules:      watchRules,
+			},
+			&rbacv1.RoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "RoleBinding"},
+				ObjectMeta: watchMeta,
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: watchMeta.Name},
+				Subjects:   subjects,
+			},
+		)
+	}
+
+	if cfg.LeaderElect {
+		leaseMeta := metav1.ObjectMeta{Name: manifestName + "-leader-election", Namespace: cfg.LeaseNamespace, Labels: labels}
+		objects = append(objects,
+			&rbacv1.Role{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "Role"},
+				ObjectMeta: leaseMeta,
+				Rules: []rbacv1.PolicyRule{
+					// Lease doesn't exist before the first replica creates it, so create can't be limited by name.
+					{APIGroups: []string{"coordination.k8s.io"}, Resources: []string{"leases"}, Verbs: []string{"create"}},
+					{APIGroups: []string{"coordination.k8s.io"}, Resources: []string{"leases"}, ResourceNames: []string{cfg.LeaseName}, Verbs: []string{"get", "update"}},
+				},
+			},
+			&rbacv1.RoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "RoleBinding"},
+				ObjectMeta: leaseMeta,
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: leaseMeta.Name},
+				Subjects:   subjects,
+			},
+		)
+	}
+
+	container := corev1.Container{
+		Name:            manifestName,
+		Image:           cfg.Image,
+		Args:            cfg.Args,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("50m"),
+				corev1.ResourceMemory: resource.MustParse("64Mi"),
+			},
+			Limits: corev1.ResourceList{
+				corev1.ResourceMemory: resource.MustParse("256Mi"),
+			},
+		},
+		SecurityContext: &corev1.SecurityContext{
+			RunAsNonRoot:             boolPtr(true),
+			RunAsUser:                int64Ptr(65532),
+			ReadOnlyRootFilesystem:   boolPtr(true),
+			AllowPrivilegeEscalation: boolPtr(false),
+			Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+		},
+	}
+
+	var metricsPort int32
+	if cfg.MetricsAddr != "" {
+		port, err := addrPort(cfg.MetricsAddr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --metrics-addr: %w", err)
+		}
+		metricsPort = port
+		container.Ports = append(container.Ports, corev1.ContainerPort{Name: "metrics", ContainerPort: port, Protocol: corev1.ProtocolTCP})
+	}
+	if cfg.HealthAddr != "" {
+		port, err := addrPort(cfg.HealthAddr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --health-probe-addr: %w", err)
+		}
+		container.Ports = append(container.Ports, corev1.ContainerPort{Name: "health", ContainerPort: port, Protocol: corev1.ProtocolTCP})
+		probe := func(path string) *corev1.Probe {
+			return &corev1.Probe{
+				ProbeHandler:  corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Path: path, Port: intstr.FromString("health")}},
+				PeriodSeconds: 10,
+			}
+		}
+		container.LivenessProbe = probe("/healthz")
+		container.ReadinessProbe = probe("/readyz")
+	}
+
+	replicas := int32(cfg.Replicas)
+	// Leave room for in-flight reconciles to drain, before the pod gets killed.
+	terminationGracePeriod := int64((cfg.ShutdownGracePeriod + 10*time.Second).Seconds())
+	objects = append(objects, &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: appsv1.SchemeGroupVersion.String(), Kind: "Deployment"},
+		ObjectMeta: meta(cfg.Namespace),
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					ServiceAccountName:            manifestName,
+					TerminationGracePeriodSeconds: &terminationGracePeriod,
+					Containers:                    []corev1.Container{container},
+				},
+			},
+		},
+	})
+
+	if metricsPort != 0 {
+		objects = append(objects, &corev1.Service{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+			ObjectMeta: metav1.ObjectMeta{Name: manifestName + "-metrics", Namespace: cfg.Namespace, Labels: labels},
+			Spec: corev1.ServiceSpec{
+				Selector: labels,
+				Ports: []corev1.ServicePort{{
+					Name:       "metrics",
+					Port:       metricsPort,
+					TargetPort: intstr.FromString("metrics"),
+					Protocol:   corev1.ProtocolTCP,
+				}},
+			},
+		})
+	}
+	return objects, nil
+}
+
+// addrPort returns the port of listen address like :8080.
+func addrPort(addr string) (int32, error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return 0, err
+	}
+	p, err := strconv.ParseInt(port, 10, 32)
+	if err != nil || p <= 0 || p > 65535 {
+		return 0, fmt.Errorf("invalid port %q", port)
+	}
+	return int32(p), nil
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func int64Ptr(i int64) *int64 {
+	return &i
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"testing"
+
+	globalMirrorWatcher "github.com/rushi47/service-mirror-prototype/watcher"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// apiCall is a request operator makes, namespace is empty for cluster wide ones.
+type apiCall struct {
+	namespace string
+	group     string
+	resource  string
+	verb      string
+	name      string
+}
+
+// allowed reports if ClusterRoles or Roles in the call's namespace grant it. Bindings aren't checked, each role
+// generated has one.
+func allowed(objects []runtime.Object, call apiCall) bool {
+	for _, obj := range objects {
+		var rules []rbacv1.PolicyRule
+		switch role := obj.(type) {
+		case *rbacv1.ClusterRole:
+			rules = role.Rules
+		case *rbacv1.Role:
+			if call.namespace == "" || role.Namespace != call.namespace {
+				continue
+			}
+			rules = role.Rules
+		}
+		for _, rule := range rules {
+			if len(rule.ResourceNames) > 0 && !contains(rule.ResourceNames, call.name) {
+				continue
+			}
+			if contains(rule.APIGroups, call.group) && contains(rule.Resources, call.resource) && contains(rule.Verbs, call.verb) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestManifestsTrafficSplitVerbs(t *testing.T) {
+	tests := []struct {
+		kind     globalMirrorWatcher.TrafficSplitKind
+		group    string
+		resource string
+	}{
+		{kind: globalMirrorWatcher.TrafficSplitSMI, group: "split.smi-spec.io", resource: "trafficsplits"},
+		{kind: globalMirrorWatcher.TrafficSplitHTTPRoute, group: "gateway.networking.k8s.io", resource: "httproutes"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.kind), func(t *testing.T) {
+			objects, err := manifests(ManifestConfig{Image: "global-mirror:test", Namespace: "linkerd-global-mirror", Replicas: 1, TrafficSplit: tt.kind})
+			if err != nil {
+				t.Fatalf("manifests() error = %v", err)
+			}
+			// Apex services and splits are server-side applied in namespaces of mirrored services, and cleaned up by label.
+			for _, verb := range []string{"list", "watch", "create", "patch", "delete"} {
+				for _, call := range []apiCall{
+					{namespace: "test", group: "", resource: "services", verb: verb},
+					{namespace: "test", group: tt.group, resource: tt.resource, verb: verb},
+				} {
+					if !allowed(objects, call) {
+						t.Errorf("%v %v not allowed in namespace %v", call.verb, call.resource, call.namespace)
+					}
+				}
+			}
+		})
+	}
+}
+
+// calls returns a call for each of the verbs.
+func calls(namespace, group, resource string, verbs ...string) []apiCall {
+	c := make([]apiCall, 0, len(verbs))
+	for _, verb := range verbs {
+		c = append(c, apiCall{namespace: namespace, group: group, resource: resource, verb: verb})
+	}
+	return c
+}
+
+// watchCalls are the ones of informers of mirrored services and endpointslices.
+func watchCalls(namespace string) []apiCall {
+	return append(calls(namespace, "", "services", "list", "watch"),
+		calls(namespace, "discovery.k8s.io", "endpointslices", "list", "watch")...)
+}
+
+// globalCalls are the ones reconciling global services, global endpointslices and GlobalServices in the namespace.
+// Applies create objects which don't exist yet, so they need create on top of patch.
+// Global service name is checked with a get before the first apply.
+func globalCalls(namespace string) []apiCall {
+	c := calls(namespace, "", "services", "get", "list", "watch", "create", "patch", "delete")
+	c = append(c, calls(namespace, "", "services/finalizers", "update")...)
+	c = append(c, calls(namespace, "discovery.k8s.io", "endpointslices", "list", "watch", "create", "patch", "delete")...)
+	c = append(c, calls(namespace, "", "events", "create", "patch")...)
+	c = append(c, calls(namespace, "mirror.linkerd.io", "globalservices", "list", "watch")...)
+	return append(c, calls(namespace, "mirror.linkerd.io", "globalservices/status", "patch")...)
+}
+
+func TestManifestsRBAC(t *testing.T) {
+	const globalNamespace = "linkerd-global-mirror"
+	// Global namespace is read and applied when missing.
+	namespaceCalls := calls("", "", "namespaces", "get", "create", "patch")
+	tests := []struct {
+		name string
+		cfg  ManifestConfig
+		// Calls operator makes, which have to be allowed.
+		want []apiCall
+		// Calls operator doesn't make in the mode, which shouldn't be allowed.
+		notWant []apiCall
+	}{
+		{
+			name: "single",
+			cfg:  ManifestConfig{},
+			want: concat(namespaceCalls, watchCalls(""), globalCalls(globalNamespace)),
+			// Global objects are only written in the global namespace.
+			notWant: concat(calls("test", "", "services", "create", "patch", "delete"),
+				calls("test", "discovery.k8s.io", "endpointslices", "create", "patch", "delete"),
+				calls("", "mirror.linkerd.io", "globalservices", "list"),
+				calls("", "", "namespaces", "list", "watch"),
+				calls("", "split.smi-spec.io", "trafficsplits", "list")),
+		},
+		{
+			name: "traffic split smi",
+			cfg:  ManifestConfig{TrafficSplit: globalMirrorWatcher.TrafficSplitSMI},
+			// Apex services and splits are watched wherever mirrored services are.
+			want: concat(namespaceCalls, watchCalls(""), globalCalls(globalNamespace),
+				calls("", "", "services", "list", "watch", "create", "patch", "delete"),
+				calls("", "split.smi-spec.io", "trafficsplits", "list", "watch", "create", "patch", "delete")),
+			notWant: calls("", "gateway.networking.k8s.io", "httproutes", "list"),
+		},
+		{
+			name: "traffic split httproute",
+			cfg:  ManifestConfig{TrafficSplit: globalMirrorWatcher.TrafficSplitHTTPRoute},
+			want: concat(namespaceCalls, watchCalls(""), globalCalls(globalNamespace),
+				calls("", "", "services", "list", "watch", "create", "patch", "delete"),
+				calls("", "gateway.networking.k8s.io", "httproutes", "list", "watch", "create", "patch", "delete")),
+			notWant: calls("", "split.smi-spec.io", "trafficsplits", "list"),
+		},
+		{
+			name: "traffic split in watch namespaces",
+			cfg:  ManifestConfig{TrafficSplit: globalMirrorWatcher.TrafficSplitSMI, WatchNamespaces: []string{"a"}},
+			want: concat(namespaceCalls, watchCalls("a"), globalCalls(globalNamespace),
+				calls("a", "", "services", "list", "watch", "create", "patch", "delete"),
+				calls("a", "split.smi-spec.io", "trafficsplits", "list", "watch", "create", "patch", "delete")),
+			notWant: concat(calls("", "", "services", "list", "create"), calls("", "split.smi-spec.io", "trafficsplits", "list", "create")),
+		},
+		{
+			name: "preserve namespaces",
+			cfg:  ManifestConfig{NamespaceMode: globalMirrorWatcher.NamespacePreserve},
+			// Global objects are written and watched next to mirrored services.
+			want: concat(namespaceCalls, watchCalls(""), globalCalls("")),
+		},
+		{
+			name:    "watch namespaces",
+			cfg:     ManifestConfig{WatchNamespaces: []string{"a", "b"}},
+			want:    concat(namespaceCalls, watchCalls("a"), watchCalls("b"), globalCalls(globalNamespace)),
+			notWant: concat(watchCalls(""), watchCalls("test")),
+		},
+		{
+			name: "namespace selector",
+			cfg:  ManifestConfig{NamespaceSelector: true},
+			want: concat(namespaceCalls, watchCalls(""), globalCalls(globalNamespace), calls("", "", "namespaces", "list", "watch")),
+		},
+		{
+			name: "leader election",
+			cfg:  ManifestConfig{LeaderElect: true, Replicas: 2, LeaseName: "global-mirror", LeaseNamespace: "kube-system"},
+			want: concat(namespaceCalls, watchCalls(""), globalCalls(globalNamespace),
+				calls("kube-system", "coordination.k8s.io", "leases", "create"),
+				[]apiCall{
+					{namespace: "kube-system", group: "coordination.k8s.io", resource: "leases", verb: "get", name: "global-mirror"},
+					{namespace: "kube-system", group: "coordination.k8s.io", resource: "leases", verb: "update", name: "global-mirror"},
+				}),
+			notWant: []apiCall{{namespace: "kube-system", group: "coordination.k8s.io", resource: "leases", verb: "update", name: "other"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Image, tt.cfg.Namespace = "global-mirror:test", globalNamespace
+			if tt.cfg.Replicas == 0 {
+				tt.cfg.Replicas = 1
+			}
+			objects, err := manifests(tt.cfg)
+			if err != nil {
+				t.Fatalf("manifests() error = %v", err)
+			}
+			for _, call := range tt.want {
+				if !allowed(objects, call) {
+					t.Errorf("%v %v/%v not allowed in namespace %q", call.verb, call.group, call.resource, call.namespace)
+				}
+			}
+			for _, call := range tt.notWant {
+				if allowed(objects, call) {
+					t.Errorf("%v %v/%v allowed in namespace %q, operator doesn't need it", call.verb, call.group, call.resource, call.namespace)
+				}
+			}
+		})
+	}
+}
+
+func concat(c ...[]apiCall) []apiCall {
+	all := make([]apiCall, 0)
+	for _, calls := range c {
+		all = append(all, calls...)
+	}
+	return all
+}
+
+func TestManifestsRequireImage(t *testing.T) {
+	if _, err := manifests(ManifestConfig{Namespace: "linkerd-global-mirror", Replicas: 1}); err == nil {
+		t.Errorf("manifests() without image error = nil, want --image required")
+	}
+}
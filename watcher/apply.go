@@ -0,0 +1,151 @@
+package watcher
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apiError "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	discoveryv1ac "k8s.io/client-go/applyconfigurations/discovery/v1"
+	metav1ac "k8s.io/client-go/applyconfigurations/meta/v1"
+)
+
+// Field manager of all our writes. With server-side apply we only own the fields we set,
+// annotations or labels added by others on global objects are left alone.
+const fieldManager = "global-mirror"
+
+func (w *Watcher) applyOptions() metav1.ApplyOptions {
+	return metav1.ApplyOptions{FieldManager: fieldManager, Force: w.forceConflicts}
+}
+
+// explainConflict points at --force-conflicts, when apply failed as fields we set are owned by another field manager.
+func (w *Watcher) explainConflict(err error) error {
+	if apiError.IsConflict(err) && !w.forceConflicts {
+		return fmt.Errorf("%w (fields are owned by another field manager, --force-conflicts takes them over)", err)
+	}
+	return err
+}
+
+// globalServiceApply is everything we own on global service.
+// IP families are left to apiserver when there are none.
+func (svcW *Watcher) globalServiceApply(namespace, name string, ports []corev1.ServicePort, headless bool, ipFamilies []corev1.IPFamily, finalizer bool) *corev1ac.ServiceApplyConfiguration {
+	spec := corev1ac.ServiceSpec()
+	for _, port := range ports {
+		p := corev1ac.ServicePort().WithPort(port.Port).WithTargetPort(port.TargetPort)
+		if port.Name != "" {
+			p.WithName(port.Name)
+		}
+		if port.Protocol != "" {
+			p.WithProtocol(port.Protocol)
+		}
+		if port.AppProtocol != nil {
+			p.WithAppProtocol(*port.AppProtocol)
+		}
+		spec.WithPorts(p)
+	}
+	if headless {
+		spec.WithClusterIP(corev1.ClusterIPNone)
+	}
+	if len(ipFamilies) > 0 {
+		spec.WithIPFamilyPolicy(ipFamilyPolicy(ipFamilies)).WithIPFamilies(ipFamilies...)
+	}
+
+	svc := corev1ac.Service(name, namespace).
+		WithLabels(map[string]string{globalMirrorLabel: "true"}).
+		WithSpec(spec)
+	if finalizer {
+		// Lets us clean up before it's gone, its global endpointslices are garbage collected along with it.
+		svc.WithFinalizers(globalServiceFinalizer)
+	}
+	return svc
+}
+
+// globalEndpointSliceApply is everything we own on global endpointslice holding endpoints of mirrored endpointslice,
+// the ones of addressType.
+func (epsW *Watcher) globalEndpointSliceApply(name string, globalSvc *corev1.Service, endpointslice discoveryv1.EndpointSlice, addressType discoveryv1.AddressType, endpoints []discoveryv1.Endpoint) *discoveryv1ac.EndpointSliceApplyConfiguration {
+	owner := globalServiceOwner(globalSvc)
+	eps := discoveryv1ac.EndpointSlice(name, globalSvc.Namespace).
+		WithLabels(map[string]string{
+			serviceNameLabel:                  globalSvc.Name,
+			targetMirrorSvcNameLabel:          endpointslice.GetLabels()[serviceNameLabel],
+			sourceEndpointSliceLabel:          endpointslice.Name,
+			sourceEndpointSliceNamespaceLabel: endpointslice.Namespace,
+			clusterNameLabel:                  endpointslice.GetLabels()[clusterNameLabel],
+			globalMirrorLabel:                 "true",
+		}).
+		WithOwnerReferences(metav1ac.OwnerReference().
+			WithAPIVersion(owner.APIVersion).
+			WithKind(owner.Kind).
+			WithName(owner.Name).
+			WithUID(owner.UID).
+			WithController(*owner.Controller).
+			WithBlockOwnerDeletion(*owner.BlockOwnerDeletion)).
+		WithAddressType(addressType)
+
+	for _, ep := range endpoints {
+		eps.WithEndpoints(endpointApply(ep))
+	}
+	for _, port := range endpointslice.Ports {
+		p := discoveryv1ac.EndpointPort()
+		if port.Name != nil {
+			p.WithName(*port.Name)
+		}
+		if port.Protocol != nil {
+			p.WithProtocol(*port.Protocol)
+		}
+		if port.Port != nil {
+			p.WithPort(*port.Port)
+		}
+		if port.AppProtocol != nil {
+			p.WithAppProtocol(*port.AppProtocol)
+		}
+		eps.WithPorts(p)
+	}
+	return eps
+}
+
+func endpointApply(ep discoveryv1.Endpoint) *discoveryv1ac.EndpointApplyConfiguration {
+	e := discoveryv1ac.Endpoint().WithAddresses(ep.Addresses...)
+
+	conditions := discoveryv1ac.EndpointConditions()
+	if ep.Conditions.Ready != nil {
+		conditions.WithReady(*ep.Conditions.Ready)
+	}
+	if ep.Conditions.Serving != nil {
+		conditions.WithServing(*ep.Conditions.Serving)
+	}
+	if ep.Conditions.Terminating != nil {
+		conditions.WithTerminating(*ep.Conditions.Terminating)
+	}
+	e.WithConditions(conditions)
+
+	if ep.Hostname != nil {
+		e.WithHostname(*ep.Hostname)
+	}
+	if ep.TargetRef != nil {
+		ref := corev1ac.ObjectReference().WithKind(ep.TargetRef.Kind).WithName(ep.TargetRef.Name)
+		if ep.TargetRef.Namespace != "" {
+			ref.WithNamespace(ep.TargetRef.Namespace)
+		}
+		if ep.TargetRef.UID != "" {
+			ref.WithUID(ep.TargetRef.UID)
+		}
+		e.WithTargetRef(ref)
+	}
+	if ep.NodeName != nil {
+		e.WithNodeName(*ep.NodeName)
+	}
+	if ep.Zone != nil {
+		e.WithZone(*ep.Zone)
+	}
+	if ep.Hints != nil {
+		hints := discoveryv1ac.EndpointHints()
+		for _, zone := range ep.Hints.ForZones {
+			hints.WithForZones(discoveryv1ac.ForZone().WithName(zone.Name))
+		}
+		e.WithHints(hints)
+	}
+	return e
+}
@@ -0,0 +1,103 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apiError "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestExplainConflict(t *testing.T) {
+	conflict := apiError.NewConflict(schema.GroupResource{Resource: "services"}, "x-global", errors.New("conflict with other-manager"))
+	tests := []struct {
+		name           string
+		force          bool
+		err            error
+		wantSuggestion bool
+	}{
+		{name: "conflict", err: conflict, wantSuggestion: true},
+		{name: "conflict when forced", force: true, err: conflict},
+		{name: "other error", err: errors.New("connection refused")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWatcher(t, Options{ForceConflicts: tt.force})
+			err := w.explainConflict(tt.err)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("explainConflict() = %v, doesn't wrap %v", err, tt.err)
+			}
+			if got := strings.Contains(err.Error(), "--force-conflicts"); got != tt.wantSuggestion {
+				t.Errorf("explainConflict() = %v, suggests --force-conflicts = %v, want %v", err, got, tt.wantSuggestion)
+			}
+		})
+	}
+}
+
+func TestReconcileApplyKeepsOthersFields(t *testing.T) {
+	others := func(objLabels map[string]string) metav1.ObjectMeta {
+		objLabels["team"] = "a"
+		return metav1.ObjectMeta{Namespace: testGlobalNamespace, Labels: objLabels, Annotations: map[string]string{"note": "kept"}}
+	}
+	globalSvc := &corev1.Service{ObjectMeta: others(map[string]string{globalMirrorLabel: "true"})}
+	globalSvc.Name = "x-global"
+	globalSvc.Spec = corev1.ServiceSpec{ClusterIP: corev1.ClusterIPNone, IPFamilies: []corev1.IPFamily{corev1.IPv4Protocol}}
+	// Global endpointslice of x-target1, someone else set the cluster label to something else.
+	globalEps := &discoveryv1.EndpointSlice{
+		ObjectMeta: others(map[string]string{
+			serviceNameLabel:                  "x-global",
+			targetMirrorSvcNameLabel:          "x-target1",
+			sourceEndpointSliceLabel:          "x-target1-abcde",
+			sourceEndpointSliceNamespaceLabel: "test",
+			clusterNameLabel:                  "target0",
+			globalMirrorLabel:                 "true",
+		}),
+		AddressType: discoveryv1.AddressTypeIPv4,
+	}
+	globalEps.Name = "x-global-abcde"
+
+	tests := []struct {
+		name        string
+		force       bool
+		wantCluster string
+	}{
+		{name: "conflict", wantCluster: "target0"},
+		{name: "forced", force: true, wantCluster: "target1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWatcher(t, Options{AutoAggregate: true, ForceConflicts: tt.force}, globalSvc.DeepCopy(), globalEps.DeepCopy(),
+				mirroredService("x", "target1", servicePort("http", 80, 8080)), mirroredEndpointSlice("x", "target1", "x-0"))
+			err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global"))
+			if tt.force && err != nil {
+				t.Fatalf("reconcileGlobalService() error = %v", err)
+			}
+			if !tt.force && (err == nil || !strings.Contains(err.Error(), "--force-conflicts")) {
+				t.Fatalf("reconcileGlobalService() error = %v, want conflict suggesting --force-conflicts", err)
+			}
+
+			ctx := context.Background()
+			gotSvc, err := w.clientset.CoreV1().Services(testGlobalNamespace).Get(ctx, "x-global", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotEps, err := w.clientset.DiscoveryV1().EndpointSlices(testGlobalNamespace).Get(ctx, "x-global-abcde", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, obj := range []metav1.ObjectMeta{gotSvc.ObjectMeta, gotEps.ObjectMeta} {
+				if obj.Labels["team"] != "a" || obj.Annotations["note"] != "kept" {
+					t.Errorf("%v lost label or annotation it didn't get from us, labels %v, annotations %v", obj.Name, obj.Labels, obj.Annotations)
+				}
+			}
+			if got := gotEps.Labels[clusterNameLabel]; got != tt.wantCluster {
+				t.Errorf("%v of global endpointslice = %v, want %v", clusterNameLabel, got, tt.wantCluster)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package watcher
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apiError "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGlobalEndpoints(t *testing.T) {
+	tests := []struct {
+		name           string
+		eps            *discoveryv1.EndpointSlice
+		wantHosts      []string
+		wantGatewayIPs int
+	}{
+		{name: "hostnames get cluster suffix", eps: mirroredEndpointSlice("x", "target1", "x-0", "x-1"), wantHosts: []string{"x-0-target1", "x-1-target1"}},
+		{name: "hyphenated cluster", eps: mirroredEndpointSlice("x", "us-east-1", "x-0"), wantHosts: []string{"x-0-us-east-1"}},
+		{name: "no endpoints", eps: mirroredEndpointSlice("x", "target1"), wantHosts: []string{}},
+		{name: "gateway ip without hostname", eps: mirroredEndpointSlice("x", "target1", ""), wantHosts: []string{""}, wantGatewayIPs: 1},
+		{name: "gateway ip among pods", eps: mirroredEndpointSlice("x", "target1", "x-0", ""), wantHosts: []string{"x-0-target1", ""}, wantGatewayIPs: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWatcher(t, Options{})
+			original := tt.eps.DeepCopy()
+
+			endpoints, gatewayIPs := w.globalEndpoints(*tt.eps, nil)
+			if gatewayIPs != tt.wantGatewayIPs {
+				t.Errorf("globalEndpoints() gateway ips = %v, want %v", gatewayIPs, tt.wantGatewayIPs)
+			}
+			if !reflect.DeepEqual(tt.eps, original) {
+				t.Errorf("globalEndpoints() modified mirrored endpointslice")
+			}
+
+			hosts := make([]string, 0, len(endpoints))
+			for i, ep := range endpoints {
+				hostname := ""
+				if ep.Hostname != nil {
+					hostname = *ep.Hostname
+				}
+				hosts = append(hosts, hostname)
+				if !reflect.DeepEqual(ep.Addresses, tt.eps.Endpoints[i].Addresses) {
+					t.Errorf("endpoint %v addresses = %v, want %v", i, ep.Addresses, tt.eps.Endpoints[i].Addresses)
+				}
+			}
+			if !reflect.DeepEqual(hosts, tt.wantHosts) {
+				t.Errorf("hostnames = %v, want %v", hosts, tt.wantHosts)
+			}
+		})
+	}
+}
+
+func TestHandleEpsAdd(t *testing.T) {
+	w := newTestWatcher(t, Options{})
+	eps := mirroredEndpointSlice("x", "target1", "x-0")
+	endpoints, _ := w.globalEndpoints(*eps, nil)
+	globalSvc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "x-global", Namespace: testGlobalNamespace, UID: "global-uid"}}
+
+	if err := w.handleEpsAdd(globalSvc, *eps, "x-target1-abcde-global-0", eps.AddressType, endpoints); err != nil {
+		t.Fatalf("handleEpsAdd() error = %v", err)
+	}
+
+	slices := globalSlicesOf(t, w, "x-target1")
+	if len(slices) != 1 {
+		t.Fatalf("created %v global endpointslices, want 1", len(slices))
+	}
+	got := slices[0]
+	if got.Name != "x-target1-abcde-global-0" {
+		t.Errorf("name = %q, want x-target1-abcde-global-0", got.Name)
+	}
+	wantLabels := map[string]string{
+		serviceNameLabel:                  "x-global",
+		targetMirrorSvcNameLabel:          "x-target1",
+		sourceEndpointSliceLabel:          "x-target1-abcde",
+		sourceEndpointSliceNamespaceLabel: "test",
+		clusterNameLabel:                  "target1",
+		globalMirrorLabel:                 "true",
+	}
+	if !reflect.DeepEqual(got.Labels, wantLabels) {
+		t.Errorf("labels = %v, want %v", got.Labels, wantLabels)
+	}
+	if !reflect.DeepEqual(got.Endpoints, endpoints) {
+		t.Errorf("endpoints = %v, want %v", got.Endpoints, endpoints)
+	}
+	if !reflect.DeepEqual(got.Ports, eps.Ports) || got.AddressType != eps.AddressType {
+		t.Errorf("ports = %v %v, want %v %v", got.Ports, got.AddressType, eps.Ports, eps.AddressType)
+	}
+	if !ownedBy(got.ObjectMeta, globalSvc) {
+		t.Errorf("owner references = %v, want controller reference to global service", got.OwnerReferences)
+	}
+}
+
+func TestHandleEpsUpdateAdoptsSlice(t *testing.T) {
+	w := newTestWatcher(t, Options{})
+	eps := mirroredEndpointSlice("x", "target1", "x-0")
+	endpoints, _ := w.globalEndpoints(*eps, nil)
+	globalSvc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "x-global", Namespace: testGlobalNamespace, UID: "global-uid"}}
+	if err := w.handleEpsAdd(globalSvc, *eps, "x-target1-abcde-global-0", eps.AddressType, endpoints); err != nil {
+		t.Fatalf("handleEpsAdd() error = %v", err)
+	}
+
+	// Global service got recreated, endpointslice has to follow the new one.
+	recreated := globalSvc.DeepCopy()
+	recreated.UID = "recreated-uid"
+	current := globalSlicesOf(t, w, "x-target1")[0]
+	if err := w.handleEpsUpdate(recreated, &current, *eps, endpoints); err != nil {
+		t.Fatalf("handleEpsUpdate() error = %v", err)
+	}
+
+	got := globalSlicesOf(t, w, "x-target1")[0]
+	if !ownedBy(got.ObjectMeta, recreated) {
+		t.Errorf("owner references = %v, want controller reference to recreated global service", got.OwnerReferences)
+	}
+}
+
+func TestHandleEpsDelete(t *testing.T) {
+	globalEps := mirroredEndpointSlice("x", "target1", "x-0")
+	globalEps.Name = "x-target1-global"
+	globalEps.Namespace = testGlobalNamespace
+
+	tests := []struct {
+		name        string
+		dryRun      bool
+		exists      bool
+		wantDeleted bool
+	}{
+		{name: "deletes global endpointslice", exists: true, wantDeleted: true},
+		{name: "already deleted", exists: false, wantDeleted: true},
+		{name: "dry run keeps it", dryRun: true, exists: true, wantDeleted: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWatcher(t, Options{DryRun: tt.dryRun})
+			if tt.exists {
+				w = newTestWatcher(t, Options{DryRun: tt.dryRun}, globalEps.DeepCopy())
+			}
+
+			if err := w.handleEpsDelete(*globalEps); err != nil {
+				t.Fatalf("handleEpsDelete() error = %v", err)
+			}
+
+			_, err := w.clientset.DiscoveryV1().EndpointSlices(testGlobalNamespace).Get(context.Background(), globalEps.Name, metav1.GetOptions{})
+			if deleted := apiError.IsNotFound(err); deleted != tt.wantDeleted {
+				t.Errorf("deleted = %v, want %v (err = %v)", deleted, tt.wantDeleted, err)
+			}
+		})
+	}
+}
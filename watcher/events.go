@@ -0,0 +1,69 @@
+package watcher
+
+import (
+	"fmt"
+
+	mirrorscheme "github.com/rushi47/service-mirror-prototype/generated/clientset/versioned/scheme"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+)
+
+// Reasons of events recorded on global services and GlobalServices.
+const (
+	reasonEndpointSliceSkipped = "EndpointSliceSkipped"
+	reasonPortConflict         = "PortConflict"
+	reasonSyncFailed           = "SyncFailed"
+	reasonGlobalServiceDeleted = "GlobalServiceDeleted"
+	reasonInvalidName          = "InvalidName"
+	reasonNameCollision        = "NameCollision"
+	reasonHostnameConflict     = "HostnameConflict"
+	reasonClusterWithdrawn     = "ClusterWithdrawn"
+	reasonInvalidFailover      = "InvalidFailover"
+	reasonFailedOver           = "FailedOver"
+	reasonFailedBack           = "FailedBack"
+	reasonTrafficSplitSkipped  = "TrafficSplitSkipped"
+	reasonServiceTypeConflict  = "ServiceTypeConflict"
+	reasonServiceRecreated     = "ServiceRecreated"
+	reasonNamespaceCollision   = "NamespaceCollision"
+)
+
+// newEventRecorder returns recorder which knows about core and GlobalService types.
+func newEventRecorder(broadcaster record.EventBroadcaster) record.EventRecorder {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(mirrorscheme.AddToScheme(scheme))
+	return broadcaster.NewRecorder(scheme, corev1.EventSource{Component: "global-mirror"})
+}
+
+// warnf logs the problem and records warning event on global service, and on GlobalService declaring it.
+// Problem is also remembered, to be reported in GlobalService status.
+func (w *Watcher) warnf(agg *aggregation, reason, messageFmt string, args ...interface{}) {
+	message := fmt.Sprintf(messageFmt, args...)
+	w.log.Warnf("Global service Name=%v/%v: %v", agg.namespace, agg.name, message)
+	agg.problems = append(agg.problems, message)
+	w.record(agg, corev1.EventTypeWarning, reason, message)
+}
+
+// eventf logs and records normal event on global service, and on GlobalService declaring it.
+func (w *Watcher) eventf(agg *aggregation, reason, messageFmt string, args ...interface{}) {
+	message := fmt.Sprintf(messageFmt, args...)
+	w.log.Infof("Global service Name=%v/%v: %v", agg.namespace, agg.name, message)
+	w.record(agg, corev1.EventTypeNormal, reason, message)
+}
+
+// record returns false when there was nothing to record the event on, i.e. global service isn't in cache yet.
+func (w *Watcher) record(agg *aggregation, eventType, reason, message string) bool {
+	recorded := false
+	if globalSvc, err := w.svcLister.Services(agg.namespace).Get(agg.name); err == nil && w.isOwnGlobalService(globalSvc) {
+		w.recorder.Event(globalSvc, eventType, reason, message)
+		recorded = true
+	}
+	if agg.globalService != nil {
+		w.recorder.Event(agg.globalService, eventType, reason, message)
+		recorded = true
+	}
+	return recorded
+}
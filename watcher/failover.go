@@ -0,0 +1,196 @@
+package watcher
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+)
+
+// Annotations on global service turning on failover mode, for global services without GlobalService.
+// Failover of GlobalService takes precedence over them.
+const (
+	// Comma separated clusters in order of priority.
+	failoverClustersAnnotation = "mirror.linkerd.io/failover-clusters"
+	// Go duration, i.e. 1m.
+	failbackDelayAnnotation = "mirror.linkerd.io/failback-delay"
+)
+
+// failover is the priority of clusters of global service in failover mode.
+type failover struct {
+	clusters      []string
+	failbackDelay time.Duration
+}
+
+// failoverState is what we remember about global service in failover mode, between reconciles.
+type failoverState struct {
+	// Cluster global service sends traffic to.
+	active string
+	// Since when clusters have had ready endpoints, for failing back.
+	healthySince map[string]time.Time
+}
+
+// priority returns the clusters in order of priority, listed ones first, the rest sorted by name.
+func (f *failover) priority(clusters []string) []string {
+	order := make([]string, 0, len(f.clusters)+len(clusters))
+	seen := make(map[string]bool)
+	for _, cluster := range f.clusters {
+		if !seen[cluster] {
+			seen[cluster] = true
+			order = append(order, cluster)
+		}
+	}
+	rest := make([]string, 0, len(clusters))
+	for _, cluster := range clusters {
+		if !seen[cluster] {
+			seen[cluster] = true
+			rest = append(rest, cluster)
+		}
+	}
+	sort.Strings(rest)
+	return append(order, rest...)
+}
+
+// failoverFor returns failover of global service, nil when its endpoints come from all the clusters.
+func (w *Watcher) failoverFor(agg *aggregation, globalSvc *corev1.Service) *failover {
+	if gs := agg.globalService; gs != nil && gs.Spec.Failover != nil {
+		f := &failover{clusters: gs.Spec.Failover.Clusters, failbackDelay: w.failbackDelay}
+		if gs.Spec.Failover.FailbackDelay != nil {
+			f.failbackDelay = gs.Spec.Failover.FailbackDelay.Duration
+		}
+		return f
+	}
+	if globalSvc == nil {
+		return nil
+	}
+	clusters, ok := globalSvc.GetAnnotations()[failoverClustersAnnotation]
+	if !ok {
+		return nil
+	}
+
+	f := &failover{failbackDelay: w.failbackDelay}
+	for _, cluster := range strings.Split(clusters, ",") {
+		if cluster = strings.TrimSpace(cluster); cluster != "" {
+			f.clusters = append(f.clusters, cluster)
+		}
+	}
+	if delay, ok := globalSvc.GetAnnotations()[failbackDelayAnnotation]; ok {
+		d, err := time.ParseDuration(delay)
+		if err != nil || d < 0 {
+			w.warnf(agg, reasonInvalidFailover, "Ignoring %v annotation %q, it isn't a duration like 1m", failbackDelayAnnotation, delay)
+		} else {
+			f.failbackDelay = d
+		}
+	}
+	return f
+}
+
+// failoverCluster returns the cluster global service sends traffic to in failover mode, the highest priority one
+// with ready endpoints. It fails over right away when the active cluster has no ready endpoints, but fails back
+// to higher priority cluster only once it has had ready endpoints for the failback delay, so it doesn't flap.
+// When no cluster has ready endpoints, the active one stays.
+func (w *Watcher) failoverCluster(agg *aggregation, f *failover, globalEps []*discoveryv1.EndpointSlice, ready map[string]int, withdrawn map[string]bool) string {
+	clusters := make([]string, 0, len(ready))
+	for cluster := range ready {
+		clusters = append(clusters, cluster)
+	}
+	order := f.priority(clusters)
+	healthy := func(cluster string) bool { return ready[cluster] > 0 && !withdrawn[cluster] }
+
+	w.failoverMu.Lock()
+	defer w.failoverMu.Unlock()
+	key := globalKey(agg.namespace, agg.name)
+	state, ok := w.failovers[key]
+	if !ok {
+		state = &failoverState{healthySince: make(map[string]time.Time)}
+		w.failovers[key] = state
+	}
+
+	now := w.clock.Now()
+	for cluster := range state.healthySince {
+		if !healthy(cluster) {
+			delete(state.healthySince, cluster)
+		}
+	}
+	for _, cluster := range order {
+		if _, ok := state.healthySince[cluster]; !ok && healthy(cluster) {
+			state.healthySince[cluster] = now
+		}
+	}
+
+	current := state.active
+	if current == "" {
+		// Restarted, global endpointslices only exist for the cluster which was active.
+		for _, eps := range globalEps {
+			if cluster := eps.GetLabels()[clusterNameLabel]; current == "" || rank(order, cluster) < rank(order, current) {
+				current = cluster
+			}
+		}
+	}
+
+	next := current
+	for _, cluster := range order {
+		if cluster == current && healthy(cluster) {
+			break
+		}
+		if !healthy(cluster) {
+			continue
+		}
+		if current == "" || !healthy(current) {
+			next = cluster
+			break
+		}
+		// Higher priority cluster than the active one.
+		if wait := f.failbackDelay - now.Sub(state.healthySince[cluster]); wait > 0 {
+			if agg.requeueAfter == 0 || wait < agg.requeueAfter {
+				agg.requeueAfter = wait
+			}
+			continue
+		}
+		next = cluster
+		break
+	}
+
+	if next != current && current != "" {
+		failoverSwitchesTotal.WithLabelValues(agg.namespace, agg.name, current, next).Inc()
+		if rank(order, next) < rank(order, current) {
+			w.eventf(agg, reasonFailedBack, "Failed back from cluster %v to %v, which has had ready endpoints for %v", current, next, f.failbackDelay)
+		} else {
+			w.eventf(agg, reasonFailedOver, "Failed over from cluster %v to %v, as %v has too few ready endpoints", current, next, current)
+		}
+	}
+	state.active = next
+	return next
+}
+
+// rank is position of the cluster in priority order.
+func rank(order []string, cluster string) int {
+	for i, c := range order {
+		if c == cluster {
+			return i
+		}
+	}
+	return len(order)
+}
+
+// forgetFailover drops what we remember about global service in failover mode, by its key.
+func (w *Watcher) forgetFailover(key string) {
+	w.failoverMu.Lock()
+	defer w.failoverMu.Unlock()
+	delete(w.failovers, key)
+}
+
+// activeClusters returns the active cluster of global services in failover mode, by their key.
+func (w *Watcher) activeClusters() map[string]string {
+	w.failoverMu.Lock()
+	defer w.failoverMu.Unlock()
+	active := make(map[string]string, len(w.failovers))
+	for key, state := range w.failovers {
+		if state.active != "" {
+			active[key] = state.active
+		}
+	}
+	return active
+}
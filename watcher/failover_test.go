@@ -0,0 +1,176 @@
+package watcher
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	mirrorv1alpha1 "github.com/rushi47/service-mirror-prototype/apis/mirror/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func TestFailoverPriority(t *testing.T) {
+	tests := []struct {
+		name     string
+		listed   []string
+		clusters []string
+		want     []string
+	}{
+		{name: "listed order wins over names", listed: []string{"target2", "target1"}, clusters: []string{"target1", "target2"}, want: []string{"target2", "target1"}},
+		{name: "unlisted come last sorted", listed: []string{"target2"}, clusters: []string{"target3", "target1", "target2"}, want: []string{"target2", "target1", "target3"}},
+		{name: "listed without endpoints stay", listed: []string{"target3", "target1"}, clusters: []string{"target1"}, want: []string{"target3", "target1"}},
+		{name: "duplicates", listed: []string{"target1", "target1"}, clusters: []string{"target1"}, want: []string{"target1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &failover{clusters: tt.listed}
+			if got := f.priority(tt.clusters); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("priority() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileFailover(t *testing.T) {
+	tests := []struct {
+		name          string
+		failbackDelay time.Duration
+		// Cluster global service sends traffic to, once target2 recovered.
+		wantRecovered string
+		wantEvent     string
+	}{
+		{name: "fails back right away", wantRecovered: "target2", wantEvent: reasonFailedBack},
+		{name: "waits for failback delay", failbackDelay: time.Hour, wantRecovered: "target1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs := &mirrorv1alpha1.GlobalService{
+				ObjectMeta: metav1.ObjectMeta{Name: "x", Namespace: testGlobalNamespace},
+				Spec: mirrorv1alpha1.GlobalServiceSpec{Service: "x", Failover: &mirrorv1alpha1.Failover{
+					Clusters:      []string{"target2", "target1"},
+					FailbackDelay: &metav1.Duration{Duration: tt.failbackDelay},
+				}},
+			}
+			w := newTestWatcher(t, Options{}, gs,
+				mirroredService("x", "target1", servicePort("http", 80, 8080)),
+				mirroredService("x", "target2", servicePort("http", 80, 8080)),
+				mirroredEndpointSlice("x", "target1", "x-0"),
+				mirroredEndpointSlice("x", "target2", "x-0"))
+			reconcile := func() {
+				t.Helper()
+				if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+					t.Fatalf("reconcileGlobalService() error = %v", err)
+				}
+				syncCache(t, w)
+			}
+
+			reconcile()
+			if got := clustersWithSlices(t, w, "x", "target1", "target2"); !reflect.DeepEqual(got, []string{"target2"}) {
+				t.Fatalf("clusters with global endpointslices = %v, want only target2", got)
+			}
+
+			applyObject(t, w.clientset, withReadiness(mirroredEndpointSlice("x", "target2", "x-0"), false))
+			syncCache(t, w)
+			reconcile()
+			if got := clustersWithSlices(t, w, "x", "target1", "target2"); !reflect.DeepEqual(got, []string{"target1"}) {
+				t.Fatalf("clusters with global endpointslices = %v, want to fail over to target1", got)
+			}
+			if !hasEvent(w, reasonFailedOver) {
+				t.Errorf("no %v event recorded", reasonFailedOver)
+			}
+
+			applyObject(t, w.clientset, mirroredEndpointSlice("x", "target2", "x-0"))
+			syncCache(t, w)
+			reconcile()
+			if got := clustersWithSlices(t, w, "x", "target1", "target2"); !reflect.DeepEqual(got, []string{tt.wantRecovered}) {
+				t.Errorf("clusters with global endpointslices = %v, want %v", got, tt.wantRecovered)
+			}
+			if tt.wantEvent != "" && !hasEvent(w, tt.wantEvent) {
+				t.Errorf("no %v event recorded", tt.wantEvent)
+			}
+			if got := w.activeClusters()[globalKey(testGlobalNamespace, "x-global")]; got != tt.wantRecovered {
+				t.Errorf("active cluster = %v, want %v", got, tt.wantRecovered)
+			}
+		})
+	}
+}
+
+func TestReconcileFailbackDelay(t *testing.T) {
+	gs := &mirrorv1alpha1.GlobalService{
+		ObjectMeta: metav1.ObjectMeta{Name: "x", Namespace: testGlobalNamespace},
+		Spec: mirrorv1alpha1.GlobalServiceSpec{Service: "x", Failover: &mirrorv1alpha1.Failover{
+			Clusters:      []string{"target2", "target1"},
+			FailbackDelay: &metav1.Duration{Duration: time.Minute},
+		}},
+	}
+	clock := testingclock.NewFakeClock(time.Now())
+	w := newTestWatcher(t, Options{Clock: clock}, gs,
+		mirroredService("x", "target1", servicePort("http", 80, 8080)),
+		mirroredService("x", "target2", servicePort("http", 80, 8080)),
+		mirroredEndpointSlice("x", "target1", "x-0"),
+		withReadiness(mirroredEndpointSlice("x", "target2", "x-0"), false))
+	requeues := recordRequeues(w)
+	reconcile := func() {
+		t.Helper()
+		syncCache(t, w)
+		if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+			t.Fatalf("reconcileGlobalService() error = %v", err)
+		}
+	}
+
+	reconcile()
+	applyObject(t, w.clientset, mirroredEndpointSlice("x", "target2", "x-0"))
+	reconcile()
+	clock.Step(30 * time.Second)
+	reconcile()
+	if got := w.activeClusters()[globalKey(testGlobalNamespace, "x-global")]; got != "target1" {
+		t.Errorf("active cluster = %v halfway through failback delay, want target1", got)
+	}
+	// Reconciled again once the rest of the delay is up.
+	if got := requeues.requeued()[globalKey(testGlobalNamespace, "x-global")]; got != 30*time.Second {
+		t.Errorf("requeued after %v, want 30s", got)
+	}
+
+	clock.Step(30 * time.Second)
+	reconcile()
+	if got := w.activeClusters()[globalKey(testGlobalNamespace, "x-global")]; got != "target2" {
+		t.Errorf("active cluster = %v once failback delay is up, want target2", got)
+	}
+	if !hasEvent(w, reasonFailedBack) {
+		t.Errorf("no %v event recorded", reasonFailedBack)
+	}
+}
+
+func TestReconcileFailoverAnnotation(t *testing.T) {
+	w := newTestWatcher(t, Options{AutoAggregate: true, FailbackDelay: time.Hour},
+		mirroredService("x", "target1", servicePort("http", 80, 8080)),
+		mirroredService("x", "target2", servicePort("http", 80, 8080)),
+		mirroredEndpointSlice("x", "target1", "x-0"),
+		mirroredEndpointSlice("x", "target2", "x-0"))
+	if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+		t.Fatalf("reconcileGlobalService() error = %v", err)
+	}
+	syncCache(t, w)
+	if got := clustersWithSlices(t, w, "x", "target1", "target2"); !reflect.DeepEqual(got, []string{"target1", "target2"}) {
+		t.Fatalf("clusters with global endpointslices = %v, want both without failover", got)
+	}
+
+	// Turning failover on keeps the highest priority cluster which already has global endpointslices,
+	// without waiting for failback delay.
+	globalSvc, err := w.clientset.CoreV1().Services(testGlobalNamespace).Get(context.Background(), "x-global", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting global service: %v", err)
+	}
+	globalSvc.Annotations = map[string]string{failoverClustersAnnotation: "target2, target1"}
+	applyObject(t, w.clientset, globalSvc)
+	syncCache(t, w)
+	if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+		t.Fatalf("reconcileGlobalService() error = %v", err)
+	}
+	syncCache(t, w)
+	if got := clustersWithSlices(t, w, "x", "target1", "target2"); !reflect.DeepEqual(got, []string{"target2"}) {
+		t.Errorf("clusters with global endpointslices = %v, want only target2", got)
+	}
+}
@@ -0,0 +1,92 @@
+package watcher
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apiError "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// Finalizer on global services, keeps them around until cleanup hooks have run.
+const globalServiceFinalizer = "mirror.linkerd.io/global-mirror-cleanup"
+
+// CleanupHook runs before global service goes away, i.e. to notify source clusters.
+// Global service is only removed once every hook succeeds, failed hooks are retried.
+type CleanupHook func(globalSvc *corev1.Service) error
+
+// globalServiceOwner returns owner reference pointing to global service, set on all of its global endpointslices
+// so they get garbage collected along with it.
+func globalServiceOwner(globalSvc *corev1.Service) metav1.OwnerReference {
+	return *metav1.NewControllerRef(globalSvc, corev1.SchemeGroupVersion.WithKind("Service"))
+}
+
+// ownedBy reports if object is controlled by global service.
+func ownedBy(obj metav1.ObjectMeta, globalSvc *corev1.Service) bool {
+	owner := metav1.GetControllerOfNoCopy(&obj)
+	return owner != nil && owner.UID == globalSvc.UID && owner.Kind == "Service" && owner.Name == globalSvc.Name
+}
+
+func hasFinalizer(obj metav1.ObjectMeta) bool {
+	for _, f := range obj.Finalizers {
+		if f == globalServiceFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// finalizeGlobalService is called once global service is being deleted. Global endpointslices are deleted first,
+// without waiting for garbage collector, then cleanup hooks run and at last finalizer is removed.
+func (svcW *Watcher) finalizeGlobalService(agg *aggregation, globalSvc *corev1.Service) error {
+	if !hasFinalizer(globalSvc.ObjectMeta) {
+		return nil
+	}
+	svcW.log.Infof("Global service Name=%v is being deleted, cleaning up", globalSvc.Name)
+
+	globalEps, err := svcW.globalEndpointSlices(globalSvc.Namespace, globalSvc.Name)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, eps := range globalEps {
+		errs = append(errs, runHandler(handlerEpsDelete, func() error { return svcW.handleEpsDelete(*eps) }))
+	}
+	if err := utilerrors.NewAggregate(errs); err != nil {
+		return err
+	}
+
+	for _, hook := range svcW.cleanupHooks {
+		if err := hook(globalSvc); err != nil {
+			return fmt.Errorf("cleanup of global service Name=%v failed: %w", globalSvc.Name, err)
+		}
+	}
+
+	// Finalizer is removed even in dry-run, global service is already being deleted and would otherwise be stuck
+	// terminating. Global endpointslices left behind go along with it, as they are owned by it.
+	// Applying it without the finalizer gives up our ownership of it, so it is removed.
+	headless := globalSvc.Spec.ClusterIP == corev1.ClusterIPNone
+	// Resource version keeps apply from creating it again, if it's already gone.
+	apply := svcW.globalServiceApply(globalSvc.Namespace, globalSvc.Name, globalSvc.Spec.Ports, headless, nil, false).WithResourceVersion(globalSvc.ResourceVersion)
+	_, err = svcW.clientset.CoreV1().Services(globalSvc.Namespace).Apply(svcW.workCtx, apply, svcW.applyOptions())
+	if apiError.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		countAPIError("apply", "services")
+		return fmt.Errorf("unable to remove finalizer of global service Name=%v: %w", globalSvc.Name, svcW.explainConflict(err))
+	}
+
+	if agg != nil && agg.globalService != nil {
+		svcW.recorder.Eventf(agg.globalService, corev1.EventTypeNormal, reasonGlobalServiceDeleted, "Global service %v was deleted", globalSvc.Name)
+	}
+	svcW.log.Infof("Global service Name=%v cleaned up", globalSvc.Name)
+	return nil
+}
+
+// recordDeletion is the default cleanup hook, it leaves event behind for the global service.
+func (svcW *Watcher) recordDeletion(globalSvc *corev1.Service) error {
+	svcW.recorder.Eventf(globalSvc, corev1.EventTypeNormal, reasonGlobalServiceDeleted, "Global service %v is being deleted, global endpointslices removed", globalSvc.Name)
+	return nil
+}
@@ -0,0 +1,82 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apiError "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestFinalizeGlobalService(t *testing.T) {
+	now := metav1.Now()
+	deleting := &corev1.Service{ObjectMeta: metav1.ObjectMeta{
+		Name:              "x-global",
+		Namespace:         testGlobalNamespace,
+		UID:               "global-uid",
+		Labels:            map[string]string{globalMirrorLabel: "true"},
+		Finalizers:        []string{globalServiceFinalizer},
+		DeletionTimestamp: &now,
+	}}
+	globalEps := mirroredEndpointSlice("x", "target1", "x-0-target1")
+	globalEps.Name = "x-target1-global"
+	globalEps.Namespace = testGlobalNamespace
+	globalEps.Labels = map[string]string{serviceNameLabel: "x-global", globalMirrorLabel: "true"}
+
+	tests := []struct {
+		name          string
+		hook          CleanupHook
+		dryRun        bool
+		wantErr       bool
+		wantFinalizer bool
+	}{
+		{name: "cleans up and removes finalizer"},
+		{name: "dry-run still removes finalizer", dryRun: true},
+		{name: "failed hook keeps finalizer", hook: func(*corev1.Service) error { return errors.New("source cluster unreachable") }, wantErr: true, wantFinalizer: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := Options{AutoAggregate: true, DryRun: tt.dryRun}
+			if tt.hook != nil {
+				opts.CleanupHooks = []CleanupHook{tt.hook}
+			}
+			// Still mirrored, global service was deleted by someone else.
+			w := newTestWatcher(t, opts, deleting.DeepCopy(), globalEps.DeepCopy(),
+				mirroredService("x", "target1", servicePort("http", 80, 8080)), mirroredEndpointSlice("x", "target1", "x-0"))
+
+			err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("reconcileGlobalService() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			ctx := context.Background()
+			_, err = w.clientset.DiscoveryV1().EndpointSlices(testGlobalNamespace).Get(ctx, globalEps.Name, metav1.GetOptions{})
+			switch {
+			case tt.dryRun && err != nil:
+				// Left to garbage collector, along with global service.
+				t.Errorf("global endpointslice deleted in dry-run: %v", err)
+			case !tt.dryRun && !apiError.IsNotFound(err):
+				t.Errorf("global endpointslice not deleted before cleanup hooks: %v", err)
+			}
+			svc, err := w.clientset.CoreV1().Services(testGlobalNamespace).Get(ctx, "x-global", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("getting global service: %v", err)
+			}
+			if got := hasFinalizer(svc.ObjectMeta); got != tt.wantFinalizer {
+				t.Errorf("has finalizer = %v, want %v", got, tt.wantFinalizer)
+			}
+
+			if tt.wantFinalizer {
+				return
+			}
+			select {
+			case <-w.recorder.(*record.FakeRecorder).Events:
+			default:
+				t.Errorf("no %v event recorded", reasonGlobalServiceDeleted)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package watcher
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+)
+
+// GatewayIPPolicy is what happens to mirrored endpointslices having endpoints without hostname. Linkerd puts the
+// gateway IP there for a while, i.e. after port of the service is changed in target cluster.
+type GatewayIPPolicy string
+
+const (
+	// GatewayIPKeepLastKnown leaves global endpointslices as they were before gateway IP showed up.
+	GatewayIPKeepLastKnown GatewayIPPolicy = "keep-last-known"
+	// GatewayIPSkipEndpoints leaves out only the endpoints without hostname.
+	GatewayIPSkipEndpoints GatewayIPPolicy = "skip-endpoints"
+	// GatewayIPIncludeGateway keeps gateway endpoints without hostname, so global service still reaches
+	// the cluster through its gateway, just not the per-pod DNS names.
+	GatewayIPIncludeGateway GatewayIPPolicy = "include-gateway"
+)
+
+// ParseGatewayIPPolicy returns the policy, empty string being keep-last-known.
+func ParseGatewayIPPolicy(policy string) (GatewayIPPolicy, error) {
+	switch GatewayIPPolicy(policy) {
+	case "", GatewayIPKeepLastKnown:
+		return GatewayIPKeepLastKnown, nil
+	case GatewayIPSkipEndpoints, GatewayIPIncludeGateway:
+		return GatewayIPPolicy(policy), nil
+	}
+	return "", fmt.Errorf("unknown gateway IP policy %q, use one of %v, %v or %v", policy, GatewayIPKeepLastKnown, GatewayIPSkipEndpoints, GatewayIPIncludeGateway)
+}
+
+// clusterIPMirrors returns namespace/name of mirrors of ClusterIP services. Linkerd gives them the gateway IP as their
+// only endpoint, which unlike the one of headless mirrors doesn't go away.
+func clusterIPMirrors(targetSvcs []*corev1.Service) map[string]bool {
+	mirrors := make(map[string]bool)
+	for _, svc := range targetSvcs {
+		if svc.Spec.ClusterIP != corev1.ClusterIPNone {
+			mirrors[svc.Namespace+"/"+svc.Name] = true
+		}
+	}
+	return mirrors
+}
+
+// hasGatewayIPs reports if mirrored endpointslice has endpoints without hostname.
+func hasGatewayIPs(eps *discoveryv1.EndpointSlice) bool {
+	for _, ep := range eps.Endpoints {
+		if ep.Hostname == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// mirrorOf returns namespace/name of mirrored service of the mirrored endpointslice.
+func mirrorOf(eps *discoveryv1.EndpointSlice) string {
+	return eps.Namespace + "/" + eps.GetLabels()[serviceNameLabel]
+}
+
+// handleGatewayIPs applies gateway IP policy to endpoints of mirrored endpointslice, some of which don't have hostname.
+// Returns false when global endpointslices of it should be left as they are. Endpointslices of ClusterIP mirrors
+// aren't going to recover, so they get the policy without being retried or reported.
+func (w *Watcher) handleGatewayIPs(agg *aggregation, eps *discoveryv1.EndpointSlice, endpoints []discoveryv1.Endpoint, gatewayIPs int, clusterIPMirror bool) ([]discoveryv1.Endpoint, bool) {
+	report := func(messageFmt string, args ...interface{}) {
+		if clusterIPMirror {
+			w.log.Debugf("Global service Name=%v/%v: %v", agg.namespace, agg.name, fmt.Sprintf(messageFmt, args...))
+			return
+		}
+		w.warnf(agg, reasonEndpointSliceSkipped, messageFmt, args...)
+	}
+	skipped := func() {
+		if !clusterIPMirror {
+			gatewayIPSkipsTotal.WithLabelValues(agg.namespace, agg.name, eps.GetLabels()[clusterNameLabel]).Inc()
+		}
+	}
+	if !clusterIPMirror {
+		// Mirrored endpointslice is expected to recover, without any event telling us. Shorter wait, i.e. of
+		// failback, stays.
+		if agg.requeueAfter == 0 || w.gatewayIPRetryPeriod < agg.requeueAfter {
+			agg.requeueAfter = w.gatewayIPRetryPeriod
+		}
+	}
+
+	switch w.gatewayIPPolicy {
+	case GatewayIPSkipEndpoints:
+		skipped()
+		report("EndpointSlice %v/%v: %v endpoints without hostname (gateway IP) skipped", eps.Namespace, eps.Name, gatewayIPs)
+		withHostname := make([]discoveryv1.Endpoint, 0, len(endpoints)-gatewayIPs)
+		for _, ep := range endpoints {
+			if ep.Hostname != nil {
+				withHostname = append(withHostname, ep)
+			}
+		}
+		return withHostname, true
+	case GatewayIPIncludeGateway:
+		report("EndpointSlice %v/%v: %v endpoints without hostname (gateway IP) included without hostname", eps.Namespace, eps.Name, gatewayIPs)
+		return endpoints, true
+	}
+	skipped()
+	report("EndpointSlice %v/%v skipped: missing hostname (gateway IP), keeping last known endpoints", eps.Namespace, eps.Name)
+	return nil, false
+}
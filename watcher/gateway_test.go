@@ -0,0 +1,157 @@
+package watcher
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	mirrorv1alpha1 "github.com/rushi47/service-mirror-prototype/apis/mirror/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseGatewayIPPolicy(t *testing.T) {
+	tests := []struct {
+		policy  string
+		want    GatewayIPPolicy
+		wantErr bool
+	}{
+		{policy: "", want: GatewayIPKeepLastKnown},
+		{policy: "keep-last-known", want: GatewayIPKeepLastKnown},
+		{policy: "skip-endpoints", want: GatewayIPSkipEndpoints},
+		{policy: "include-gateway", want: GatewayIPIncludeGateway},
+		{policy: "drop", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			got, err := ParseGatewayIPPolicy(tt.policy)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ParseGatewayIPPolicy(%q) = %q, %v, want %q, wantErr %v", tt.policy, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReconcileGatewayIPPolicy(t *testing.T) {
+	tests := []struct {
+		policy GatewayIPPolicy
+		// Hostnames in global endpointslices once x-1 got gateway ip, endpoints without one are "".
+		want []string
+		// Whether gateway endpoints were counted as skipped.
+		wantSkipped bool
+	}{
+		{policy: GatewayIPKeepLastKnown, want: []string{"x-0-target1", "x-1-target1"}, wantSkipped: true},
+		{policy: GatewayIPSkipEndpoints, want: []string{"x-0-target1"}, wantSkipped: true},
+		{policy: GatewayIPIncludeGateway, want: []string{"", "x-0-target1"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			w := newTestWatcher(t, Options{AutoAggregate: true, GatewayIPPolicy: tt.policy, GatewayIPRetryPeriod: time.Minute},
+				mirroredService("x", "target1", servicePort("http", 80, 8080)), mirroredEndpointSlice("x", "target1", "x-0", "x-1"))
+			requeues := recordRequeues(w)
+			if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+				t.Fatalf("reconcileGlobalService() error = %v", err)
+			}
+			if requeued := requeues.requeued(); len(requeued) != 0 {
+				t.Fatalf("requeued %v, want none", requeued)
+			}
+			skips := testutil.ToFloat64(gatewayIPSkipsTotal.WithLabelValues(testGlobalNamespace, "x-global", "target1"))
+
+			applyObject(t, w.clientset, mirroredEndpointSlice("x", "target1", "x-0", ""))
+			syncCache(t, w)
+			if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+				t.Fatalf("reconcileGlobalService() error = %v", err)
+			}
+
+			got := make([]string, 0)
+			for _, eps := range globalSlicesOf(t, w, "x-target1") {
+				for _, ep := range eps.Endpoints {
+					hostname := ""
+					if ep.Hostname != nil {
+						hostname = *ep.Hostname
+					}
+					got = append(got, hostname)
+				}
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("hostnames = %v, want %v", got, tt.want)
+			}
+
+			if got := testutil.ToFloat64(gatewayIPSkipsTotal.WithLabelValues(testGlobalNamespace, "x-global", "target1")) > skips; got != tt.wantSkipped {
+				t.Errorf("gateway IP skips counted = %v, want %v", got, tt.wantSkipped)
+			}
+
+			// Requeued, to pick up mirrored endpointslice once it recovers.
+			want := map[interface{}]time.Duration{globalKey(testGlobalNamespace, "x-global"): time.Minute}
+			if requeued := requeues.requeued(); !reflect.DeepEqual(requeued, want) {
+				t.Errorf("requeued %v, want %v", requeued, want)
+			}
+		})
+	}
+}
+
+func TestHandleGatewayIPsRequeue(t *testing.T) {
+	tests := []struct {
+		name            string
+		clusterIPMirror bool
+		requeueAfter    time.Duration
+		want            time.Duration
+		wantProblem     bool
+	}{
+		{name: "retried", want: time.Minute, wantProblem: true},
+		{name: "shorter failback wait stays", requeueAfter: time.Second, want: time.Second, wantProblem: true},
+		{name: "longer wait is cut", requeueAfter: time.Hour, want: time.Minute, wantProblem: true},
+		{name: "ClusterIP mirror doesn't recover", clusterIPMirror: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWatcher(t, Options{GatewayIPRetryPeriod: time.Minute})
+			agg := &aggregation{name: "x-global", endpoints: make(map[string]int), requeueAfter: tt.requeueAfter}
+			eps := mirroredEndpointSlice("x", "target1", "")
+			endpoints, gatewayIPs := w.globalEndpoints(*eps, nil)
+			if _, ok := w.handleGatewayIPs(agg, eps, endpoints, gatewayIPs, tt.clusterIPMirror); ok {
+				t.Errorf("handleGatewayIPs() = true, want last known endpoints kept")
+			}
+			if agg.requeueAfter != tt.want {
+				t.Errorf("requeueAfter = %v, want %v", agg.requeueAfter, tt.want)
+			}
+			if got := len(agg.problems) > 0; got != tt.wantProblem {
+				t.Errorf("problems = %v, want reported %v", agg.problems, tt.wantProblem)
+			}
+		})
+	}
+}
+
+func TestGatewayIPKeepLastKnownStatus(t *testing.T) {
+	gs := &mirrorv1alpha1.GlobalService{
+		ObjectMeta: metav1.ObjectMeta{Name: "x", Namespace: testGlobalNamespace},
+		Spec:       mirrorv1alpha1.GlobalServiceSpec{Service: "x"},
+	}
+	w := newTestWatcher(t, Options{GatewayIPPolicy: GatewayIPKeepLastKnown, GatewayIPRetryPeriod: time.Minute}, gs,
+		mirroredService("x", "target1", servicePort("http", 80, 8080)), mirroredEndpointSlice("x", "target1", "x-0", "x-1"))
+	if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+		t.Fatalf("reconcileGlobalService() error = %v", err)
+	}
+	syncCache(t, w)
+
+	applyObject(t, w.clientset, mirroredEndpointSlice("x", "target1", "x-0", ""))
+	syncCache(t, w)
+	if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+		t.Fatalf("reconcileGlobalService() error = %v", err)
+	}
+
+	// Last known endpoints are still in global endpointslices, so they are counted.
+	got, err := w.mirrorClient.MirrorV1alpha1().GlobalServices(testGlobalNamespace).Get(context.Background(), "x", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting GlobalService: %v", err)
+	}
+	if got.Status.EndpointCount != 2 {
+		t.Errorf("endpointCount = %v, want 2 last known endpoints", got.Status.EndpointCount)
+	}
+	if !reflect.DeepEqual(got.Status.ClustersContributing, []string{"target1"}) {
+		t.Errorf("clustersContributing = %v, want [target1]", got.Status.ClustersContributing)
+	}
+}
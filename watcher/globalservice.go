@@ -0,0 +1,269 @@
+package watcher
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	mirrorv1alpha1 "github.com/rushi47/service-mirror-prototype/apis/mirror/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/cache"
+)
+
+// aggregation describes which mirrored services make up a global service and how global service looks like.
+// It comes either from a GlobalService or, when automatic aggregation is enabled, from the defaults.
+type aggregation struct {
+	// Namespace and name of the global service.
+	namespace string
+	name      string
+	// Logical name of the mirrored services, x for x-target1, x-target2.
+	service string
+	// Ports of global service, when empty ports of all mirrored services are merged.
+	ports []corev1.ServicePort
+	// Type declared by GlobalService, empty when it's up to mirrored services or the default.
+	serviceType mirrorv1alpha1.ServiceType
+	// Decided on every reconcile, from serviceType, annotations of mirrored services and the default.
+	headless bool
+	// IP families of global service, decided on every reconcile from mirrored services and their endpoints.
+	ipFamilies []corev1.IPFamily
+	// GlobalService declaring this aggregation, nil for automatic aggregation.
+	globalService *mirrorv1alpha1.GlobalService
+	// Newer GlobalServices generating the same global service name, which are ignored.
+	ignored []*mirrorv1alpha1.GlobalService
+
+	// What reconcile observed, reported in GlobalService status.
+	mirroredServices int
+	// Endpoints aggregated per target cluster.
+	endpoints map[string]int
+	// Problems with aggregation, which are also recorded as events.
+	problems []string
+	// Reconcile again after this long, even without any event.
+	requeueAfter time.Duration
+}
+
+// includesCluster reports if mirrored services from the target cluster are part of aggregation.
+func (a *aggregation) includesCluster(cluster string) bool {
+	if a.globalService == nil {
+		return true
+	}
+	return a.globalService.IncludesCluster(cluster)
+}
+
+// listGlobalServices returns GlobalServices declaring global services in the namespace.
+func (w *Watcher) listGlobalServices(namespace string) []*mirrorv1alpha1.GlobalService {
+	gss, err := w.gsLister.GlobalServices(namespace).List(labels.Everything())
+	if err != nil {
+		// Lister only reads from the cache, this doesn't really happen.
+		w.log.Errorf("Unable to list GlobalServices from cache: %v", err)
+		return nil
+	}
+	return gss
+}
+
+// globalServiceKeys returns keys of all the global services, mirrored service from target cluster is part of.
+func (w *Watcher) globalServiceKeys(namespace, targetSvcName, targetClusterName string) []string {
+	service := w.serviceName(targetSvcName, targetClusterName)
+	globalNamespace := w.globalNamespace(namespace)
+
+	keys := make([]string, 0)
+	declared := false
+	for _, gs := range w.listGlobalServices(globalNamespace) {
+		if gs.Spec.Service != service {
+			continue
+		}
+		declared = true
+		if gs.IncludesCluster(targetClusterName) {
+			keys = append(keys, globalKey(globalNamespace, w.globalServiceNameOf(gs)))
+		}
+	}
+
+	// Fallback to aggregating everything, only for services nobody declared.
+	if !declared && w.autoAggregate {
+		if name := w.autoGlobalName(service); name != "" {
+			keys = append(keys, globalKey(globalNamespace, name))
+		}
+	}
+	return keys
+}
+
+// olderGlobalService reports if a was created before b. Creation timestamps only go by the second, ones created
+// in the same second are ordered by namespace and name.
+func olderGlobalService(a, b *mirrorv1alpha1.GlobalService) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	return globalKey(a.Namespace, a.Name) < globalKey(b.Namespace, b.Name)
+}
+
+// aggregationFor returns how the global service should be aggregated, nil if it shouldn't exist.
+// Error means global service can't be created under this name, because it's invalid, names of
+// different services collide or Service which isn't ours has it. Aggregation is still returned when GlobalService declares it, for reporting.
+func (w *Watcher) aggregationFor(namespace, globalSvcName string) (*aggregation, error) {
+	if !w.managesNamespace(namespace) {
+		return nil, nil
+	}
+	gss := w.listGlobalServices(namespace)
+
+	var owner *mirrorv1alpha1.GlobalService
+	var ignored []*mirrorv1alpha1.GlobalService
+	for _, gs := range gss {
+		if w.globalServiceNameOf(gs) != globalSvcName {
+			continue
+		}
+		if owner != nil {
+			// Oldest one wins, so the global service doesn't flip between them.
+			if olderGlobalService(gs, owner) {
+				owner, gs = gs, owner
+			}
+			ignored = append(ignored, gs)
+			continue
+		}
+		owner = gs
+	}
+	sort.Slice(ignored, func(i, j int) bool { return olderGlobalService(ignored[i], ignored[j]) })
+
+	var services []string
+	if w.autoAggregate {
+		services = w.autoAggregatedServices(namespace, globalSvcName, gss)
+	}
+
+	if owner != nil {
+		agg := &aggregation{
+			namespace:     namespace,
+			name:          globalSvcName,
+			service:       owner.Spec.Service,
+			serviceType:   owner.Spec.Type,
+			globalService: owner,
+			ignored:       ignored,
+			endpoints:     make(map[string]int),
+		}
+		for _, port := range owner.Spec.Ports {
+			// Default the same way apiserver does, so we don't keep on updating global service.
+			if port.Protocol == "" {
+				port.Protocol = corev1.ProtocolTCP
+			}
+			if port.TargetPort.IntValue() == 0 && port.TargetPort.Type == intstr.Int {
+				port.TargetPort = intstr.FromInt(int(port.Port))
+			}
+			// Global services don't get node ports, globalServiceApply leaves them out.
+			port.NodePort = 0
+			agg.ports = append(agg.ports, port)
+		}
+		// Same order and names as ports of mirrored services get, so several unnamed ports make a valid service.
+		sortServicePorts(agg.ports)
+		nameServicePorts(agg.ports)
+		if err := validateGlobalName(globalSvcName); err != nil {
+			return agg, err
+		}
+		if err := w.checkNameTaken(namespace, globalSvcName); err != nil {
+			return agg, err
+		}
+		if len(services) > 0 {
+			// GlobalService wins, automatically aggregated services are left out.
+			w.warnf(agg, reasonNameCollision, "Global service name collides with automatically aggregated %v, which are left out", strings.Join(services, ", "))
+		}
+		for _, gs := range ignored {
+			w.warnf(agg, reasonNameCollision, "Global service name collides with GlobalService %v, which is ignored", gs.Name)
+		}
+		return agg, nil
+	}
+
+	switch {
+	case len(services) == 0:
+		return nil, nil
+	case len(services) > 1:
+		return nil, fmt.Errorf("services %v would all be aggregated into global service %v", strings.Join(services, ", "), globalSvcName)
+	}
+	if err := validateGlobalName(globalSvcName); err != nil {
+		return nil, err
+	}
+	if err := w.checkNameTaken(namespace, globalSvcName); err != nil {
+		return nil, err
+	}
+	return &aggregation{
+		namespace: namespace,
+		name:      globalSvcName,
+		service:   services[0],
+		endpoints: make(map[string]int),
+	}, nil
+}
+
+// reportIgnored reports GlobalServices left out of aggregation as an older one generates the same global service,
+// on their own events and status. They are reconciled again once the older one is gone.
+func (w *Watcher) reportIgnored(agg *aggregation) error {
+	var errs []error
+	for _, gs := range agg.ignored {
+		namingErrorsTotal.WithLabelValues(agg.namespace, agg.name).Inc()
+		err := fmt.Errorf("GlobalService %v, which is older, generates global service %v too", agg.globalService.Name, agg.name)
+		w.log.Warnf("GlobalService %v/%v: Not reconciling: %v", gs.Namespace, gs.Name, err)
+		w.recorder.Eventf(gs, corev1.EventTypeWarning, reasonNameCollision, "Not reconciling: %v", err)
+		ignored := &aggregation{
+			namespace:     agg.namespace,
+			name:          agg.name,
+			service:       gs.Spec.Service,
+			globalService: gs,
+			endpoints:     make(map[string]int),
+			problems:      []string{fmt.Sprintf("Not reconciling: %v", err)},
+		}
+		errs = append(errs, w.updateGlobalServiceStatus(ignored, err))
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// enqueueGlobalService queues the global service generated by the GlobalService.
+func (w *Watcher) enqueueGlobalService(gs *mirrorv1alpha1.GlobalService) {
+	if name := w.globalServiceNameOf(gs); name != "" {
+		w.queue.Add(globalKey(gs.Namespace, name))
+	}
+	if name := w.autoGlobalName(gs.Spec.Service); w.autoAggregate && name != "" {
+		// Automatically aggregated global service gets taken over, or handed back.
+		w.queue.Add(globalKey(gs.Namespace, name))
+	}
+}
+
+func (w *Watcher) registerGlobalServiceHandlers() {
+	gsInformer := w.MirrorInformersFactory.Mirror().V1alpha1().GlobalServices().Informer()
+	gsInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			gs, ok := obj.(*mirrorv1alpha1.GlobalService)
+			if !ok {
+				w.log.Errorf("Failed to cast GlobalService in Add")
+				return
+			}
+			w.enqueueGlobalService(gs)
+		},
+		UpdateFunc: func(oldobj, obj interface{}) {
+			newGs, ok := obj.(*mirrorv1alpha1.GlobalService)
+			if !ok {
+				w.log.Errorf("Failed to cast GlobalService in Update")
+				return
+			}
+			oldGs, ok := oldobj.(*mirrorv1alpha1.GlobalService)
+			if !ok {
+				w.log.Errorf("Failed to cast GlobalService in Update")
+				return
+			}
+			if newGs.ResourceVersion == oldGs.ResourceVersion {
+				return
+			}
+			// Name or service might have changed, old global service has to be cleaned up.
+			w.enqueueGlobalService(oldGs)
+			w.enqueueGlobalService(newGs)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			gs, ok := obj.(*mirrorv1alpha1.GlobalService)
+			if !ok {
+				w.log.Errorf("Failed to cast GlobalService in Delete")
+				return
+			}
+			w.enqueueGlobalService(gs)
+		},
+	})
+}
@@ -0,0 +1,131 @@
+package watcher
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/clock"
+)
+
+// health tracks what the liveness and readiness probes report.
+type health struct {
+	// Set once informer caches are synced and initial resync is done.
+	ready atomic.Bool
+	mu    sync.Mutex
+	// Informers failing to list or watch. It doesn't go by events, informers only watch mirrored and global
+	// objects and there may be none.
+	failing map[cache.SharedIndexInformer]informerFailure
+	// Keys workers are processing right now, and since when.
+	inFlight map[string]time.Time
+	// What failing informers and stuck workers are timed by.
+	clock clock.PassiveClock
+}
+
+// informerFailure is since when informer has been failing, and the resource version it had synced to by then.
+type informerFailure struct {
+	since   time.Time
+	version string
+}
+
+func (h *health) watchFailed(informer cache.SharedIndexInformer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.failing[informer]; !ok {
+		h.failing[informer] = informerFailure{since: h.clock.Now(), version: informer.LastSyncResourceVersion()}
+	}
+}
+
+// failingSince returns since when informer has been failing, zero while it works. Informer works again once it
+// synced to another resource version.
+func (h *health) failingSince(informer cache.SharedIndexInformer) time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	failure, ok := h.failing[informer]
+	if !ok {
+		return time.Time{}
+	}
+	if informer.LastSyncResourceVersion() != failure.version {
+		delete(h.failing, informer)
+		return time.Time{}
+	}
+	return failure.since
+}
+
+func (h *health) startedProcessing(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.inFlight[key] = h.clock.Now()
+}
+
+func (h *health) doneProcessing(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.inFlight, key)
+}
+
+// Readyz fails until informers are synced and the initial resync has completed.
+func (w *Watcher) Readyz() error {
+	if !w.health.ready.Load() {
+		return fmt.Errorf("informers not synced or initial resync not completed")
+	}
+	return nil
+}
+
+// Healthz fails when a worker is stuck on a key, or informers stopped or keep on failing to list and watch.
+func (w *Watcher) Healthz() error {
+	// Nothing to check before informers are up, startup is covered by readiness.
+	if !w.health.ready.Load() {
+		return nil
+	}
+
+	for _, informer := range w.informers() {
+		if informer.IsStopped() {
+			return fmt.Errorf("informer stopped")
+		}
+		// Each informer on its own, others working doesn't mean this one does.
+		if failing := w.health.failingSince(informer); !failing.IsZero() {
+			if since := w.health.clock.Since(failing); since > w.stallTimeout {
+				return fmt.Errorf("informer failing to list and watch for %v", since.Round(time.Second))
+			}
+		}
+	}
+
+	w.health.mu.Lock()
+	defer w.health.mu.Unlock()
+	for key, start := range w.health.inFlight {
+		if since := w.health.clock.Since(start); since > w.stallTimeout {
+			return fmt.Errorf("worker stuck reconciling global service %v for %v", key, since.Round(time.Second))
+		}
+	}
+	return nil
+}
+
+// watchErrorHandler marks the informer failing, on top of logging the error like informers do by default.
+func (w *Watcher) watchErrorHandler(informer cache.SharedIndexInformer) cache.WatchErrorHandler {
+	return func(r *cache.Reflector, err error) {
+		cache.DefaultWatchErrorHandler(r, err)
+		w.health.watchFailed(informer)
+	}
+}
+
+// HealthHandler serves /healthz and /readyz probes.
+func (w *Watcher) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", probeHandler(w.Healthz))
+	mux.HandleFunc("/readyz", probeHandler(w.Readyz))
+	return mux
+}
+
+func probeHandler(check func() error) http.HandlerFunc {
+	return func(rw http.ResponseWriter, _ *http.Request) {
+		if err := check(); err != nil {
+			http.Error(rw, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(rw, "ok")
+	}
+}
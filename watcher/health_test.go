@@ -0,0 +1,164 @@
+package watcher
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+// runTestWatcher runs informers of test watcher like Run does in the operator, until the test is done.
+func runTestWatcher(t *testing.T, w *Watcher) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	w.Context = ctx
+	t.Cleanup(func() {
+		cancel()
+		w.Shutdown()
+	})
+	if err := w.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	w := newTestWatcher(t, Options{AutoAggregate: true},
+		mirroredService("x", "target1", servicePort("http", 80, 8080)),
+		mirroredEndpointSlice("x", "target1", "x-0"))
+	if err := w.Readyz(); err == nil {
+		t.Errorf("Readyz() = nil before Run, want not ready")
+	}
+
+	runTestWatcher(t, w)
+	if err := w.Readyz(); err != nil {
+		t.Errorf("Readyz() error = %v after Run, want ready", err)
+	}
+	// Initial resync queued global service of the mirrored service.
+	if w.queue.Len() == 0 {
+		t.Errorf("queue is empty after Run, want initial resync to queue global services")
+	}
+}
+
+// informerFailed marks informer failing since then, having synced to the version by then.
+func informerFailed(w *Watcher, informer cache.SharedIndexInformer, since time.Time, version string) {
+	w.health.mu.Lock()
+	defer w.health.mu.Unlock()
+	w.health.failing[informer] = informerFailure{since: since, version: version}
+}
+
+func globalServiceInformer(w *Watcher) cache.SharedIndexInformer {
+	return w.MirrorInformersFactory.Mirror().V1alpha1().GlobalServices().Informer()
+}
+
+func TestHealthz(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, w *Watcher, clock *testingclock.FakeClock)
+		wantErr bool
+	}{
+		{name: "healthy", setup: func(t *testing.T, w *Watcher, clock *testingclock.FakeClock) {}},
+		{
+			name: "worker stuck",
+			setup: func(t *testing.T, w *Watcher, clock *testingclock.FakeClock) {
+				w.health.startedProcessing(globalKey(testGlobalNamespace, "x-global"))
+				clock.Step(time.Minute)
+			},
+			wantErr: true,
+		},
+		{
+			name: "worker busy within stall timeout",
+			setup: func(t *testing.T, w *Watcher, clock *testingclock.FakeClock) {
+				w.health.startedProcessing(globalKey(testGlobalNamespace, "x-global"))
+				clock.Step(time.Second)
+			},
+		},
+		{
+			name: "worker done",
+			setup: func(t *testing.T, w *Watcher, clock *testingclock.FakeClock) {
+				w.health.startedProcessing(globalKey(testGlobalNamespace, "x-global"))
+				w.health.doneProcessing(globalKey(testGlobalNamespace, "x-global"))
+				clock.Step(time.Minute)
+			},
+		},
+		{
+			name: "informer failing",
+			setup: func(t *testing.T, w *Watcher, clock *testingclock.FakeClock) {
+				w.health.watchFailed(globalServiceInformer(w))
+				clock.Step(time.Minute)
+			},
+			wantErr: true,
+		},
+		{
+			name: "informer failing within stall timeout",
+			setup: func(t *testing.T, w *Watcher, clock *testingclock.FakeClock) {
+				w.health.watchFailed(globalServiceInformer(w))
+				clock.Step(time.Second)
+			},
+		},
+		{
+			name: "informer synced since it failed",
+			setup: func(t *testing.T, w *Watcher, clock *testingclock.FakeClock) {
+				informerFailed(w, globalServiceInformer(w), clock.Now(), "stale")
+				clock.Step(time.Minute)
+			},
+		},
+		{
+			name: "informer failing while others get events",
+			setup: func(t *testing.T, w *Watcher, clock *testingclock.FakeClock) {
+				w.health.watchFailed(globalServiceInformer(w))
+				svcInformer := w.MirroredFactories[metav1.NamespaceAll].Core().V1().Services().Informer()
+				applyObject(t, w.clientset, mirroredService("x", "target1", servicePort("http", 80, 8080)))
+				err := wait.PollUntilContextTimeout(context.Background(), time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+					return len(svcInformer.GetStore().List()) > 0, nil
+				})
+				if err != nil {
+					t.Fatalf("mirrored service didn't reach the informer: %v", err)
+				}
+				clock.Step(time.Minute)
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := testingclock.NewFakeClock(time.Now())
+			w := newTestWatcher(t, Options{StallTimeout: 10 * time.Second, Clock: clock})
+			runTestWatcher(t, w)
+			// Informers synced to whatever versions they have by now.
+			if err := w.Healthz(); err != nil {
+				t.Fatalf("Healthz() error = %v right after Run", err)
+			}
+			tt.setup(t, w, clock)
+			if err := w.Healthz(); (err != nil) != tt.wantErr {
+				t.Errorf("Healthz() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHealthzBeforeRun(t *testing.T) {
+	clock := testingclock.NewFakeClock(time.Now())
+	w := newTestWatcher(t, Options{StallTimeout: time.Millisecond, Clock: clock})
+	w.health.watchFailed(globalServiceInformer(w))
+	clock.Step(time.Second)
+	// Startup is up to readiness.
+	if err := w.Healthz(); err != nil {
+		t.Errorf("Healthz() error = %v before Run, want nil", err)
+	}
+}
+
+func TestHealthzEmptyCluster(t *testing.T) {
+	clock := testingclock.NewFakeClock(time.Now())
+	w := newTestWatcher(t, Options{StallTimeout: time.Millisecond, Clock: clock})
+	runTestWatcher(t, w)
+
+	// Nothing is mirrored, so informers don't get any events.
+	clock.Step(time.Second)
+	if err := w.Healthz(); err != nil {
+		t.Errorf("Healthz() error = %v, want healthy watcher of cluster without mirrored services", err)
+	}
+}
@@ -0,0 +1,106 @@
+package watcher
+
+import (
+	"fmt"
+	"strings"
+
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// HostnamePolicy decides hostnames of endpoints in global endpointslices, i.e. per-pod DNS names of global service.
+type HostnamePolicy interface {
+	// Hostname returns hostname of endpoint of mirrored endpointslice from the cluster, in global endpointslice.
+	// unique reports if no other cluster has endpoint with the same hostname in the global service.
+	Hostname(hostname, cluster string, unique bool) string
+}
+
+// HostnameMode is how ClusterHostnames adds the cluster to hostnames.
+type HostnameMode string
+
+const (
+	// HostnameSuffix appends cluster, nginx-set-0-target1.
+	HostnameSuffix HostnameMode = "suffix"
+	// HostnamePrefix prepends cluster, target1-nginx-set-0.
+	HostnamePrefix HostnameMode = "prefix"
+	// HostnamePreserve keeps hostname as it is when unique, otherwise appends cluster.
+	HostnamePreserve HostnameMode = "preserve"
+)
+
+// ClusterHostnames is the default HostnamePolicy, it adds cluster or its alias to hostnames.
+type ClusterHostnames struct {
+	Mode HostnameMode
+	// Aliases are short names used for clusters instead of names of their links, i.e. use1 for us-east-1.
+	Aliases map[string]string
+}
+
+// ParseHostnameMode returns the mode, empty string being suffix.
+func ParseHostnameMode(mode string) (HostnameMode, error) {
+	switch HostnameMode(mode) {
+	case "", HostnameSuffix:
+		return HostnameSuffix, nil
+	case HostnamePrefix, HostnamePreserve:
+		return HostnameMode(mode), nil
+	}
+	return "", fmt.Errorf("unknown hostname mode %q, use one of %v, %v or %v", mode, HostnameSuffix, HostnamePrefix, HostnamePreserve)
+}
+
+func (p ClusterHostnames) Hostname(hostname, cluster string, unique bool) string {
+	if alias, ok := p.Aliases[cluster]; ok {
+		cluster = alias
+	}
+	switch {
+	case p.Mode == HostnamePreserve && unique:
+		return hostname
+	case p.Mode == HostnamePrefix:
+		return fmt.Sprintf("%v-%v", cluster, hostname)
+	}
+	return fmt.Sprintf("%v-%v", hostname, cluster)
+}
+
+// hostnameClusters returns clusters having endpoints with the hostname, across mirrored endpointslices of global service.
+func hostnameClusters(targetEps []*discoveryv1.EndpointSlice) map[string]map[string]bool {
+	clusters := make(map[string]map[string]bool)
+	for _, eps := range targetEps {
+		cluster := eps.GetLabels()[clusterNameLabel]
+		for _, ep := range eps.Endpoints {
+			if ep.Hostname == nil {
+				continue
+			}
+			if clusters[*ep.Hostname] == nil {
+				clusters[*ep.Hostname] = make(map[string]bool)
+			}
+			clusters[*ep.Hostname][cluster] = true
+		}
+	}
+	return clusters
+}
+
+// checkHostnames drops hostnames which aren't valid DNS labels, or are already taken by another endpoint of global
+// service. Such endpoints still get traffic of global service, but don't get DNS name of their own.
+// taken holds hostnames of endpoints checked so far, by mirrored endpointslice they come from.
+func (w *Watcher) checkHostnames(agg *aggregation, eps *discoveryv1.EndpointSlice, endpoints []discoveryv1.Endpoint, taken map[string]string) {
+	var problems []string
+	for i := range endpoints {
+		hostname := endpoints[i].Hostname
+		if hostname == nil {
+			continue
+		}
+		if errs := validation.IsDNS1123Label(*hostname); len(errs) > 0 {
+			problems = append(problems, fmt.Sprintf("%q is invalid: %v", *hostname, strings.Join(errs, ", ")))
+			endpoints[i].Hostname = nil
+			continue
+		}
+		if source, ok := taken[*hostname]; ok {
+			problems = append(problems, fmt.Sprintf("%q is already used by EndpointSlice %v", *hostname, source))
+			endpoints[i].Hostname = nil
+			continue
+		}
+		taken[*hostname] = eps.Namespace + "/" + eps.Name
+	}
+
+	if len(problems) > 0 {
+		hostnameConflictsTotal.WithLabelValues(agg.namespace, agg.name, eps.GetLabels()[clusterNameLabel]).Add(float64(len(problems)))
+		w.warnf(agg, reasonHostnameConflict, "Endpoints of EndpointSlice %v/%v left without hostname: %v", eps.Namespace, eps.Name, strings.Join(problems, "; "))
+	}
+}
@@ -0,0 +1,155 @@
+package watcher
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestClusterHostnames(t *testing.T) {
+	aliases := map[string]string{"us-east-1": "use1"}
+	tests := []struct {
+		name     string
+		mode     HostnameMode
+		hostname string
+		cluster  string
+		unique   bool
+		want     string
+	}{
+		{name: "suffix", mode: HostnameSuffix, hostname: "nginx-set-0", cluster: "target1", want: "nginx-set-0-target1"},
+		{name: "prefix", mode: HostnamePrefix, hostname: "nginx-set-0", cluster: "target1", want: "target1-nginx-set-0"},
+		{name: "suffix with alias", mode: HostnameSuffix, hostname: "nginx-set-0", cluster: "us-east-1", want: "nginx-set-0-use1"},
+		{name: "prefix with alias", mode: HostnamePrefix, hostname: "nginx-set-0", cluster: "us-east-1", want: "use1-nginx-set-0"},
+		{name: "preserve unique", mode: HostnamePreserve, hostname: "nginx-set-0", cluster: "target1", unique: true, want: "nginx-set-0"},
+		{name: "preserve falls back to suffix", mode: HostnamePreserve, hostname: "nginx-set-0", cluster: "us-east-1", want: "nginx-set-0-use1"},
+		{name: "suffix ignores uniqueness", mode: HostnameSuffix, hostname: "nginx-set-0", cluster: "target1", unique: true, want: "nginx-set-0-target1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := ClusterHostnames{Mode: tt.mode, Aliases: aliases}
+			if got := policy.Hostname(tt.hostname, tt.cluster, tt.unique); got != tt.want {
+				t.Errorf("Hostname(%q, %q, %v) = %q, want %q", tt.hostname, tt.cluster, tt.unique, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseHostnameMode(t *testing.T) {
+	tests := []struct {
+		mode    string
+		want    HostnameMode
+		wantErr bool
+	}{
+		{mode: "", want: HostnameSuffix},
+		{mode: "suffix", want: HostnameSuffix},
+		{mode: "prefix", want: HostnamePrefix},
+		{mode: "preserve", want: HostnamePreserve},
+		{mode: "random", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			got, err := ParseHostnameMode(tt.mode)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ParseHostnameMode(%q) = %q, %v, want %q, wantErr %v", tt.mode, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReconcileHostnames(t *testing.T) {
+	http := servicePort("http", 80, 8080)
+	tests := []struct {
+		name    string
+		policy  ClusterHostnames
+		objects []runtime.Object
+		// Hostnames in global endpointslices by mirrored service, endpoints left without one are "".
+		want         map[string][]string
+		wantProblems bool
+	}{
+		{
+			name:   "preserve unique hostnames",
+			policy: ClusterHostnames{Mode: HostnamePreserve},
+			objects: []runtime.Object{
+				mirroredService("x", "target1", http), mirroredEndpointSlice("x", "target1", "x-0", "x-1"),
+				mirroredService("x", "target2", http), mirroredEndpointSlice("x", "target2", "x-0", "x-2"),
+			},
+			want: map[string][]string{
+				"x-target1": {"x-0-target1", "x-1"},
+				"x-target2": {"x-0-target2", "x-2"},
+			},
+		},
+		{
+			name:   "aliases",
+			policy: ClusterHostnames{Mode: HostnamePrefix, Aliases: map[string]string{"target1": "t1"}},
+			objects: []runtime.Object{
+				mirroredService("x", "target1", http), mirroredEndpointSlice("x", "target1", "x-0"),
+				mirroredService("x", "target2", http), mirroredEndpointSlice("x", "target2", "x-0"),
+			},
+			want: map[string][]string{
+				"x-target1": {"t1-x-0"},
+				"x-target2": {"target2-x-0"},
+			},
+		},
+		{
+			name:   "colliding hostname is dropped from cluster sorting last",
+			policy: ClusterHostnames{Mode: HostnameSuffix, Aliases: map[string]string{"target1": "east", "target2": "east"}},
+			objects: []runtime.Object{
+				mirroredService("x", "target2", http), mirroredEndpointSlice("x", "target2", "x-0", "x-1"),
+				mirroredService("x", "target1", http), mirroredEndpointSlice("x", "target1", "x-0"),
+			},
+			want: map[string][]string{
+				"x-target1": {"x-0-east"},
+				"x-target2": {"", "x-1-east"},
+			},
+			wantProblems: true,
+		},
+		{
+			name:   "invalid hostname is dropped",
+			policy: ClusterHostnames{Mode: HostnameSuffix},
+			objects: []runtime.Object{
+				mirroredService("x", "target1", http), mirroredEndpointSlice("x", "target1", strings.Repeat("x", 60), "x-1"),
+			},
+			want:         map[string][]string{"x-target1": {"", "x-1-target1"}},
+			wantProblems: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWatcher(t, Options{AutoAggregate: true, Hostnames: tt.policy}, tt.objects...)
+			if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+				t.Fatalf("reconcileGlobalService() error = %v", err)
+			}
+
+			got := make(map[string][]string)
+			for targetSvcName := range tt.want {
+				hosts := make([]string, 0)
+				for _, eps := range globalSlicesOf(t, w, targetSvcName) {
+					for _, ep := range eps.Endpoints {
+						hostname := ""
+						if ep.Hostname != nil {
+							hostname = *ep.Hostname
+						}
+						hosts = append(hosts, hostname)
+					}
+				}
+				sort.Strings(hosts)
+				got[targetSvcName] = hosts
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("hostnames = %v, want %v", got, tt.want)
+			}
+
+			agg, _ := w.aggregationFor(testGlobalNamespace, "x-global")
+			syncCache(t, w)
+			if err := w.syncGlobalService(testGlobalNamespace, "x-global", agg); err != nil {
+				t.Fatalf("syncGlobalService() error = %v", err)
+			}
+			if problems := len(agg.problems) > 0; problems != tt.wantProblems {
+				t.Errorf("problems = %v, want some %v", agg.problems, tt.wantProblems)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package watcher
+
+import (
+	"net"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+)
+
+// Order global endpointslices of mirrored endpointslice are synced in, one set for every address type.
+var addressTypes = []discoveryv1.AddressType{discoveryv1.AddressTypeIPv4, discoveryv1.AddressTypeIPv6, discoveryv1.AddressTypeFQDN}
+
+// endpointAddressType returns address type of endpoint, going by its first address, as mirrored endpointslice
+// of one cluster can have addresses of the other family. Endpoints of FQDN endpointslices are passed through.
+func endpointAddressType(addressType discoveryv1.AddressType, ep discoveryv1.Endpoint) discoveryv1.AddressType {
+	if addressType == discoveryv1.AddressTypeFQDN || len(ep.Addresses) == 0 {
+		return addressType
+	}
+	ip := net.ParseIP(ep.Addresses[0])
+	switch {
+	case ip == nil:
+		return addressType
+	case ip.To4() != nil:
+		return discoveryv1.AddressTypeIPv4
+	default:
+		return discoveryv1.AddressTypeIPv6
+	}
+}
+
+// splitByAddressType returns endpoints by address type, endpointslice can only hold addresses of one.
+func splitByAddressType(addressType discoveryv1.AddressType, endpoints []discoveryv1.Endpoint) map[discoveryv1.AddressType][]discoveryv1.Endpoint {
+	split := make(map[discoveryv1.AddressType][]discoveryv1.Endpoint)
+	for _, ep := range endpoints {
+		t := endpointAddressType(addressType, ep)
+		split[t] = append(split[t], ep)
+	}
+	return split
+}
+
+// globalIPFamilies returns IP families of global service, the union of families of mirrored services and of addresses
+// of their endpoints. Primary family of existing global service stays first while it's still there, as it can't be
+// changed in place, otherwise IPv4 goes first. Nil when there is nothing to go by, leaving it to apiserver.
+func globalIPFamilies(globalSvc *corev1.Service, targetSvcs []*corev1.Service, targetEps []*discoveryv1.EndpointSlice) []corev1.IPFamily {
+	union := make(map[corev1.IPFamily]bool)
+	for _, svc := range targetSvcs {
+		for _, family := range svc.Spec.IPFamilies {
+			union[family] = true
+		}
+	}
+	for _, eps := range targetEps {
+		for _, ep := range eps.Endpoints {
+			switch endpointAddressType(eps.AddressType, ep) {
+			case discoveryv1.AddressTypeIPv4:
+				union[corev1.IPv4Protocol] = true
+			case discoveryv1.AddressTypeIPv6:
+				union[corev1.IPv6Protocol] = true
+			}
+		}
+	}
+
+	families := make([]corev1.IPFamily, 0, len(union))
+	if globalSvc != nil && len(globalSvc.Spec.IPFamilies) > 0 && union[globalSvc.Spec.IPFamilies[0]] {
+		families = append(families, globalSvc.Spec.IPFamilies[0])
+	}
+	for _, family := range []corev1.IPFamily{corev1.IPv4Protocol, corev1.IPv6Protocol} {
+		if union[family] && (len(families) == 0 || families[0] != family) {
+			families = append(families, family)
+		}
+	}
+	if len(families) == 0 {
+		return nil
+	}
+	return families
+}
+
+// ipFamilyPolicy of global service with the families. Dual-stack is preferred, not required,
+// so global service still works in cluster which is single-stack.
+func ipFamilyPolicy(families []corev1.IPFamily) corev1.IPFamilyPolicy {
+	if len(families) > 1 {
+		return corev1.IPFamilyPolicyPreferDualStack
+	}
+	return corev1.IPFamilyPolicySingleStack
+}
+
+// ipFamiliesSynced reports if global service has the families. Only policy and primary family are compared,
+// apiserver leaves out the secondary family when the cluster doesn't have it.
+func ipFamiliesSynced(globalSvc *corev1.Service, families []corev1.IPFamily) bool {
+	if len(families) == 0 {
+		return true
+	}
+	if globalSvc.Spec.IPFamilyPolicy == nil || *globalSvc.Spec.IPFamilyPolicy != ipFamilyPolicy(families) {
+		return false
+	}
+	return len(globalSvc.Spec.IPFamilies) > 0 && globalSvc.Spec.IPFamilies[0] == families[0]
+}
+
+// primaryFamilyChanged reports if global service has to be recreated to get the families, as primary family
+// of service can't be changed in place.
+func primaryFamilyChanged(globalSvc *corev1.Service, families []corev1.IPFamily) bool {
+	return len(families) > 0 && len(globalSvc.Spec.IPFamilies) > 0 && globalSvc.Spec.IPFamilies[0] != families[0]
+}
@@ -0,0 +1,132 @@
+package watcher
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// withAddresses sets address type of mirrored endpointslice and addresses of its endpoints, in order.
+func withAddresses(eps *discoveryv1.EndpointSlice, addressType discoveryv1.AddressType, addresses ...string) *discoveryv1.EndpointSlice {
+	eps.AddressType = addressType
+	for i, address := range addresses {
+		eps.Endpoints[i].Addresses = []string{address}
+	}
+	return eps
+}
+
+func TestEndpointAddressType(t *testing.T) {
+	tests := []struct {
+		name        string
+		addressType discoveryv1.AddressType
+		addresses   []string
+		want        discoveryv1.AddressType
+	}{
+		{name: "ipv4", addressType: discoveryv1.AddressTypeIPv4, addresses: []string{"10.0.0.1"}, want: discoveryv1.AddressTypeIPv4},
+		{name: "ipv6", addressType: discoveryv1.AddressTypeIPv6, addresses: []string{"fd00::1"}, want: discoveryv1.AddressTypeIPv6},
+		{name: "ipv6 in ipv4 endpointslice", addressType: discoveryv1.AddressTypeIPv4, addresses: []string{"fd00::1"}, want: discoveryv1.AddressTypeIPv6},
+		{name: "fqdn", addressType: discoveryv1.AddressTypeFQDN, addresses: []string{"x.example.com"}, want: discoveryv1.AddressTypeFQDN},
+		{name: "not an ip", addressType: discoveryv1.AddressTypeIPv6, addresses: []string{"x.example.com"}, want: discoveryv1.AddressTypeIPv6},
+		{name: "no addresses", addressType: discoveryv1.AddressTypeIPv4, want: discoveryv1.AddressTypeIPv4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := endpointAddressType(tt.addressType, discoveryv1.Endpoint{Addresses: tt.addresses}); got != tt.want {
+				t.Errorf("endpointAddressType() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGlobalIPFamilies(t *testing.T) {
+	ipv4 := withAddresses(mirroredEndpointSlice("x", "target1", "x-0"), discoveryv1.AddressTypeIPv4, "10.0.0.1")
+	ipv6 := withAddresses(mirroredEndpointSlice("x", "target2", "x-0"), discoveryv1.AddressTypeIPv6, "fd00::1")
+	ipv6Svc := mirroredService("x", "target2")
+	ipv6Svc.Spec.IPFamilies = []corev1.IPFamily{corev1.IPv6Protocol}
+	existing := func(families ...corev1.IPFamily) *corev1.Service {
+		return &corev1.Service{Spec: corev1.ServiceSpec{IPFamilies: families}}
+	}
+
+	tests := []struct {
+		name      string
+		globalSvc *corev1.Service
+		svcs      []*corev1.Service
+		eps       []*discoveryv1.EndpointSlice
+		want      []corev1.IPFamily
+	}{
+		{name: "nothing to go by", svcs: []*corev1.Service{mirroredService("x", "target1")}},
+		{name: "single stack", eps: []*discoveryv1.EndpointSlice{ipv4}, want: []corev1.IPFamily{corev1.IPv4Protocol}},
+		{name: "ipv4 goes first", eps: []*discoveryv1.EndpointSlice{ipv6, ipv4}, want: []corev1.IPFamily{corev1.IPv4Protocol, corev1.IPv6Protocol}},
+		{name: "family of mirrored service", svcs: []*corev1.Service{ipv6Svc}, eps: []*discoveryv1.EndpointSlice{ipv4}, want: []corev1.IPFamily{corev1.IPv4Protocol, corev1.IPv6Protocol}},
+		{
+			name:      "primary family of global service stays",
+			globalSvc: existing(corev1.IPv6Protocol),
+			eps:       []*discoveryv1.EndpointSlice{ipv4, ipv6},
+			want:      []corev1.IPFamily{corev1.IPv6Protocol, corev1.IPv4Protocol},
+		},
+		{
+			name:      "primary family of global service is gone",
+			globalSvc: existing(corev1.IPv6Protocol),
+			eps:       []*discoveryv1.EndpointSlice{ipv4},
+			want:      []corev1.IPFamily{corev1.IPv4Protocol},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := globalIPFamilies(tt.globalSvc, tt.svcs, tt.eps); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("globalIPFamilies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileDualStack(t *testing.T) {
+	w := newTestWatcher(t, Options{AutoAggregate: true},
+		mirroredService("x", "target1", servicePort("http", 80, 8080)),
+		mirroredService("x", "target2", servicePort("http", 80, 8080)),
+		// Mirrored endpointslice holding addresses of both families.
+		withAddresses(mirroredEndpointSlice("x", "target1", "x-0", "x-1"), discoveryv1.AddressTypeIPv4, "10.0.0.1", "fd00::1"),
+		withAddresses(mirroredEndpointSlice("x", "target2", "x-0"), discoveryv1.AddressTypeIPv6, "fd00::2"))
+	for i := 0; i < 2; i++ {
+		if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+			t.Fatalf("reconcileGlobalService() error = %v", err)
+		}
+		syncCache(t, w)
+	}
+
+	globalSvc, err := w.clientset.CoreV1().Services(testGlobalNamespace).Get(context.Background(), "x-global", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting global service: %v", err)
+	}
+	if want := []corev1.IPFamily{corev1.IPv4Protocol, corev1.IPv6Protocol}; !reflect.DeepEqual(globalSvc.Spec.IPFamilies, want) {
+		t.Errorf("ipFamilies = %v, want %v", globalSvc.Spec.IPFamilies, want)
+	}
+	if globalSvc.Spec.IPFamilyPolicy == nil || *globalSvc.Spec.IPFamilyPolicy != corev1.IPFamilyPolicyPreferDualStack {
+		t.Errorf("ipFamilyPolicy = %v, want %v", globalSvc.Spec.IPFamilyPolicy, corev1.IPFamilyPolicyPreferDualStack)
+	}
+
+	tests := []struct {
+		targetSvcName string
+		want          []string
+	}{
+		{targetSvcName: "x-target1", want: []string{"IPv4 10.0.0.1", "IPv6 fd00::1"}},
+		{targetSvcName: "x-target2", want: []string{"IPv6 fd00::2"}},
+	}
+	for _, tt := range tests {
+		got := make([]string, 0)
+		for _, eps := range globalSlicesOf(t, w, tt.targetSvcName) {
+			for _, ep := range eps.Endpoints {
+				got = append(got, string(eps.AddressType)+" "+ep.Addresses[0])
+			}
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("global endpointslices of %v = %v, want %v", tt.targetSvcName, got, tt.want)
+		}
+	}
+}
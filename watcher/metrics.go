@@ -0,0 +1,309 @@
+package watcher
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Handlers reconcile of global service is split into, used as metric label.
+const (
+	handlerServiceAdd    = "service_add"
+	handlerServiceUpdate = "service_update"
+	handlerServiceDelete = "service_delete"
+	// Cleanup once global service is being deleted.
+	handlerServiceFinalize = "service_finalize"
+	handlerEpsAdd          = "eps_add"
+	handlerEpsUpdate       = "eps_update"
+	handlerEpsDelete       = "eps_delete"
+	// Apex service and traffic split next to global service.
+	handlerTrafficSplit = "traffic_split"
+)
+
+var (
+	reconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "global_mirror_reconcile_total",
+		Help: "Number of times handler ran while reconciling global services, by result.",
+	}, []string{"handler", "result"})
+
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "global_mirror_reconcile_duration_seconds",
+		Help:    "Time taken by handler while reconciling global services.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"handler"})
+
+	apiErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "global_mirror_api_errors_total",
+		Help: "Number of failed calls to apiserver, by verb and resource.",
+	}, []string{"verb", "resource"})
+
+	gatewayIPSkipsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "global_mirror_gateway_ip_skips_total",
+		Help: "Number of times endpoints without hostname (gateway IP) of mirrored endpointslice were skipped, either alone or with the whole endpointslice, by the gateway IP policy.",
+	}, []string{"namespace", "global_service", "cluster"})
+
+	portConflictsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "global_mirror_port_conflicts_total",
+		Help: "Number of times port of mirrored service was left out of global service, because it conflicted with the same port of other mirrored services.",
+	}, []string{"namespace", "global_service", "cluster"})
+
+	hostnameConflictsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "global_mirror_hostname_conflicts_total",
+		Help: "Number of times endpoint was left without hostname in global endpointslice, because its hostname was invalid or already used by another endpoint.",
+	}, []string{"namespace", "global_service", "cluster"})
+
+	clusterWithdrawalsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "global_mirror_cluster_withdrawals_total",
+		Help: "Number of times cluster serving global service was withdrawn from it, because it had less ready endpoints than the minimum.",
+	}, []string{"namespace", "global_service", "cluster"})
+
+	failoverSwitchesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "global_mirror_failover_switches_total",
+		Help: "Number of times global service in failover mode switched the cluster it sends traffic to.",
+	}, []string{"namespace", "global_service", "from_cluster", "to_cluster"})
+
+	serviceRecreatesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "global_mirror_service_recreates_total",
+		Help: "Number of times global service was deleted to be recreated, because its type changed between Headless and ClusterIP, or its primary IP family changed.",
+	}, []string{"namespace", "global_service"})
+
+	namespaceCollisionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "global_mirror_namespace_collisions_total",
+		Help: "Number of times mirrored services of different namespaces ended up in the same global service, and only the ones of one namespace were aggregated.",
+	}, []string{"namespace", "global_service"})
+
+	namingErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "global_mirror_naming_errors_total",
+		Help: "Number of times global service wasn't reconciled, because its name is invalid or names of different services collide.",
+	}, []string{"namespace", "global_service"})
+)
+
+// observeHandler counts the handler run and its latency.
+func observeHandler(handler string, start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	reconcileTotal.WithLabelValues(handler, result).Inc()
+	reconcileDuration.WithLabelValues(handler).Observe(time.Since(start).Seconds())
+}
+
+// runHandler runs one of the handlers and records it in metrics.
+func runHandler(handler string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	observeHandler(handler, start, err)
+	return err
+}
+
+// countAPIError counts failed call to apiserver.
+func countAPIError(verb, resource string) {
+	apiErrorsTotal.WithLabelValues(verb, resource).Inc()
+}
+
+// MetricsHandler serves metrics of the watcher, work queue and go runtime in prometheus format.
+func (w *Watcher) MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(w.registry, promhttp.HandlerOpts{})
+}
+
+func newRegistry(w *Watcher) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		reconcileTotal,
+		reconcileDuration,
+		apiErrorsTotal,
+		gatewayIPSkipsTotal,
+		portConflictsTotal,
+		namingErrorsTotal,
+		hostnameConflictsTotal,
+		clusterWithdrawalsTotal,
+		failoverSwitchesTotal,
+		serviceRecreatesTotal,
+		namespaceCollisionsTotal,
+		queueDepth,
+		queueAdds,
+		queueLatency,
+		queueWorkDuration,
+		queueUnfinishedWork,
+		queueLongestRunning,
+		queueRetries,
+		&stateCollector{w: w},
+	)
+	return registry
+}
+
+/* -------------------- STATE OF AGGREGATION, COMPUTED FROM CACHE ON SCRAPE ---------------------- */
+
+var (
+	globalServicesDesc = prometheus.NewDesc(
+		"global_mirror_global_services",
+		"Number of global services.",
+		nil, nil)
+
+	globalEndpointsDesc = prometheus.NewDesc(
+		"global_mirror_global_endpoints",
+		"Number of endpoints contributed to global service by source cluster.",
+		[]string{"namespace", "global_service", "cluster"}, nil)
+
+	gatewayIPSlicesDesc = prometheus.NewDesc(
+		"global_mirror_gateway_ip_endpointslices",
+		"Number of mirrored endpointslices currently having endpoints without hostname (gateway IP), by source cluster.",
+		[]string{"namespace", "cluster"}, nil)
+
+	failoverActiveClusterDesc = prometheus.NewDesc(
+		"global_mirror_failover_active_cluster",
+		"Cluster global service in failover mode sends traffic to, always 1.",
+		[]string{"namespace", "global_service", "cluster"}, nil)
+)
+
+// stateCollector reports the state of global services from informer cache, so it is always accurate
+// without having to keep gauges in sync with every create and delete.
+type stateCollector struct {
+	w *Watcher
+}
+
+func (c *stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- globalServicesDesc
+	ch <- globalEndpointsDesc
+	ch <- gatewayIPSlicesDesc
+	ch <- failoverActiveClusterDesc
+}
+
+func (c *stateCollector) Collect(ch chan<- prometheus.Metric) {
+	w := c.w
+	globalSelector := labels.SelectorFromSet(labels.Set{globalMirrorLabel: "true"})
+
+	if globalSvcs, err := w.svcLister.List(globalSelector); err == nil {
+		count := 0
+		for _, svc := range globalSvcs {
+			if w.isGlobalObject(svc.ObjectMeta) {
+				count++
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(globalServicesDesc, prometheus.GaugeValue, float64(count))
+	}
+
+	if globalEps, err := w.epsLister.List(globalSelector); err == nil {
+		type key struct{ namespace, svc, cluster string }
+		endpoints := make(map[key]int)
+		for _, eps := range globalEps {
+			if !w.isGlobalObject(eps.ObjectMeta) {
+				continue
+			}
+			epsLabels := eps.GetLabels()
+			endpoints[key{eps.Namespace, epsLabels[serviceNameLabel], epsLabels[clusterNameLabel]}] += len(eps.Endpoints)
+		}
+		for k, count := range endpoints {
+			ch <- prometheus.MustNewConstMetric(globalEndpointsDesc, prometheus.GaugeValue, float64(count), k.namespace, k.svc, k.cluster)
+		}
+	}
+
+	if slices, err := w.epsLister.List(labels.Everything()); err == nil {
+		type key struct{ namespace, cluster string }
+		stuck := make(map[key]int)
+		for _, eps := range slices {
+			if !w.Filter(eps.ObjectMeta) {
+				continue
+			}
+			for _, ep := range eps.Endpoints {
+				if ep.Hostname == nil {
+					stuck[key{eps.Namespace, eps.GetLabels()[clusterNameLabel]}]++
+					break
+				}
+			}
+		}
+		for k, count := range stuck {
+			ch <- prometheus.MustNewConstMetric(gatewayIPSlicesDesc, prometheus.GaugeValue, float64(count), k.namespace, k.cluster)
+		}
+	}
+
+	for key, cluster := range w.activeClusters() {
+		if namespace, globalSvcName, err := splitGlobalKey(key); err == nil {
+			ch <- prometheus.MustNewConstMetric(failoverActiveClusterDesc, prometheus.GaugeValue, 1, namespace, globalSvcName, cluster)
+		}
+	}
+}
+
+/* -------------------- WORK QUEUE METRICS ---------------------- */
+
+var (
+	queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "workqueue_depth",
+		Help: "Current depth of workqueue.",
+	}, []string{"name"})
+
+	queueAdds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "workqueue_adds_total",
+		Help: "Total number of adds handled by workqueue.",
+	}, []string{"name"})
+
+	queueLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "workqueue_queue_duration_seconds",
+		Help:    "How long an item stays in workqueue before being requested.",
+		Buckets: prometheus.ExponentialBuckets(10e-9, 10, 10),
+	}, []string{"name"})
+
+	queueWorkDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "workqueue_work_duration_seconds",
+		Help:    "How long processing an item from workqueue takes.",
+		Buckets: prometheus.ExponentialBuckets(10e-9, 10, 10),
+	}, []string{"name"})
+
+	queueUnfinishedWork = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "workqueue_unfinished_work_seconds",
+		Help: "How many seconds of work has been done that is in progress and hasn't been observed by work_duration.",
+	}, []string{"name"})
+
+	queueLongestRunning = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "workqueue_longest_running_processor_seconds",
+		Help: "How many seconds has the longest running processor for workqueue been running.",
+	}, []string{"name"})
+
+	queueRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "workqueue_retries_total",
+		Help: "Total number of retries handled by workqueue.",
+	}, []string{"name"})
+)
+
+// queueMetricsProvider hands prometheus metrics to client-go work queues.
+type queueMetricsProvider struct{}
+
+func (queueMetricsProvider) NewDepthMetric(name string) workqueue.GaugeMetric {
+	return queueDepth.WithLabelValues(name)
+}
+
+func (queueMetricsProvider) NewAddsMetric(name string) workqueue.CounterMetric {
+	return queueAdds.WithLabelValues(name)
+}
+
+func (queueMetricsProvider) NewLatencyMetric(name string) workqueue.HistogramMetric {
+	return queueLatency.WithLabelValues(name)
+}
+
+func (queueMetricsProvider) NewWorkDurationMetric(name string) workqueue.HistogramMetric {
+	return queueWorkDuration.WithLabelValues(name)
+}
+
+func (queueMetricsProvider) NewUnfinishedWorkSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return queueUnfinishedWork.WithLabelValues(name)
+}
+
+func (queueMetricsProvider) NewLongestRunningProcessorSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return queueLongestRunning.WithLabelValues(name)
+}
+
+func (queueMetricsProvider) NewRetriesMetric(name string) workqueue.CounterMetric {
+	return queueRetries.WithLabelValues(name)
+}
+
+func init() {
+	// Has to be set before any queue is created.
+	workqueue.SetProvider(queueMetricsProvider{})
+}
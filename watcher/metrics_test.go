@@ -0,0 +1,36 @@
+package watcher
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestStateCollector(t *testing.T) {
+	w := newTestWatcher(t, Options{AutoAggregate: true, GatewayIPPolicy: GatewayIPSkipEndpoints},
+		mirroredService("x", "target1", servicePort("http", 80, 8080)), mirroredEndpointSlice("x", "target1", "x-0", "x-1"),
+		mirroredService("x", "target2", servicePort("http", 80, 8080)), mirroredEndpointSlice("x", "target2", "x-0", ""),
+		mirroredService("y", "target2", servicePort("http", 80, 8080)), mirroredEndpointSlice("y", "target2", ""))
+	for _, name := range []string{"x-global", "y-global"} {
+		if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, name)); err != nil {
+			t.Fatalf("reconcileGlobalService() error = %v", err)
+		}
+	}
+	syncCache(t, w)
+
+	// Endpoints having gateway IP instead of hostname are skipped, so y-global has none.
+	want := `
+# HELP global_mirror_gateway_ip_endpointslices Number of mirrored endpointslices currently having endpoints without hostname (gateway IP), by source cluster.
+# TYPE global_mirror_gateway_ip_endpointslices gauge
+global_mirror_gateway_ip_endpointslices{cluster="target2",namespace="test"} 2
+# HELP global_mirror_global_endpoints Number of endpoints contributed to global service by source cluster.
+# TYPE global_mirror_global_endpoints gauge
+global_mirror_global_endpoints{cluster="target1",global_service="x-global",namespace="global"} 2
+global_mirror_global_endpoints{cluster="target2",global_service="x-global",namespace="global"} 1
+`
+	if err := testutil.GatherAndCompare(w.registry, strings.NewReader(want),
+		"global_mirror_global_endpoints", "global_mirror_gateway_ip_endpointslices"); err != nil {
+		t.Error(err)
+	}
+}
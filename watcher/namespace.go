@@ -0,0 +1,102 @@
+package watcher
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// NamespaceMode tells in which namespace global service of mirrored services is created.
+type NamespaceMode string
+
+const (
+	// NamespaceSingle creates all the global services in the global namespace. Same named services of different
+	// namespaces collide, only the ones of the namespace which sorts first are aggregated.
+	NamespaceSingle NamespaceMode = "single"
+	// NamespacePreserve creates global service in namespace of its mirrored services, or the one it's mapped to.
+	NamespacePreserve NamespaceMode = "preserve"
+)
+
+// ParseNamespaceMode returns the mode, empty string being single.
+func ParseNamespaceMode(mode string) (NamespaceMode, error) {
+	switch NamespaceMode(mode) {
+	case "", NamespaceSingle:
+		return NamespaceSingle, nil
+	case NamespacePreserve:
+		return NamespacePreserve, nil
+	}
+	return "", fmt.Errorf("unknown namespace mode %q, use %v or %v", mode, NamespaceSingle, NamespacePreserve)
+}
+
+// globalKey is queue key of global service, namespace/name.
+func globalKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// globalNamespace returns namespace of global services aggregating mirrored services of the namespace.
+func (w *Watcher) globalNamespace(namespace string) string {
+	if w.namespaceMode != NamespacePreserve {
+		return w.namespace
+	}
+	if mapped, ok := w.namespaceMapping[namespace]; ok {
+		return mapped
+	}
+	return namespace
+}
+
+// managesNamespace reports if global objects in the namespace can be ours. In single mode they are only in the
+// global namespace, so another instance with another global namespace is left alone.
+func (w *Watcher) managesNamespace(namespace string) bool {
+	return w.namespaceMode == NamespacePreserve || namespace == w.namespace
+}
+
+// splitGlobalKey returns namespace and name of global service from queue key.
+func splitGlobalKey(key string) (string, string, error) {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err == nil && namespace == "" {
+		err = fmt.Errorf("key %q of global service has no namespace", key)
+	}
+	return namespace, name, err
+}
+
+// sourceNamespace leaves out mirrored services and endpointslices of all but one namespace, when same named
+// services of different namespaces end up in the same global service. Namespace which sorts first wins.
+func (w *Watcher) sourceNamespace(agg *aggregation, targetSvcs []*corev1.Service, targetEps []*discoveryv1.EndpointSlice) ([]*corev1.Service, []*discoveryv1.EndpointSlice) {
+	seen := make(map[string]bool)
+	for _, svc := range targetSvcs {
+		seen[svc.Namespace] = true
+	}
+	for _, eps := range targetEps {
+		seen[eps.Namespace] = true
+	}
+	if len(seen) <= 1 {
+		return targetSvcs, targetEps
+	}
+
+	namespaces := make([]string, 0, len(seen))
+	for namespace := range seen {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	namespaceCollisionsTotal.WithLabelValues(agg.namespace, agg.name).Inc()
+	w.warnf(agg, reasonNamespaceCollision, "Mirrored services of namespaces %v collide, only the ones of %v are aggregated",
+		strings.Join(namespaces, ", "), namespaces[0])
+
+	svcs := make([]*corev1.Service, 0, len(targetSvcs))
+	for _, svc := range targetSvcs {
+		if svc.Namespace == namespaces[0] {
+			svcs = append(svcs, svc)
+		}
+	}
+	slices := make([]*discoveryv1.EndpointSlice, 0, len(targetEps))
+	for _, eps := range targetEps {
+		if eps.Namespace == namespaces[0] {
+			slices = append(slices, eps)
+		}
+	}
+	return svcs, slices
+}
@@ -0,0 +1,123 @@
+package watcher
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestParseNamespaceMode(t *testing.T) {
+	tests := []struct {
+		mode    string
+		want    NamespaceMode
+		wantErr bool
+	}{
+		{mode: "", want: NamespaceSingle},
+		{mode: "single", want: NamespaceSingle},
+		{mode: "preserve", want: NamespacePreserve},
+		{mode: "mapped", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			got, err := ParseNamespaceMode(tt.mode)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ParseNamespaceMode(%q) = %q, %v, want %q, wantErr %v", tt.mode, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGlobalNamespace(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      Options
+		namespace string
+		want      string
+	}{
+		{name: "single", opts: Options{}, namespace: testNamespace, want: testGlobalNamespace},
+		{name: "preserve", opts: Options{NamespaceMode: NamespacePreserve}, namespace: testNamespace, want: testNamespace},
+		{
+			name:      "mapped",
+			opts:      Options{NamespaceMode: NamespacePreserve, NamespaceMapping: map[string]string{testNamespace: "shared"}},
+			namespace: testNamespace,
+			want:      "shared",
+		},
+		{
+			name:      "not mapped",
+			opts:      Options{NamespaceMode: NamespacePreserve, NamespaceMapping: map[string]string{"other": "shared"}},
+			namespace: testNamespace,
+			want:      testNamespace,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWatcher(t, tt.opts)
+			if got := w.globalNamespace(tt.namespace); got != tt.want {
+				t.Errorf("globalNamespace(%q) = %q, want %q", tt.namespace, got, tt.want)
+			}
+		})
+	}
+}
+
+// inNamespace moves mirrored service or endpointslice to the namespace.
+func inNamespace(obj interface {
+	runtime.Object
+	metav1.Object
+}, namespace string) runtime.Object {
+	obj.SetNamespace(namespace)
+	return obj
+}
+
+func TestReconcilePreserveNamespace(t *testing.T) {
+	w := newTestWatcher(t, Options{AutoAggregate: true, NamespaceMode: NamespacePreserve, NamespaceMapping: map[string]string{"b": "shared"}},
+		inNamespace(mirroredService("x", "target1", servicePort("http", 80, 8080)), "a"),
+		inNamespace(mirroredEndpointSlice("x", "target1", "x-0"), "a"),
+		inNamespace(mirroredService("x", "target1", servicePort("http", 80, 8080)), "b"),
+		inNamespace(mirroredEndpointSlice("x", "target1", "x-0"), "b"))
+
+	for _, namespace := range []string{"a", "shared"} {
+		if err := w.reconcileGlobalService(globalKey(namespace, "x-global")); err != nil {
+			t.Fatalf("reconcileGlobalService() error = %v", err)
+		}
+		syncCache(t, w)
+		if _, err := w.clientset.CoreV1().Services(namespace).Get(context.Background(), "x-global", metav1.GetOptions{}); err != nil {
+			t.Errorf("getting global service in %v: %v", namespace, err)
+		}
+	}
+	if _, err := w.clientset.CoreV1().Services(testGlobalNamespace).Get(context.Background(), "x-global", metav1.GetOptions{}); err == nil {
+		t.Errorf("global service created in global namespace, want it next to mirrored services")
+	}
+}
+
+func TestReconcileNamespaceCollision(t *testing.T) {
+	w := newTestWatcher(t, Options{AutoAggregate: true},
+		inNamespace(mirroredService("x", "target1", servicePort("http", 80, 8080)), "b"),
+		inNamespace(mirroredEndpointSlice("x", "target1", "x-0"), "b"),
+		inNamespace(mirroredService("x", "target1", servicePort("http", 80, 9090)), "a"),
+		inNamespace(mirroredEndpointSlice("x", "target1", "x-0"), "a"))
+	// Event goes on global service, which is there after the first one.
+	for i := 0; i < 2; i++ {
+		if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+			t.Fatalf("reconcileGlobalService() error = %v", err)
+		}
+		syncCache(t, w)
+	}
+
+	globalSvc, err := w.clientset.CoreV1().Services(testGlobalNamespace).Get(context.Background(), "x-global", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting global service: %v", err)
+	}
+	want := []corev1.ServicePort{servicePort("http", 80, 9090)}
+	if len(globalSvc.Spec.Ports) != 1 || globalSvc.Spec.Ports[0].TargetPort != want[0].TargetPort {
+		t.Errorf("ports = %v, want the ones of namespace a %v", globalSvc.Spec.Ports, want)
+	}
+	if !hasEvent(w, reasonNamespaceCollision) {
+		t.Errorf("no %v event recorded", reasonNamespaceCollision)
+	}
+	if slices := globalSlicesOf(t, w, "x-target1"); len(slices) != 1 {
+		t.Errorf("global endpointslices = %v, want the one of namespace a only", len(slices))
+	}
+}
@@ -0,0 +1,157 @@
+package watcher
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	mirrorv1alpha1 "github.com/rushi47/service-mirror-prototype/apis/mirror/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// NamingStrategy tells which service in target cluster a mirrored service comes from,
+// and names global services of automatically aggregated services and GlobalServices without name.
+type NamingStrategy interface {
+	// ServiceName returns name of the service in target cluster, i.e. x for mirrored service x-target1.
+	ServiceName(targetSvcName, cluster string) string
+	// GlobalName returns name of the global service aggregating the service.
+	GlobalName(service string) (string, error)
+}
+
+// DefaultNaming strips the -<cluster> suffix Linkerd adds to mirrored services, and names global service <service>-global.
+type DefaultNaming struct{}
+
+func (DefaultNaming) ServiceName(targetSvcName, cluster string) string {
+	return strings.TrimSuffix(targetSvcName, "-"+cluster)
+}
+
+func (DefaultNaming) GlobalName(service string) (string, error) {
+	return service + "-global", nil
+}
+
+// TemplateNaming names global services using Go template, i.e. {{.Service}}-all.
+type TemplateNaming struct {
+	DefaultNaming
+	tmpl *template.Template
+}
+
+// NewTemplateNaming parses the template, which gets the service as {{.Service}}.
+func NewTemplateNaming(text string) (*TemplateNaming, error) {
+	tmpl, err := template.New("global-name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid global name template %q: %w", text, err)
+	}
+	naming := &TemplateNaming{tmpl: tmpl}
+	// Catch templates using anything else than .Service early, rather than on every reconcile.
+	if _, err := naming.GlobalName("x"); err != nil {
+		return nil, err
+	}
+	return naming, nil
+}
+
+func (n *TemplateNaming) GlobalName(service string) (string, error) {
+	var name strings.Builder
+	if err := n.tmpl.Execute(&name, struct{ Service string }{service}); err != nil {
+		return "", fmt.Errorf("unable to name global service of %v: %w", service, err)
+	}
+	return name.String(), nil
+}
+
+// validateGlobalName makes sure global service can be created under the name, its length included.
+func validateGlobalName(name string) error {
+	if errs := validation.IsDNS1035Label(name); len(errs) > 0 {
+		return fmt.Errorf("invalid global service name %q: %v", name, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// isOwnGlobalService reports if the service under a global service name is ours, not a Service of users or a mirrored
+// service which happens to have the name. Server-side apply would take those over otherwise.
+func (w *Watcher) isOwnGlobalService(svc *corev1.Service) bool {
+	_, mirrored := svc.GetLabels()[mirroredServiceLabel]
+	return w.isGlobalObject(svc.ObjectMeta) && !mirrored
+}
+
+// checkNameTaken returns error when global service name is taken by Service which isn't ours, as far as cache knows.
+// Services of users aren't in cache, handleServiceAdd checks for them before creating global service.
+func (w *Watcher) checkNameTaken(namespace, name string) error {
+	svc, err := w.svcLister.Services(namespace).Get(name)
+	if err != nil || w.isOwnGlobalService(svc) {
+		return nil
+	}
+	return nameTakenError(svc)
+}
+
+func nameTakenError(svc *corev1.Service) error {
+	return fmt.Errorf("global service name %v is taken by Service %v/%v, which isn't a global service", svc.Name, svc.Namespace, svc.Name)
+}
+
+// serviceName returns name of the service in target cluster mirrored service comes from.
+func (w *Watcher) serviceName(targetSvcName, targetClusterName string) string {
+	return w.naming.ServiceName(targetSvcName, targetClusterName)
+}
+
+// autoGlobalName returns name of the global service automatically aggregating the service, empty when it can't be named.
+func (w *Watcher) autoGlobalName(service string) string {
+	name, err := w.naming.GlobalName(service)
+	if err != nil {
+		w.log.Errorf("Unable to name global service: %v", err)
+		return ""
+	}
+	return name
+}
+
+// globalServiceNameOf returns the name of global service generated for the GlobalService.
+func (w *Watcher) globalServiceNameOf(gs *mirrorv1alpha1.GlobalService) string {
+	if gs.Spec.Name != "" {
+		return gs.Spec.Name
+	}
+	return w.autoGlobalName(gs.Spec.Service)
+}
+
+// autoAggregatedServices returns services in target clusters, mirrored services or endpointslices of which
+// are automatically aggregated into the global service. More than one of them means their names collide.
+func (w *Watcher) autoAggregatedServices(namespace, globalSvcName string, gss []*mirrorv1alpha1.GlobalService) []string {
+	declared := make(map[string]bool, len(gss))
+	for _, gs := range gss {
+		declared[gs.Spec.Service] = true
+	}
+
+	found := make(map[string]bool)
+	add := func(targetSvcName, targetClusterName string) {
+		service := w.serviceName(targetSvcName, targetClusterName)
+		if !declared[service] {
+			found[service] = true
+		}
+	}
+
+	// Listers only read from the cache, errors don't really happen.
+	key := globalKey(namespace, globalSvcName)
+	svcs, err := w.indexedServices(autoGlobalNameIndex, key)
+	if err != nil {
+		w.log.Errorf("Unable to list services from cache: %v", err)
+	}
+	for _, svc := range svcs {
+		if w.Filter(svc.ObjectMeta) {
+			add(svc.Name, svc.GetLabels()[clusterNameLabel])
+		}
+	}
+	slices, err := w.indexedEndpointSlices(autoGlobalNameIndex, key)
+	if err != nil {
+		w.log.Errorf("Unable to list endpointslices from cache: %v", err)
+	}
+	for _, eps := range slices {
+		if w.Filter(eps.ObjectMeta) {
+			add(eps.GetLabels()[serviceNameLabel], eps.GetLabels()[clusterNameLabel])
+		}
+	}
+
+	services := make([]string, 0, len(found))
+	for service := range found {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	return services
+}
@@ -0,0 +1,320 @@
+package watcher
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	mirrorv1alpha1 "github.com/rushi47/service-mirror-prototype/apis/mirror/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestDefaultNaming(t *testing.T) {
+	tests := []struct {
+		name          string
+		targetSvcName string
+		cluster       string
+		want          string
+		wantGlobal    string
+	}{
+		{name: "simple", targetSvcName: "x-target1", cluster: "target1", want: "x", wantGlobal: "x-global"},
+		{name: "hyphenated service", targetSvcName: "web-app-target1", cluster: "target1", want: "web-app", wantGlobal: "web-app-global"},
+		{name: "hyphenated cluster", targetSvcName: "x-us-east-1", cluster: "us-east-1", want: "x", wantGlobal: "x-global"},
+		{name: "cluster with digits", targetSvcName: "x-target10", cluster: "target10", want: "x", wantGlobal: "x-global"},
+		{name: "no cluster suffix", targetSvcName: "x", cluster: "target1", want: "x", wantGlobal: "x-global"},
+		{name: "cluster inside service name", targetSvcName: "target1-api-target1", cluster: "target1", want: "target1-api", wantGlobal: "target1-api-global"},
+		{name: "cluster in the middle only", targetSvcName: "api-target1-v2", cluster: "target1", want: "api-target1-v2", wantGlobal: "api-target1-v2-global"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			naming := DefaultNaming{}
+			got := naming.ServiceName(tt.targetSvcName, tt.cluster)
+			if got != tt.want {
+				t.Errorf("ServiceName(%q, %q) = %q, want %q", tt.targetSvcName, tt.cluster, got, tt.want)
+			}
+			if global, err := naming.GlobalName(got); err != nil || global != tt.wantGlobal {
+				t.Errorf("GlobalName(%q) = %q, %v, want %q", got, global, err, tt.wantGlobal)
+			}
+		})
+	}
+}
+
+func TestTemplateNaming(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{name: "suffix", template: "{{.Service}}-all", want: "x-all"},
+		{name: "prefix", template: "global-{{.Service}}", want: "global-x"},
+		{name: "unknown field", template: "{{.Cluster}}-global", wantErr: true},
+		{name: "broken template", template: "{{.Service", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			naming, err := NewTemplateNaming(tt.template)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewTemplateNaming(%q) error = %v, wantErr %v", tt.template, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got, err := naming.GlobalName("x"); err != nil || got != tt.want {
+				t.Errorf("GlobalName(%q) = %q, %v, want %q", "x", got, err, tt.want)
+			}
+			if got := naming.ServiceName("x-target1", "target1"); got != "x" {
+				t.Errorf("ServiceName() = %q, want %q", got, "x")
+			}
+		})
+	}
+}
+
+func TestAggregationForNaming(t *testing.T) {
+	mustTemplate := func(text string) NamingStrategy {
+		naming, err := NewTemplateNaming(text)
+		if err != nil {
+			t.Fatalf("NewTemplateNaming(%q) error = %v", text, err)
+		}
+		return naming
+	}
+	declared := func(service, name string) *mirrorv1alpha1.GlobalService {
+		return &mirrorv1alpha1.GlobalService{
+			ObjectMeta: metav1.ObjectMeta{Name: service, Namespace: testGlobalNamespace},
+			Spec:       mirrorv1alpha1.GlobalServiceSpec{Service: service, Name: name},
+		}
+	}
+
+	tests := []struct {
+		name          string
+		naming        NamingStrategy
+		objects       []runtime.Object
+		globalSvcName string
+		wantService   string
+		wantErr       bool
+		wantProblems  int
+		wantCreated   bool
+	}{
+		{
+			name:          "template name",
+			naming:        mustTemplate("{{.Service}}-all"),
+			objects:       []runtime.Object{mirroredService("x", "target1")},
+			globalSvcName: "x-all",
+			wantService:   "x",
+			wantCreated:   true,
+		},
+		{
+			name:          "default name isn't used with template",
+			naming:        mustTemplate("{{.Service}}-all"),
+			objects:       []runtime.Object{mirroredService("x", "target1")},
+			globalSvcName: "x-global",
+		},
+		{
+			name:          "mirrored endpointslice alone",
+			objects:       []runtime.Object{mirroredEndpointSlice("x", "target1")},
+			globalSvcName: "x-global",
+			wantService:   "x",
+		},
+		{
+			name:          "collision",
+			naming:        mustTemplate("everything"),
+			objects:       []runtime.Object{mirroredService("x", "target1"), mirroredService("y", "target1")},
+			globalSvcName: "everything",
+			wantErr:       true,
+		},
+		{
+			name:          "too long",
+			objects:       []runtime.Object{mirroredService(strings.Repeat("x", 60), "target1")},
+			globalSvcName: strings.Repeat("x", 60) + "-global",
+			wantErr:       true,
+		},
+		{
+			name:          "GlobalService wins collision",
+			objects:       []runtime.Object{mirroredService("x", "target1"), mirroredService("web", "target1"), declared("web", "x-global")},
+			globalSvcName: "x-global",
+			wantService:   "web",
+			wantProblems:  1,
+			wantCreated:   true,
+		},
+		{
+			name:          "invalid name of GlobalService",
+			objects:       []runtime.Object{mirroredService("web", "target1"), declared("web", "Web_Global")},
+			globalSvcName: "Web_Global",
+			wantService:   "web",
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWatcher(t, Options{AutoAggregate: true, Naming: tt.naming}, tt.objects...)
+
+			agg, err := w.aggregationFor(testGlobalNamespace, tt.globalSvcName)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("aggregationFor(%q) error = %v, wantErr %v", tt.globalSvcName, err, tt.wantErr)
+			}
+			service := ""
+			if agg != nil {
+				service = agg.service
+				if len(agg.problems) != tt.wantProblems {
+					t.Errorf("problems = %v, want %v of them", agg.problems, tt.wantProblems)
+				}
+			}
+			if service != tt.wantService {
+				t.Errorf("aggregationFor(%q) service = %q, want %q", tt.globalSvcName, service, tt.wantService)
+			}
+
+			// Nothing gets created for names which can't be used. Endpointslice alone doesn't create it either.
+			if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, tt.globalSvcName)); err != nil {
+				t.Fatalf("reconcileGlobalService() error = %v", err)
+			}
+			syncCache(t, w)
+			_, err = w.svcLister.Services(testGlobalNamespace).Get(tt.globalSvcName)
+			if created := err == nil; created != tt.wantCreated {
+				t.Errorf("global service %v created = %v, want %v", tt.globalSvcName, created, tt.wantCreated)
+			}
+		})
+	}
+}
+
+func TestReconcileGlobalServiceCollision(t *testing.T) {
+	declared := func(name string, created time.Time) *mirrorv1alpha1.GlobalService {
+		return &mirrorv1alpha1.GlobalService{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testGlobalNamespace, CreationTimestamp: metav1.NewTime(created)},
+			Spec:       mirrorv1alpha1.GlobalServiceSpec{Service: "x", Name: "x-all"},
+		}
+	}
+	now := time.Now()
+	w := newTestWatcher(t, Options{},
+		mirroredService("x", "target1", servicePort("http", 80, 8080)), mirroredEndpointSlice("x", "target1", "x-0"),
+		declared("newer", now), declared("older", now.Add(-time.Hour)))
+
+	if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-all")); err != nil {
+		t.Fatalf("reconcileGlobalService() error = %v", err)
+	}
+	if _, err := w.clientset.CoreV1().Services(testGlobalNamespace).Get(context.Background(), "x-all", metav1.GetOptions{}); err != nil {
+		t.Errorf("getting global service of the older GlobalService: %v", err)
+	}
+
+	// Ignored GlobalService gets the event and the status.
+	ignoredEvent := false
+	for len(w.recorder.(*record.FakeRecorder).Events) > 0 {
+		event := <-w.recorder.(*record.FakeRecorder).Events
+		if strings.Contains(event, " "+reasonNameCollision+" Not reconciling: GlobalService older") {
+			ignoredEvent = true
+		}
+	}
+	if !ignoredEvent {
+		t.Errorf("no %v event recorded on ignored GlobalService", reasonNameCollision)
+	}
+	gs, err := w.mirrorClient.MirrorV1alpha1().GlobalServices(testGlobalNamespace).Get(context.Background(), "newer", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting GlobalService: %v", err)
+	}
+	ready := meta.FindStatusCondition(gs.Status.Conditions, mirrorv1alpha1.ConditionReady)
+	if ready == nil || ready.Status != metav1.ConditionFalse || !strings.Contains(ready.Message, "older") {
+		t.Errorf("Ready condition of ignored GlobalService = %+v, want false pointing at older", ready)
+	}
+	if degraded := meta.FindStatusCondition(gs.Status.Conditions, mirrorv1alpha1.ConditionDegraded); degraded == nil || degraded.Status != metav1.ConditionTrue {
+		t.Errorf("Degraded condition of ignored GlobalService = %+v, want true", degraded)
+	}
+}
+
+func TestAggregationForSameCreationTime(t *testing.T) {
+	created := metav1.NewTime(time.Now().Truncate(time.Second))
+	declared := func(name string) *mirrorv1alpha1.GlobalService {
+		return &mirrorv1alpha1.GlobalService{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testGlobalNamespace, CreationTimestamp: created},
+			Spec:       mirrorv1alpha1.GlobalServiceSpec{Service: "x", Name: "x-all"},
+		}
+	}
+	w := newTestWatcher(t, Options{}, declared("c"), declared("a"), declared("b"))
+
+	// Lister order is random, owner mustn't follow it.
+	for i := 0; i < 20; i++ {
+		agg, err := w.aggregationFor(testGlobalNamespace, "x-all")
+		if err != nil {
+			t.Fatalf("aggregationFor() error = %v", err)
+		}
+		ignored := make([]string, 0, len(agg.ignored))
+		for _, gs := range agg.ignored {
+			ignored = append(ignored, gs.Name)
+		}
+		if agg.globalService.Name != "a" || !reflect.DeepEqual(ignored, []string{"b", "c"}) {
+			t.Fatalf("owner = %v, ignored = %v, want a owning and b, c ignored", agg.globalService.Name, ignored)
+		}
+	}
+}
+
+func TestReconcileNameTaken(t *testing.T) {
+	tests := []struct {
+		name string
+		// Service which has the name of global service.
+		taken   *corev1.Service
+		objects []runtime.Object
+		// Services of users only show up when global service is created, which is retried.
+		wantErr bool
+	}{
+		{
+			name:  "service of users",
+			taken: &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "x-global", Namespace: testGlobalNamespace}},
+			objects: []runtime.Object{&mirrorv1alpha1.GlobalService{
+				ObjectMeta: metav1.ObjectMeta{Name: "x", Namespace: testGlobalNamespace},
+				Spec:       mirrorv1alpha1.GlobalServiceSpec{Service: "x"},
+			}},
+			wantErr: true,
+		},
+		{
+			name:  "mirrored service",
+			taken: inNamespace(mirroredService("y", "target1"), testGlobalNamespace).(*corev1.Service),
+			objects: []runtime.Object{&mirrorv1alpha1.GlobalService{
+				ObjectMeta: metav1.ObjectMeta{Name: "x", Namespace: testGlobalNamespace},
+				Spec:       mirrorv1alpha1.GlobalServiceSpec{Service: "x", Name: "y-target1"},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := append(tt.objects, tt.taken.DeepCopy(),
+				mirroredService("x", "target1", servicePort("http", 80, 8080)), mirroredEndpointSlice("x", "target1", "x-0"))
+			w := newTestWatcher(t, Options{}, objects...)
+			if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, tt.taken.Name)); (err != nil) != tt.wantErr {
+				t.Fatalf("reconcileGlobalService() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			syncCache(t, w)
+
+			svc, err := w.clientset.CoreV1().Services(testGlobalNamespace).Get(context.Background(), tt.taken.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("getting %v: %v", tt.taken.Name, err)
+			}
+			if !reflect.DeepEqual(svc.Labels, tt.taken.Labels) || len(svc.Finalizers) > 0 || len(svc.Spec.Ports) > 0 {
+				t.Errorf("%v taken over: labels %v, finalizers %v, ports %v", tt.taken.Name, svc.Labels, svc.Finalizers, svc.Spec.Ports)
+			}
+			gs, err := w.mirrorClient.MirrorV1alpha1().GlobalServices(testGlobalNamespace).Get(context.Background(), "x", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("getting GlobalService: %v", err)
+			}
+			ready := meta.FindStatusCondition(gs.Status.Conditions, mirrorv1alpha1.ConditionReady)
+			if ready == nil || ready.Status != metav1.ConditionFalse || !strings.Contains(ready.Message, "isn't a global service") {
+				t.Errorf("Ready condition = %+v, want false as the name is taken", ready)
+			}
+
+			// Nothing asks for it anymore, it still isn't deleted.
+			if err := w.mirrorClient.MirrorV1alpha1().GlobalServices(testGlobalNamespace).Delete(context.Background(), "x", metav1.DeleteOptions{}); err != nil {
+				t.Fatalf("deleting GlobalService: %v", err)
+			}
+			syncCache(t, w)
+			if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, tt.taken.Name)); err != nil {
+				t.Fatalf("reconcileGlobalService() error = %v", err)
+			}
+			if _, err := w.clientset.CoreV1().Services(testGlobalNamespace).Get(context.Background(), tt.taken.Name, metav1.GetOptions{}); err != nil {
+				t.Errorf("%v deleted: %v", tt.taken.Name, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package watcher
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+)
+
+// ReadinessPolicy is what happens to endpoints of mirrored endpointslices which aren't ready.
+type ReadinessPolicy string
+
+const (
+	// ReadinessPublishAll copies endpoints with their conditions as they are.
+	ReadinessPublishAll ReadinessPolicy = "publish-all"
+	// ReadinessReadyOnly leaves out endpoints which aren't ready.
+	ReadinessReadyOnly ReadinessPolicy = "ready-only"
+	// ReadinessMarkNotServing keeps endpoints which aren't serving, marked as not ready and terminating,
+	// so nothing falls back to them either.
+	ReadinessMarkNotServing ReadinessPolicy = "mark-not-serving"
+)
+
+// ParseReadinessPolicy returns the policy, empty string being publish-all.
+func ParseReadinessPolicy(policy string) (ReadinessPolicy, error) {
+	switch ReadinessPolicy(policy) {
+	case "", ReadinessPublishAll:
+		return ReadinessPublishAll, nil
+	case ReadinessReadyOnly, ReadinessMarkNotServing:
+		return ReadinessPolicy(policy), nil
+	}
+	return "", fmt.Errorf("unknown readiness policy %q, use one of %v, %v or %v", policy, ReadinessPublishAll, ReadinessReadyOnly, ReadinessMarkNotServing)
+}
+
+// isReady is what the EndpointSlice API says, unknown readiness means ready.
+func isReady(ep discoveryv1.Endpoint) bool {
+	return ep.Conditions.Ready == nil || *ep.Conditions.Ready
+}
+
+// isServing falls back to readiness, for endpoints without serving condition.
+func isServing(ep discoveryv1.Endpoint) bool {
+	if ep.Conditions.Serving != nil {
+		return *ep.Conditions.Serving
+	}
+	return isReady(ep)
+}
+
+// applyReadiness applies readiness policy to endpoints of global endpointslice.
+func (w *Watcher) applyReadiness(endpoints []discoveryv1.Endpoint) []discoveryv1.Endpoint {
+	switch w.readinessPolicy {
+	case ReadinessReadyOnly:
+		ready := make([]discoveryv1.Endpoint, 0, len(endpoints))
+		for _, ep := range endpoints {
+			if isReady(ep) {
+				ready = append(ready, ep)
+			}
+		}
+		return ready
+	case ReadinessMarkNotServing:
+		notReady, terminating := false, true
+		for i := range endpoints {
+			if !isServing(endpoints[i]) {
+				endpoints[i].Conditions = discoveryv1.EndpointConditions{Ready: &notReady, Serving: &notReady, Terminating: &terminating}
+			}
+		}
+	}
+	return endpoints
+}
+
+// minReadyEndpoints returns how many ready endpoints the cluster needs, to be part of global services.
+func (w *Watcher) minReadyEndpoints(cluster string) int {
+	if min, ok := w.clusterMinReadyEndpoints[cluster]; ok {
+		return min
+	}
+	return w.defaultMinReadyEndpoints
+}
+
+// readyEndpoints returns number of ready endpoints in mirrored endpointslices of global service, by cluster.
+// Endpoints without hostname (gateway IP) aren't pods, they don't count. With keep-last-known gateway IP policy,
+// mirrored endpointslices having them count the ready endpoints of their existing global endpointslices instead,
+// which stay in global service until they recover.
+func (w *Watcher) readyEndpoints(targetEps []*discoveryv1.EndpointSlice, existing map[string][]*discoveryv1.EndpointSlice, clusterIPMirror map[string]bool) map[string]int {
+	ready := make(map[string]int)
+	for _, eps := range targetEps {
+		cluster := eps.GetLabels()[clusterNameLabel]
+		if _, ok := ready[cluster]; !ok {
+			ready[cluster] = 0
+		}
+		if w.gatewayIPPolicy == GatewayIPKeepLastKnown && hasGatewayIPs(eps) && !clusterIPMirror[mirrorOf(eps)] {
+			for _, shard := range existing[eps.Namespace+"/"+eps.Name] {
+				for _, ep := range shard.Endpoints {
+					if isReady(ep) {
+						ready[cluster]++
+					}
+				}
+			}
+			continue
+		}
+		for _, ep := range eps.Endpoints {
+			if ep.Hostname != nil && isReady(ep) {
+				ready[cluster]++
+			}
+		}
+	}
+	return ready
+}
+
+// splitReadyEndpoints returns number of ready endpoints in mirrored endpointslices by cluster, which traffic split
+// weights default to. Unlike readyEndpoints, endpoints without hostname count, as traffic split reaches clusters
+// through mirrored services, gateway IP included.
+func splitReadyEndpoints(targetEps []*discoveryv1.EndpointSlice) map[string]int {
+	ready := make(map[string]int)
+	for _, eps := range targetEps {
+		for _, ep := range eps.Endpoints {
+			if isReady(ep) {
+				ready[eps.GetLabels()[clusterNameLabel]]++
+			}
+		}
+	}
+	return ready
+}
+
+// withdrawnClusters returns clusters with less ready endpoints than they need. Their endpoints are withdrawn
+// from global service, so it only answers with clusters which can take the traffic. Withdrawal is counted and
+// recorded once, when cluster stops serving, and reported on status for as long as it lasts.
+func (w *Watcher) withdrawnClusters(agg *aggregation, ready map[string]int) map[string]bool {
+	key := globalKey(agg.namespace, agg.name)
+	w.withdrawalsMu.Lock()
+	previous := w.withdrawals[key]
+	w.withdrawalsMu.Unlock()
+
+	withdrawn := make(map[string]bool)
+	// Whether the event of withdrawn clusters was recorded.
+	recorded := make(map[string]bool)
+	for cluster, count := range ready {
+		min := w.minReadyEndpoints(cluster)
+		if count >= min {
+			continue
+		}
+		withdrawn[cluster] = true
+		message := fmt.Sprintf("Endpoints of cluster %v withdrawn: %v ready endpoints, below minimum of %v", cluster, count, min)
+		agg.problems = append(agg.problems, message)
+		wasRecorded, wasWithdrawn := previous[cluster]
+		if !wasWithdrawn {
+			clusterWithdrawalsTotal.WithLabelValues(agg.namespace, agg.name, cluster).Inc()
+			w.log.Warnf("Global service Name=%v/%v: %v", agg.namespace, agg.name, message)
+		}
+		// Global service created by this reconcile isn't in cache yet, the event is recorded once it is.
+		recorded[cluster] = wasRecorded || w.record(agg, corev1.EventTypeWarning, reasonClusterWithdrawn, message)
+	}
+
+	w.withdrawalsMu.Lock()
+	defer w.withdrawalsMu.Unlock()
+	if len(recorded) == 0 {
+		delete(w.withdrawals, key)
+	} else {
+		w.withdrawals[key] = recorded
+	}
+	return withdrawn
+}
+
+// forgetWithdrawals drops withdrawn clusters of global service, by its key.
+func (w *Watcher) forgetWithdrawals(key string) {
+	w.withdrawalsMu.Lock()
+	defer w.withdrawalsMu.Unlock()
+	delete(w.withdrawals, key)
+}
@@ -0,0 +1,201 @@
+package watcher
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	mirrorv1alpha1 "github.com/rushi47/service-mirror-prototype/apis/mirror/v1alpha1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// withReadiness sets ready condition of endpoints of mirrored endpointslice, in order.
+func withReadiness(eps *discoveryv1.EndpointSlice, ready ...bool) *discoveryv1.EndpointSlice {
+	for i := range ready {
+		ready := ready[i]
+		eps.Endpoints[i].Conditions = discoveryv1.EndpointConditions{Ready: &ready, Serving: &ready}
+	}
+	return eps
+}
+
+func TestParseReadinessPolicy(t *testing.T) {
+	tests := []struct {
+		policy  string
+		want    ReadinessPolicy
+		wantErr bool
+	}{
+		{policy: "", want: ReadinessPublishAll},
+		{policy: "publish-all", want: ReadinessPublishAll},
+		{policy: "ready-only", want: ReadinessReadyOnly},
+		{policy: "mark-not-serving", want: ReadinessMarkNotServing},
+		{policy: "healthy", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			got, err := ParseReadinessPolicy(tt.policy)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ParseReadinessPolicy(%q) = %q, %v, want %q, wantErr %v", tt.policy, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplyReadiness(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		policy ReadinessPolicy
+		// Conditions of x-0 (ready), x-1 (not ready) and x-2 (unknown) in global endpointslice.
+		want map[string]discoveryv1.EndpointConditions
+	}{
+		{policy: ReadinessPublishAll, want: map[string]discoveryv1.EndpointConditions{
+			"x-0-target1": {Ready: &yes, Serving: &yes},
+			"x-1-target1": {Ready: &no, Serving: &no},
+			"x-2-target1": {},
+		}},
+		{policy: ReadinessReadyOnly, want: map[string]discoveryv1.EndpointConditions{
+			"x-0-target1": {Ready: &yes, Serving: &yes},
+			"x-2-target1": {},
+		}},
+		{policy: ReadinessMarkNotServing, want: map[string]discoveryv1.EndpointConditions{
+			"x-0-target1": {Ready: &yes, Serving: &yes},
+			"x-1-target1": {Ready: &no, Serving: &no, Terminating: &yes},
+			"x-2-target1": {},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			w := newTestWatcher(t, Options{ReadinessPolicy: tt.policy})
+			endpoints, _ := w.globalEndpoints(*withReadiness(mirroredEndpointSlice("x", "target1", "x-0", "x-1", "x-2"), true, false), nil)
+
+			got := make(map[string]discoveryv1.EndpointConditions)
+			for _, ep := range w.applyReadiness(endpoints) {
+				got[*ep.Hostname] = ep.Conditions
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("applyReadiness() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileMinReadyEndpoints(t *testing.T) {
+	tests := []struct {
+		name             string
+		minReady         int
+		clusterMinReady  map[string]int
+		wantClusters     []string
+		wantWithdrawnEvt bool
+	}{
+		{name: "no minimum", wantClusters: []string{"target1", "target2"}},
+		{name: "target1 below minimum", minReady: 2, wantClusters: []string{"target2"}, wantWithdrawnEvt: true},
+		{name: "target1 minimum overridden", minReady: 2, clusterMinReady: map[string]int{"target1": 1}, wantClusters: []string{"target1", "target2"}},
+		{name: "both below minimum", minReady: 3, wantClusters: []string{}, wantWithdrawnEvt: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWatcher(t, Options{AutoAggregate: true, MinReadyEndpoints: tt.minReady, ClusterMinReadyEndpoints: tt.clusterMinReady},
+				mirroredService("x", "target1", servicePort("http", 80, 8080)),
+				mirroredService("x", "target2", servicePort("http", 80, 8080)),
+				// Only x-0 of target1 is ready, both endpoints of target2 are.
+				withReadiness(mirroredEndpointSlice("x", "target1", "x-0", "x-1"), true, false),
+				mirroredEndpointSlice("x", "target2", "x-0", "x-1"))
+			// Events are recorded on global service, once it's in cache.
+			for i := 0; i < 2; i++ {
+				if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+					t.Fatalf("reconcileGlobalService() error = %v", err)
+				}
+				syncCache(t, w)
+			}
+
+			if got := clustersWithSlices(t, w, "x", "target1", "target2"); !reflect.DeepEqual(got, tt.wantClusters) {
+				t.Errorf("clusters with global endpointslices = %v, want %v", got, tt.wantClusters)
+			}
+			if gotEvt := hasEvent(w, reasonClusterWithdrawn); gotEvt != tt.wantWithdrawnEvt {
+				t.Errorf("%v event recorded = %v, want %v", reasonClusterWithdrawn, gotEvt, tt.wantWithdrawnEvt)
+			}
+		})
+	}
+}
+
+func TestReconcileWithdrawalReportedOnce(t *testing.T) {
+	gs := &mirrorv1alpha1.GlobalService{
+		ObjectMeta: metav1.ObjectMeta{Name: "x", Namespace: testGlobalNamespace},
+		Spec:       mirrorv1alpha1.GlobalServiceSpec{Service: "x"},
+	}
+	w := newTestWatcher(t, Options{MinReadyEndpoints: 2}, gs,
+		mirroredService("x", "target1", servicePort("http", 80, 8080)),
+		withReadiness(mirroredEndpointSlice("x", "target1", "x-0", "x-1"), true, false))
+	withdrawals := func() float64 {
+		return testutil.ToFloat64(clusterWithdrawalsTotal.WithLabelValues(testGlobalNamespace, "x-global", "target1"))
+	}
+	reconcile := func() {
+		t.Helper()
+		syncCache(t, w)
+		if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+			t.Fatalf("reconcileGlobalService() error = %v", err)
+		}
+	}
+
+	before := withdrawals()
+	reconcile()
+	if !hasEvent(w, reasonClusterWithdrawn) {
+		t.Errorf("no %v event recorded once cluster is withdrawn", reasonClusterWithdrawn)
+	}
+	// Still withdrawn, nothing new to report.
+	reconcile()
+	reconcile()
+	if hasEvent(w, reasonClusterWithdrawn) {
+		t.Errorf("%v event recorded again while cluster stays withdrawn", reasonClusterWithdrawn)
+	}
+	if got := withdrawals() - before; got != 1 {
+		t.Errorf("withdrawals counted = %v, want 1", got)
+	}
+
+	// Serving again, then withdrawn again.
+	applyObject(t, w.clientset, withReadiness(mirroredEndpointSlice("x", "target1", "x-0", "x-1"), true, true))
+	reconcile()
+	applyObject(t, w.clientset, withReadiness(mirroredEndpointSlice("x", "target1", "x-0", "x-1"), true, false))
+	reconcile()
+	if !hasEvent(w, reasonClusterWithdrawn) {
+		t.Errorf("no %v event recorded once cluster is withdrawn again", reasonClusterWithdrawn)
+	}
+	if got := withdrawals() - before; got != 2 {
+		t.Errorf("withdrawals counted = %v, want 2", got)
+	}
+}
+
+func TestReconcileMinReadyEndpointsKeepsLastKnownGatewayIPs(t *testing.T) {
+	w := newTestWatcher(t, Options{AutoAggregate: true, MinReadyEndpoints: 1, GatewayIPPolicy: GatewayIPKeepLastKnown, GatewayIPRetryPeriod: time.Minute},
+		mirroredService("x", "target1", servicePort("http", 80, 8080)),
+		mirroredEndpointSlice("x", "target1", "x-0", "x-1"))
+	reconcile := func() {
+		t.Helper()
+		if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+			t.Fatalf("reconcileGlobalService() error = %v", err)
+		}
+		syncCache(t, w)
+	}
+	reconcile()
+
+	// Linkerd briefly puts the gateway IP in place of every endpoint.
+	applyObject(t, w.clientset, mirroredEndpointSlice("x", "target1", "", ""))
+	syncCache(t, w)
+	reconcile()
+
+	if hasEvent(w, reasonClusterWithdrawn) {
+		t.Errorf("%v event recorded, want cluster kept with its last known endpoints", reasonClusterWithdrawn)
+	}
+	got := make([]string, 0)
+	for _, eps := range globalSlicesOf(t, w, "x-target1") {
+		for _, ep := range eps.Endpoints {
+			got = append(got, *ep.Hostname)
+		}
+	}
+	sort.Strings(got)
+	if want := []string{"x-0-target1", "x-1-target1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("hostnames = %v, want last known %v", got, want)
+	}
+}
@@ -0,0 +1,310 @@
+package watcher
+
+import (
+	"fmt"
+	"sort"
+
+	mirrorv1alpha1 "github.com/rushi47/service-mirror-prototype/apis/mirror/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apiError "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// runWorker keeps on processing the items from queue, until queue is shut down or worker is stopped.
+func (w *Watcher) runWorker(stopCh <-chan struct{}) {
+	for w.processNextItem(stopCh) {
+	}
+}
+
+func (w *Watcher) processNextItem(stopCh <-chan struct{}) bool {
+	key, quit := w.queue.Get()
+	if quit {
+		return false
+	}
+	select {
+	case <-stopCh:
+		// Workers are stopping, don't start on anything new.
+		w.queue.Done(key)
+		return false
+	default:
+	}
+	// Let queue know we are done with the key, so it can be handed again to other workers.
+	defer w.queue.Done(key)
+
+	w.health.startedProcessing(key.(string))
+	defer w.health.doneProcessing(key.(string))
+
+	err := w.reconcileGlobalService(key.(string))
+	w.handleErr(err, key)
+	return true
+}
+
+// handleErr forgets the key on success, otherwise requeues it with exponential backoff.
+func (w *Watcher) handleErr(err error, key interface{}) {
+	if err == nil {
+		w.queue.Forget(key)
+		return
+	}
+
+	w.log.Errorf("Failed to reconcile global service Name=%v, requeuing (retries=%v): %v", key, w.queue.NumRequeues(key), err)
+	w.queue.AddRateLimited(key)
+}
+
+// reconcileGlobalService computes what global service and its endpointslices should look like
+// from the mirrored services and endpointslices in cache, and makes cluster match it.
+// Key is namespace/name of the global service.
+func (w *Watcher) reconcileGlobalService(key string) error {
+	w.log.Debugf("Reconciling global service Name=%v", key)
+	namespace, globalSvcName, err := splitGlobalKey(key)
+	if err != nil {
+		// Retrying doesn't fix the key.
+		w.log.Errorf("Not reconciling global service: %v", err)
+		return nil
+	}
+
+	// When nobody asks for this global service, there is nothing mirrored for it.
+	agg, err := w.aggregationFor(namespace, globalSvcName)
+	if err != nil {
+		// Retrying doesn't fix the name, global service is left as it is until mirrored services or GlobalServices change.
+		namingErrorsTotal.WithLabelValues(namespace, globalSvcName).Inc()
+		if agg == nil {
+			w.log.Errorf("Not reconciling global service Name=%v: %v", key, err)
+			return nil
+		}
+		w.warnf(agg, reasonInvalidName, "Not reconciling: %v", err)
+		return utilerrors.NewAggregate([]error{w.updateGlobalServiceStatus(agg, err), w.reportIgnored(agg)})
+	}
+
+	err = w.syncGlobalService(namespace, globalSvcName, agg)
+	if agg == nil {
+		return err
+	}
+	if agg.requeueAfter > 0 {
+		w.queue.AddAfter(key, agg.requeueAfter)
+	}
+
+	if err != nil {
+		w.warnf(agg, reasonSyncFailed, "Failed to sync: %v", err)
+	}
+	if agg.globalService != nil {
+		if statusErr := utilerrors.NewAggregate([]error{w.updateGlobalServiceStatus(agg, err), w.reportIgnored(agg)}); statusErr != nil {
+			return utilerrors.NewAggregate([]error{err, statusErr})
+		}
+	}
+	return err
+}
+
+func (w *Watcher) syncGlobalService(namespace, globalSvcName string, agg *aggregation) error {
+	globalEps, err := w.globalEndpointSlices(namespace, globalSvcName)
+	if err != nil {
+		return err
+	}
+
+	targetSvcs := make([]*corev1.Service, 0)
+	targetEps := make([]*discoveryv1.EndpointSlice, 0)
+	if agg != nil {
+		if targetSvcs, err = w.mirroredServices(agg); err != nil {
+			return err
+		}
+		if targetEps, err = w.mirroredEndpointSlices(agg); err != nil {
+			return err
+		}
+		targetSvcs, targetEps = w.sourceNamespace(agg, targetSvcs, targetEps)
+		agg.mirroredServices = len(targetSvcs)
+	}
+
+	globalSvc, err := w.svcLister.Services(namespace).Get(globalSvcName)
+	switch {
+	case apiError.IsNotFound(err):
+		globalSvc = nil
+	case err != nil:
+		return fmt.Errorf("unable to get global service %v from cache: %w", globalSvcName, err)
+	case !w.isOwnGlobalService(globalSvc):
+		// Mirrored service having the name isn't ours to update or delete, aggregationFor reports it.
+		globalSvc = nil
+	}
+
+	// Global service is being deleted, clean up and let it go. It is recreated once gone, if still needed.
+	if globalSvc != nil && globalSvc.DeletionTimestamp != nil {
+		return runHandler(handlerServiceFinalize, func() error { return w.finalizeGlobalService(agg, globalSvc.DeepCopy()) })
+	}
+
+	// Nothing is mirrored anymore for this global service, remove everything we created for it.
+	// Global endpointslices go first, then the service, which goes away once it's finalized.
+	if len(targetSvcs) == 0 && len(targetEps) == 0 {
+		w.forgetFailover(globalKey(namespace, globalSvcName))
+		w.forgetWithdrawals(globalKey(namespace, globalSvcName))
+		var errs []error
+		for _, eps := range globalEps {
+			errs = append(errs, runHandler(handlerEpsDelete, func() error { return w.handleEpsDelete(*eps) }))
+		}
+		if err := utilerrors.NewAggregate(errs); err != nil {
+			return err
+		}
+		if globalSvc == nil {
+			return nil
+		}
+		return runHandler(handlerServiceDelete, func() error { return w.handleServiceDelete(namespace, globalSvcName) })
+	}
+
+	agg.headless = w.globalServiceType(agg, targetSvcs) != mirrorv1alpha1.ServiceTypeClusterIP
+	agg.ipFamilies = globalIPFamilies(globalSvc, targetSvcs, targetEps)
+	if globalSvc == nil {
+		if len(targetSvcs) > 0 {
+			err := runHandler(handlerServiceAdd, func() (err error) {
+				globalSvc, err = w.handleServiceAdd(agg, targetSvcs)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+	} else if (globalSvc.Spec.ClusterIP == corev1.ClusterIPNone) != agg.headless || primaryFamilyChanged(globalSvc, agg.ipFamilies) {
+		// Cluster IP can't be added or removed in place, neither can primary IP family change. Global endpointslices
+		// go along with the service, everything is created again once it's gone.
+		return runHandler(handlerServiceUpdate, func() error { return w.recreateGlobalService(agg, globalSvc) })
+	} else {
+		if err := runHandler(handlerServiceUpdate, func() error { return w.handleServiceUpdate(agg, globalSvc.DeepCopy(), targetSvcs) }); err != nil {
+			return err
+		}
+	}
+
+	// Endpoints of every mirrored endpointslice are spread over global endpointslices, labelled with the mirrored
+	// endpointslice. Mirrored service can have several endpointslices, each one only touches its own global ones.
+	existing := make(map[string][]*discoveryv1.EndpointSlice, len(globalEps))
+	for _, eps := range globalEps {
+		source := sourceOf(eps)
+		existing[source] = append(existing[source], eps)
+	}
+
+	weights := splitReadyEndpoints(targetEps)
+	// Global endpointslices of mirrors only reached through traffic split, if any, are left in existing and deleted.
+	targetEps = w.withoutSplitOnly(targetSvcs, targetEps)
+
+	// Same order every time, so the same endpoint loses its hostname when hostnames collide.
+	sort.Slice(targetEps, func(i, j int) bool {
+		ci, cj := targetEps[i].GetLabels()[clusterNameLabel], targetEps[j].GetLabels()[clusterNameLabel]
+		if ci != cj {
+			return ci < cj
+		}
+		return targetEps[i].Namespace+"/"+targetEps[i].Name < targetEps[j].Namespace+"/"+targetEps[j].Name
+	})
+	hostnames := hostnameClusters(targetEps)
+	taken := make(map[string]string)
+	clusterIP := clusterIPMirrors(targetSvcs)
+	ready := w.readyEndpoints(targetEps, existing, clusterIP)
+	withdrawn := w.withdrawnClusters(agg, ready)
+	if f := w.failoverFor(agg, globalSvc); f != nil {
+		// Only the active cluster is part of global service.
+		active := w.failoverCluster(agg, f, globalEps, ready, withdrawn)
+		for cluster := range ready {
+			if cluster != active {
+				withdrawn[cluster] = true
+			}
+		}
+	} else {
+		w.forgetFailover(globalKey(namespace, globalSvcName))
+	}
+
+	var errs []error
+	for _, eps := range targetEps {
+		shards := existing[eps.Namespace+"/"+eps.Name]
+		delete(existing, eps.Namespace+"/"+eps.Name)
+
+		// Get the addresses, modify hostname add target clustername at the end
+		endpoints, gatewayIPs := w.globalEndpoints(*eps, hostnames)
+		switch {
+		case withdrawn[eps.GetLabels()[clusterNameLabel]]:
+			// Global endpointslices of withdrawn cluster are deleted, until it is part of global service again.
+			endpoints = nil
+		case gatewayIPs > 0:
+			var ok bool
+			if endpoints, ok = w.handleGatewayIPs(agg, eps, endpoints, gatewayIPs, clusterIP[mirrorOf(eps)]); !ok {
+				// Last known endpoints stay in global endpointslices, they are still endpoints of global service.
+				for _, shard := range shards {
+					agg.endpoints[eps.GetLabels()[clusterNameLabel]] += len(shard.Endpoints)
+				}
+				continue
+			}
+		}
+		endpoints = w.applyReadiness(endpoints)
+		w.checkHostnames(agg, eps, endpoints, taken)
+		agg.endpoints[eps.GetLabels()[clusterNameLabel]] += len(endpoints)
+
+		if globalSvc == nil {
+			// Global endpointslices are owned by global service, they have to wait for it.
+			w.log.Debugf("Global service Name=%v doesn't exist yet, not syncing EndpointSlice %v/%v", globalSvcName, eps.Namespace, eps.Name)
+			continue
+		}
+		errs = append(errs, w.syncShards(globalSvc, eps, shards, endpoints))
+	}
+
+	if w.trafficSplit != TrafficSplitNone && globalSvc != nil {
+		errs = append(errs, runHandler(handlerTrafficSplit, func() error {
+			return w.syncTrafficSplit(agg, globalSvc, targetSvcs, weights, withdrawn)
+		}))
+	}
+
+	// Whatever is left doesn't have its mirrored endpointslice anymore.
+	for _, shards := range existing {
+		for _, eps := range shards {
+			errs = append(errs, runHandler(handlerEpsDelete, func() error { return w.handleEpsDelete(*eps) }))
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// sourceOf returns namespace/name of mirrored endpointslice, global endpointslice holds endpoints of. Mirrored
+// endpointslices of different namespaces can have the same name, and end up in the same global namespace.
+func sourceOf(globalEps *discoveryv1.EndpointSlice) string {
+	epsLabels := globalEps.GetLabels()
+	return epsLabels[sourceEndpointSliceNamespaceLabel] + "/" + epsLabels[sourceEndpointSliceLabel]
+}
+
+// mirroredServices returns the mirrored services from all target clusters aggregated by global service.
+func (w *Watcher) mirroredServices(agg *aggregation) ([]*corev1.Service, error) {
+	svcs, err := w.indexedServices(logicalServiceIndex, globalKey(agg.namespace, agg.service))
+	if err != nil {
+		return nil, fmt.Errorf("unable to list services from cache: %w", err)
+	}
+
+	targetSvcs := make([]*corev1.Service, 0)
+	for _, svc := range svcs {
+		if w.Filter(svc.ObjectMeta) && agg.includesCluster(svc.GetLabels()[clusterNameLabel]) {
+			targetSvcs = append(targetSvcs, svc)
+		}
+	}
+	return targetSvcs, nil
+}
+
+// mirroredEndpointSlices returns the mirrored endpointslices from all target clusters aggregated by global service.
+func (w *Watcher) mirroredEndpointSlices(agg *aggregation) ([]*discoveryv1.EndpointSlice, error) {
+	slices, err := w.indexedEndpointSlices(logicalServiceIndex, globalKey(agg.namespace, agg.service))
+	if err != nil {
+		return nil, fmt.Errorf("unable to list endpointslices from cache: %w", err)
+	}
+
+	targetEps := make([]*discoveryv1.EndpointSlice, 0)
+	for _, eps := range slices {
+		if w.Filter(eps.ObjectMeta) && agg.includesCluster(eps.GetLabels()[clusterNameLabel]) {
+			targetEps = append(targetEps, eps)
+		}
+	}
+	return targetEps, nil
+}
+
+// globalEndpointSlices returns the endpointslices we created for the global service.
+func (w *Watcher) globalEndpointSlices(namespace, globalSvcName string) ([]*discoveryv1.EndpointSlice, error) {
+	selector := labels.SelectorFromSet(labels.Set{
+		serviceNameLabel:  globalSvcName,
+		globalMirrorLabel: "true",
+	})
+	slices, err := w.epsLister.EndpointSlices(namespace).List(selector)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list global endpointslices of %v from cache: %w", globalSvcName, err)
+	}
+	return slices, nil
+}
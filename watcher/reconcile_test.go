@@ -0,0 +1,353 @@
+package watcher
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apiError "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+// globalState is what reconcile left behind in global namespace.
+type globalState struct {
+	// Ports of global service, nil when it doesn't exist.
+	ports []int32
+	// Hostnames in global endpointslices by mirrored service.
+	slices map[string][]string
+}
+
+func currentGlobalState(t *testing.T, client kubernetes.Interface) globalState {
+	t.Helper()
+	ctx := context.Background()
+	state := globalState{slices: make(map[string][]string)}
+
+	svc, err := client.CoreV1().Services(testGlobalNamespace).Get(ctx, "x-global", metav1.GetOptions{})
+	switch {
+	case apiError.IsNotFound(err):
+	case err != nil:
+		t.Fatalf("getting global service: %v", err)
+	default:
+		state.ports = make([]int32, 0)
+		for _, port := range svc.Spec.Ports {
+			state.ports = append(state.ports, port.Port)
+		}
+	}
+
+	selector := labels.SelectorFromSet(labels.Set{globalMirrorLabel: "true"}).String()
+	slices, err := client.DiscoveryV1().EndpointSlices(testGlobalNamespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		t.Fatalf("listing global endpointslices: %v", err)
+	}
+	for _, eps := range slices.Items {
+		targetSvcName := eps.GetLabels()[targetMirrorSvcNameLabel]
+		for _, ep := range eps.Endpoints {
+			state.slices[targetSvcName] = append(state.slices[targetSvcName], *ep.Hostname)
+		}
+	}
+	for _, hosts := range state.slices {
+		sort.Strings(hosts)
+	}
+	return state
+}
+
+func TestReconcileGlobalService(t *testing.T) {
+	http := servicePort("http", 80, 8080)
+	metrics := servicePort("metrics", 9090, 9090)
+
+	type step struct {
+		name string
+		// Mirrored objects to create or update, and ones to delete.
+		apply  []runtime.Object
+		delete []runtime.Object
+		want   globalState
+	}
+	tests := []struct {
+		name   string
+		dryRun bool
+		steps  []step
+	}{
+		{
+			name: "add, update and delete across clusters",
+			steps: []step{
+				{
+					name:  "first cluster is mirrored",
+					apply: []runtime.Object{mirroredService("x", "target1", http), mirroredEndpointSlice("x", "target1", "x-0", "x-1")},
+					want: globalState{
+						ports:  []int32{80},
+						slices: map[string][]string{"x-target1": {"x-0-target1", "x-1-target1"}},
+					},
+				},
+				{
+					name:  "second cluster with extra port",
+					apply: []runtime.Object{mirroredService("x", "target2", http, metrics), mirroredEndpointSlice("x", "target2", "x-0")},
+					want: globalState{
+						ports: []int32{80, 9090},
+						slices: map[string][]string{
+							"x-target1": {"x-0-target1", "x-1-target1"},
+							"x-target2": {"x-0-target2"},
+						},
+					},
+				},
+				{
+					name:  "pod goes away in first cluster",
+					apply: []runtime.Object{mirroredEndpointSlice("x", "target1", "x-1")},
+					want: globalState{
+						ports: []int32{80, 9090},
+						slices: map[string][]string{
+							"x-target1": {"x-1-target1"},
+							"x-target2": {"x-0-target2"},
+						},
+					},
+				},
+				{
+					name:  "second cluster drops extra port",
+					apply: []runtime.Object{mirroredService("x", "target2", http)},
+					want: globalState{
+						ports: []int32{80},
+						slices: map[string][]string{
+							"x-target1": {"x-1-target1"},
+							"x-target2": {"x-0-target2"},
+						},
+					},
+				},
+				{
+					name:  "second cluster adds it back",
+					apply: []runtime.Object{mirroredService("x", "target2", http, metrics)},
+					want: globalState{
+						ports: []int32{80, 9090},
+						slices: map[string][]string{
+							"x-target1": {"x-1-target1"},
+							"x-target2": {"x-0-target2"},
+						},
+					},
+				},
+				{
+					name:   "first cluster is unlinked",
+					delete: []runtime.Object{mirroredService("x", "target1"), mirroredEndpointSlice("x", "target1")},
+					want: globalState{
+						ports:  []int32{80, 9090},
+						slices: map[string][]string{"x-target2": {"x-0-target2"}},
+					},
+				},
+				{
+					name:   "only endpointslice is left",
+					delete: []runtime.Object{mirroredService("x", "target2")},
+					want: globalState{
+						ports:  []int32{80, 9090},
+						slices: map[string][]string{"x-target2": {"x-0-target2"}},
+					},
+				},
+				{
+					name:   "nothing is mirrored anymore",
+					delete: []runtime.Object{mirroredEndpointSlice("x", "target2")},
+					want:   globalState{slices: map[string][]string{}},
+				},
+			},
+		},
+		{
+			name: "gateway ip keeps last global endpointslice",
+			steps: []step{
+				{
+					name:  "mirrored",
+					apply: []runtime.Object{mirroredService("x", "target1", http), mirroredEndpointSlice("x", "target1", "x-0")},
+					want: globalState{
+						ports:  []int32{80},
+						slices: map[string][]string{"x-target1": {"x-0-target1"}},
+					},
+				},
+				{
+					name:  "endpoint gets gateway ip",
+					apply: []runtime.Object{mirroredEndpointSlice("x", "target1", "")},
+					want: globalState{
+						ports:  []int32{80},
+						slices: map[string][]string{"x-target1": {"x-0-target1"}},
+					},
+				},
+				{
+					name:  "gateway ip as the only endpointslice",
+					apply: []runtime.Object{mirroredService("x", "target2", http), mirroredEndpointSlice("x", "target2", "")},
+					want: globalState{
+						ports:  []int32{80},
+						slices: map[string][]string{"x-target1": {"x-0-target1"}},
+					},
+				},
+			},
+		},
+		{
+			name:   "dry run doesn't delete",
+			dryRun: true,
+			steps: []step{
+				{
+					name:  "mirrored",
+					apply: []runtime.Object{mirroredService("x", "target1", http), mirroredEndpointSlice("x", "target1", "x-0")},
+					want: globalState{
+						ports:  []int32{80},
+						slices: map[string][]string{"x-target1": {"x-0-target1"}},
+					},
+				},
+				{
+					name:   "unlinked",
+					delete: []runtime.Object{mirroredService("x", "target1"), mirroredEndpointSlice("x", "target1")},
+					want: globalState{
+						ports:  []int32{80},
+						slices: map[string][]string{"x-target1": {"x-0-target1"}},
+					},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWatcher(t, Options{AutoAggregate: true, DryRun: tt.dryRun})
+			for _, s := range tt.steps {
+				for _, obj := range s.apply {
+					applyObject(t, w.clientset, obj)
+				}
+				for _, obj := range s.delete {
+					deleteObject(t, w.clientset, obj)
+				}
+				syncCache(t, w)
+
+				if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+					t.Fatalf("%v: reconcileGlobalService() error = %v", s.name, err)
+				}
+
+				got := currentGlobalState(t, w.clientset)
+				if !reflect.DeepEqual(got, s.want) {
+					t.Fatalf("%v: global state = %+v, want %+v", s.name, got, s.want)
+				}
+
+				// Reconciling again, with global objects in cache, doesn't change anything.
+				syncCache(t, w)
+				if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+					t.Fatalf("%v: second reconcileGlobalService() error = %v", s.name, err)
+				}
+				if got := currentGlobalState(t, w.clientset); !reflect.DeepEqual(got, s.want) {
+					t.Fatalf("%v: global state after second reconcile = %+v, want %+v", s.name, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileMultipleSourceSlices(t *testing.T) {
+	first := mirroredEndpointSlice("x", "target1", "x-0", "x-1")
+	second := mirroredEndpointSlice("x", "target1", "x-2")
+	second.Name = "x-target1-fghij"
+	w := newTestWatcher(t, Options{AutoAggregate: true}, mirroredService("x", "target1", servicePort("http", 80, 8080)), first, second)
+
+	hostsBySource := func() map[string][]string {
+		hosts := make(map[string][]string)
+		for _, eps := range globalSlicesOf(t, w, "x-target1") {
+			source := eps.Labels[sourceEndpointSliceLabel]
+			for _, ep := range eps.Endpoints {
+				hosts[source] = append(hosts[source], *ep.Hostname)
+			}
+		}
+		return hosts
+	}
+	reconcile := func() {
+		t.Helper()
+		syncCache(t, w)
+		if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+			t.Fatalf("reconcileGlobalService() error = %v", err)
+		}
+	}
+
+	reconcile()
+	want := map[string][]string{
+		"x-target1-abcde": {"x-0-target1", "x-1-target1"},
+		"x-target1-fghij": {"x-2-target1"},
+	}
+	if got := hostsBySource(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("global endpoints by source = %v, want %v", got, want)
+	}
+
+	// Update of one source slice doesn't touch global copy of the other.
+	first.Endpoints = first.Endpoints[:1]
+	applyObject(t, w.clientset, first)
+	reconcile()
+	want["x-target1-abcde"] = []string{"x-0-target1"}
+	if got := hostsBySource(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("global endpoints by source after update = %v, want %v", got, want)
+	}
+
+	// Deleting one source slice only deletes its global copy.
+	deleteObject(t, w.clientset, second)
+	reconcile()
+	delete(want, "x-target1-fghij")
+	if got := hostsBySource(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("global endpoints by source after delete = %v, want %v", got, want)
+	}
+}
+
+func TestReconcileSourceSlicesOfDifferentNamespaces(t *testing.T) {
+	// Mirrored endpointslices of different namespaces, with the same name, both end up in the global namespace.
+	x := inNamespace(mirroredEndpointSlice("x", "target1", "x-0"), "a").(*discoveryv1.EndpointSlice)
+	y := inNamespace(mirroredEndpointSlice("y", "target1", "y-0", "y-1"), "b").(*discoveryv1.EndpointSlice)
+	x.Name, y.Name = "shared", "shared"
+	w := newTestWatcher(t, Options{AutoAggregate: true},
+		inNamespace(mirroredService("x", "target1", servicePort("http", 80, 8080)), "a"), x,
+		inNamespace(mirroredService("y", "target1", servicePort("http", 80, 8080)), "b"), y)
+	for i := 0; i < 2; i++ {
+		for _, name := range []string{"x-global", "y-global"} {
+			if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, name)); err != nil {
+				t.Fatalf("reconcileGlobalService(%v) error = %v", name, err)
+			}
+			syncCache(t, w)
+		}
+	}
+
+	for service, want := range map[string]int{"x-target1": 1, "y-target1": 2} {
+		slices := globalSlicesOf(t, w, service)
+		if len(slices) != 1 || len(slices[0].Endpoints) != want {
+			t.Errorf("global endpointslices of %v = %v, want one with %v endpoints", service, slices, want)
+		}
+	}
+}
+
+func applyObject(t *testing.T, client kubernetes.Interface, obj runtime.Object) {
+	t.Helper()
+	ctx := context.Background()
+	var err error
+	switch o := obj.(type) {
+	case *corev1.Service:
+		if _, err = client.CoreV1().Services(o.Namespace).Update(ctx, o, metav1.UpdateOptions{}); apiError.IsNotFound(err) {
+			_, err = client.CoreV1().Services(o.Namespace).Create(ctx, o, metav1.CreateOptions{})
+		}
+	case *discoveryv1.EndpointSlice:
+		if _, err = client.DiscoveryV1().EndpointSlices(o.Namespace).Update(ctx, o, metav1.UpdateOptions{}); apiError.IsNotFound(err) {
+			_, err = client.DiscoveryV1().EndpointSlices(o.Namespace).Create(ctx, o, metav1.CreateOptions{})
+		}
+	default:
+		t.Fatalf("unexpected object %T", obj)
+	}
+	if err != nil {
+		t.Fatalf("applying %T: %v", obj, err)
+	}
+}
+
+func deleteObject(t *testing.T, client kubernetes.Interface, obj runtime.Object) {
+	t.Helper()
+	ctx := context.Background()
+	var err error
+	switch o := obj.(type) {
+	case *corev1.Service:
+		err = client.CoreV1().Services(o.Namespace).Delete(ctx, o.Name, metav1.DeleteOptions{})
+	case *discoveryv1.EndpointSlice:
+		err = client.DiscoveryV1().EndpointSlices(o.Namespace).Delete(ctx, o.Name, metav1.DeleteOptions{})
+	default:
+		t.Fatalf("unexpected object %T", obj)
+	}
+	if err != nil {
+		t.Fatalf("deleting %T: %v", obj, err)
+	}
+}
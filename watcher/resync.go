@@ -0,0 +1,93 @@
+package watcher
+
+import (
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// resync goes through every global object we manage and compares it against mirrored services
+// and endpointslices in cache. Orphans, objects whose mirrored sources were deleted (possibly while we
+// were down), are reported and every global service is queued, so the workers clean up orphans and
+// fix anything which has drifted.
+func (w *Watcher) resync() {
+	w.log.Debugf("Starting resync of global objects")
+
+	// Index what is mirrored right now.
+	mirroredGlobalSvcs := make(map[string]bool)
+	mirroredTargetEps := make(map[string]bool)
+
+	svcs, err := w.svcLister.List(labels.Everything())
+	if err != nil {
+		w.log.Errorf("Resync failed, unable to list services from cache: %v", err)
+		return
+	}
+	for _, svc := range svcs {
+		if !w.Filter(svc.ObjectMeta) {
+			continue
+		}
+		for _, key := range w.globalServiceKeys(svc.Namespace, svc.Name, svc.GetLabels()[clusterNameLabel]) {
+			mirroredGlobalSvcs[key] = true
+		}
+	}
+
+	slices, err := w.epsLister.List(labels.Everything())
+	if err != nil {
+		w.log.Errorf("Resync failed, unable to list endpointslices from cache: %v", err)
+		return
+	}
+	for _, eps := range slices {
+		if !w.Filter(eps.ObjectMeta) {
+			continue
+		}
+		epsLabels := eps.GetLabels()
+		for _, key := range w.globalServiceKeys(eps.Namespace, epsLabels[serviceNameLabel], epsLabels[clusterNameLabel]) {
+			mirroredGlobalSvcs[key] = true
+			mirroredTargetEps[eps.Namespace+"/"+eps.Name] = true
+		}
+	}
+
+	globalSelector := labels.SelectorFromSet(labels.Set{globalMirrorLabel: "true"})
+	orphans := 0
+
+	globalSvcs, err := w.svcLister.List(globalSelector)
+	if err != nil {
+		w.log.Errorf("Resync failed, unable to list global services from cache: %v", err)
+		return
+	}
+	for _, svc := range globalSvcs {
+		if !w.isGlobalObject(svc.ObjectMeta) {
+			continue
+		}
+		key := globalKey(svc.Namespace, svc.Name)
+		if !mirroredGlobalSvcs[key] {
+			w.log.Infof("Found orphaned global Service Name=%v, no mirrored services or GlobalService left for it", key)
+			orphans++
+		}
+		w.queue.Add(key)
+	}
+
+	globalEps, err := w.epsLister.List(globalSelector)
+	if err != nil {
+		w.log.Errorf("Resync failed, unable to list global endpointslices from cache: %v", err)
+		return
+	}
+	for _, eps := range globalEps {
+		if !w.isGlobalObject(eps.ObjectMeta) {
+			continue
+		}
+		epsLabels := eps.GetLabels()
+		if !mirroredTargetEps[sourceOf(eps)] {
+			w.log.Infof("Found orphaned global EndpointSlice Name=%v/%v, mirrored EndpointSlice %v of %v is gone", eps.Namespace, eps.Name, sourceOf(eps), epsLabels[targetMirrorSvcNameLabel])
+			orphans++
+		}
+		if svcName, ok := epsLabels[serviceNameLabel]; ok {
+			w.queue.Add(globalKey(eps.Namespace, svcName))
+		}
+	}
+
+	// Also make sure global services which should exist, do exist.
+	for globalSvcName := range mirroredGlobalSvcs {
+		w.queue.Add(globalSvcName)
+	}
+
+	w.log.Infof("Resync done, found %v orphaned global objects", orphans)
+}
@@ -0,0 +1,93 @@
+package watcher
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	apiError "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestResyncOrphans(t *testing.T) {
+	tests := []struct {
+		name   string
+		dryRun bool
+	}{
+		{name: "deleted"},
+		{name: "dry-run", dryRun: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWatcher(t, Options{AutoAggregate: true},
+				mirroredService("x", "target1", servicePort("http", 80, 8080)), mirroredEndpointSlice("x", "target1", "x-0"))
+			key := globalKey(testGlobalNamespace, "x-global")
+			if err := w.reconcileGlobalService(key); err != nil {
+				t.Fatalf("reconcileGlobalService() error = %v", err)
+			}
+			if len(globalSlicesOf(t, w, "x-target1")) != 1 {
+				t.Fatalf("want a global endpointslice of x-target1")
+			}
+
+			// Mirrored service goes away while we aren't looking, leaving x-global and its endpointslice behind.
+			ctx := context.Background()
+			if err := w.clientset.CoreV1().Services(testNamespace).Delete(ctx, "x-target1", metav1.DeleteOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.clientset.DiscoveryV1().EndpointSlices(testNamespace).Delete(ctx, "x-target1-abcde", metav1.DeleteOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			syncCache(t, w)
+			w.dryRun = tt.dryRun
+			logs := logtest.NewLocal(w.log)
+
+			w.resync()
+			for _, want := range []string{
+				"Found orphaned global Service Name=" + key,
+				"Found orphaned global EndpointSlice Name=" + testGlobalNamespace + "/",
+				"Resync done, found 2 orphaned global objects",
+			} {
+				if !logged(logs, want) {
+					t.Errorf("resync() didn't log %q", want)
+				}
+			}
+			if w.queue.Len() != 1 {
+				t.Fatalf("queued %v global services, want x-global", w.queue.Len())
+			}
+			queued, _ := w.queue.Get()
+			w.queue.Done(queued)
+			if queued != key {
+				t.Fatalf("queued %v, want %v", queued, key)
+			}
+
+			if err := w.reconcileGlobalService(key); err != nil {
+				t.Fatalf("reconcileGlobalService() error = %v", err)
+			}
+			_, err := w.clientset.CoreV1().Services(testGlobalNamespace).Get(ctx, "x-global", metav1.GetOptions{})
+			slices := globalSlicesOf(t, w, "x-target1")
+			if tt.dryRun {
+				if err != nil || len(slices) != 1 {
+					t.Errorf("orphans deleted in dry-run, get x-global error = %v, %v global endpointslices", err, len(slices))
+				}
+				if !logged(logs, "[dry-run] Would delete global service: "+key) || !logged(logs, "[dry-run] Would delete global Endpointslice") {
+					t.Errorf("reconcileGlobalService() didn't log what it would delete")
+				}
+				return
+			}
+			if !apiError.IsNotFound(err) || len(slices) != 0 {
+				t.Errorf("orphans left, get x-global error = %v, %v global endpointslices", err, len(slices))
+			}
+		})
+	}
+}
+
+// logged reports if any log entry has the message.
+func logged(logs *logtest.Hook, message string) bool {
+	for _, entry := range logs.AllEntries() {
+		if strings.Contains(entry.Message, message) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,362 @@
+package watcher
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apiError "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/client-go/informers"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Mirrored service with this label set to false isn't aggregated. Linkerd copies labels of exported services
+// to their mirrors, so it can be set on the exported service.
+const globalOptOutLabel = "mirror.linkerd.io/global"
+
+// mirroredObjectSelector selects mirrored services and endpointslices on the apiserver, the way Filter does.
+// Endpointslices don't have labels of their services, so whether their service is selected is checked against
+// the cache.
+func mirroredObjectSelector() labels.Selector {
+	return labels.NewSelector().Add(
+		requirement(mirroredServiceLabel, selection.Exists),
+		requirement(headlessMirrorLabel, selection.DoesNotExist))
+}
+
+// mirroredServiceSelector selects mirrored services on the apiserver, leaving out the ones serviceSelector
+// doesn't match. Ones which opted out are still listed, so their endpointslices can be told apart from the ones
+// of services which aren't there yet.
+func mirroredServiceSelector(serviceSelector labels.Selector) labels.Selector {
+	selector := mirroredObjectSelector()
+	if serviceSelector != nil {
+		requirements, _ := serviceSelector.Requirements()
+		selector = selector.Add(requirements...)
+	}
+	return selector
+}
+
+// globalObjectSelector selects global objects we created.
+func globalObjectSelector() labels.Selector {
+	return labels.SelectorFromSet(labels.Set{globalMirrorLabel: "true"})
+}
+
+func requirement(key string, op selection.Operator, values ...string) labels.Requirement {
+	r, err := labels.NewRequirement(key, op, values)
+	if err != nil {
+		// Keys are our own constants.
+		panic(fmt.Sprintf("invalid label requirement on %v: %v", key, err))
+	}
+	return *r
+}
+
+// watchedNamespaces returns namespaces mirrored objects are watched in, one informer factory each.
+// All namespaces when none are given.
+func watchedNamespaces(namespaces []string) []string {
+	seen := make(map[string]bool)
+	watched := make([]string, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		if namespace == metav1.NamespaceAll {
+			return []string{metav1.NamespaceAll}
+		}
+		if !seen[namespace] {
+			seen[namespace] = true
+			watched = append(watched, namespace)
+		}
+	}
+	if len(watched) == 0 {
+		return []string{metav1.NamespaceAll}
+	}
+	sort.Strings(watched)
+	return watched
+}
+
+// withSelector makes informers list and watch only objects the selector matches.
+func withSelector(selector labels.Selector) informers.SharedInformerOption {
+	return informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+		opts.LabelSelector = selector.String()
+	})
+}
+
+// newMirroredFactory returns informer factory of mirrored objects in the namespace, all namespaces when empty.
+// Mirrored services are listed with serviceSelector, endpointslices with mirroredObjectSelector.
+func newMirroredFactory(client kubernetes.Interface, namespace string, serviceSelector labels.Selector) informers.SharedInformerFactory {
+	factory := informers.NewSharedInformerFactoryWithOptions(client, informerResync,
+		informers.WithNamespace(namespace), withSelector(mirroredObjectSelector()))
+	// First informer of the type wins, so Core().V1().Services() returns this one.
+	factory.InformerFor(&corev1.Service{}, func(client kubernetes.Interface, resync time.Duration) cache.SharedIndexInformer {
+		indexers := cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}
+		return coreinformers.NewFilteredServiceInformer(client, namespace, resync, indexers, func(opts *metav1.ListOptions) {
+			opts.LabelSelector = serviceSelector.String()
+		})
+	})
+	return factory
+}
+
+// Indexes of mirrored services and endpointslices, so reconciles look up the ones of a global service
+// instead of going through the whole cache.
+const (
+	// <global namespace>/<logical service>
+	logicalServiceIndex = "logicalService"
+	// <global namespace>/<name of the global service automatically aggregating the logical service>
+	autoGlobalNameIndex = "autoGlobalName"
+)
+
+// addMirroredIndexers adds the indexes to informers of mirrored objects, which has to happen before they start.
+func (w *Watcher) addMirroredIndexers() {
+	indexers := cache.Indexers{
+		logicalServiceIndex: w.logicalServiceIndexFunc,
+		autoGlobalNameIndex: w.autoGlobalNameIndexFunc,
+	}
+	for namespace, factory := range w.MirroredFactories {
+		if err := factory.Core().V1().Services().Informer().AddIndexers(indexers); err != nil {
+			w.log.Errorf("Unable to index mirrored services of namespace %q: %v", namespace, err)
+		}
+		if err := factory.Discovery().V1().EndpointSlices().Informer().AddIndexers(indexers); err != nil {
+			w.log.Errorf("Unable to index mirrored endpointslices of namespace %q: %v", namespace, err)
+		}
+	}
+}
+
+// logicalServiceOf returns global namespace and logical service of mirrored service or endpointslice.
+func (w *Watcher) logicalServiceOf(obj interface{}) (string, string, bool) {
+	switch o := obj.(type) {
+	case *corev1.Service:
+		if !isMirroredObject(o.ObjectMeta) {
+			return "", "", false
+		}
+		return w.globalNamespace(o.Namespace), w.serviceName(o.Name, o.GetLabels()[clusterNameLabel]), true
+	case *discoveryv1.EndpointSlice:
+		if !isMirroredObject(o.ObjectMeta) {
+			return "", "", false
+		}
+		epsLabels := o.GetLabels()
+		return w.globalNamespace(o.Namespace), w.serviceName(epsLabels[serviceNameLabel], epsLabels[clusterNameLabel]), true
+	}
+	return "", "", false
+}
+
+// Index funcs can't fail, client-go panics on errors.
+func (w *Watcher) logicalServiceIndexFunc(obj interface{}) ([]string, error) {
+	namespace, service, ok := w.logicalServiceOf(obj)
+	if !ok {
+		return nil, nil
+	}
+	return []string{globalKey(namespace, service)}, nil
+}
+
+func (w *Watcher) autoGlobalNameIndexFunc(obj interface{}) ([]string, error) {
+	namespace, service, ok := w.logicalServiceOf(obj)
+	if !ok {
+		return nil, nil
+	}
+	// Services which can't be named are reported when their global service is reconciled.
+	name, err := w.naming.GlobalName(service)
+	if err != nil {
+		return nil, nil
+	}
+	return []string{globalKey(namespace, name)}, nil
+}
+
+// indexedServices returns mirrored services with the key in the index, from every watched namespace.
+func (w *Watcher) indexedServices(index, key string) ([]*corev1.Service, error) {
+	svcs := make([]*corev1.Service, 0)
+	for _, factory := range w.MirroredFactories {
+		objs, err := factory.Core().V1().Services().Informer().GetIndexer().ByIndex(index, key)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objs {
+			if svc, ok := obj.(*corev1.Service); ok {
+				svcs = append(svcs, svc)
+			}
+		}
+	}
+	return svcs, nil
+}
+
+// indexedEndpointSlices returns mirrored endpointslices with the key in the index, like indexedServices.
+func (w *Watcher) indexedEndpointSlices(index, key string) ([]*discoveryv1.EndpointSlice, error) {
+	slices := make([]*discoveryv1.EndpointSlice, 0)
+	for _, factory := range w.MirroredFactories {
+		objs, err := factory.Discovery().V1().EndpointSlices().Informer().GetIndexer().ByIndex(index, key)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objs {
+			if eps, ok := obj.(*discoveryv1.EndpointSlice); ok {
+				slices = append(slices, eps)
+			}
+		}
+	}
+	return slices, nil
+}
+
+// inScope reports if mirrored object is within what we aggregate: its namespace has labels NamespaceSelector
+// asks for, and its mirrored service didn't opt out. Endpointslices don't have labels of their services, so it goes
+// by the service in cache. Without it, which is the case until the service shows up, endpointslice is only
+// in scope when services aren't selected by labels.
+func (w *Watcher) inScope(obj metav1.ObjectMeta) bool {
+	if w.nsLister != nil {
+		if _, err := w.nsLister.Get(obj.Namespace); err != nil {
+			return false
+		}
+	}
+	objLabels := obj.GetLabels()
+	svcName, ok := objLabels[serviceNameLabel]
+	if !ok {
+		return objLabels[globalOptOutLabel] != "false"
+	}
+	svc, err := w.svcLister.Services(obj.Namespace).Get(svcName)
+	if err != nil {
+		return w.serviceSelector == nil || w.serviceSelector.Empty()
+	}
+	return svc.GetLabels()[globalOptOutLabel] != "false"
+}
+
+// enqueueNamespace queues global services of mirrored services in the namespace, when it comes into or goes out
+// of NamespaceSelector.
+func (w *Watcher) enqueueNamespace(namespace *corev1.Namespace) {
+	svcs, err := w.svcLister.Services(namespace.Name).List(mirroredObjectSelector())
+	if err != nil {
+		w.log.Errorf("Unable to list services of namespace %v from cache: %v", namespace.Name, err)
+		return
+	}
+	for _, svc := range svcs {
+		for _, key := range w.globalServiceKeys(svc.Namespace, svc.Name, svc.GetLabels()[clusterNameLabel]) {
+			w.queue.Add(key)
+		}
+	}
+}
+
+func (w *Watcher) registerNamespaceHandlers() {
+	if w.namespaceFactory == nil {
+		return
+	}
+	nsInformer := w.namespaceFactory.Core().V1().Namespaces().Informer()
+	// Namespace which stops matching the selector is deleted from the cache, updates don't change anything.
+	nsInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			namespace, ok := obj.(*corev1.Namespace)
+			if !ok {
+				w.log.Errorf("Failed to cast Namespace in Add")
+				return
+			}
+			w.enqueueNamespace(namespace)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			namespace, ok := obj.(*corev1.Namespace)
+			if !ok {
+				w.log.Errorf("Failed to cast Namespace in Delete")
+				return
+			}
+			w.enqueueNamespace(namespace)
+		},
+	})
+}
+
+// serviceListers reads services from caches of several informers, as global services and mirrored services
+// of every watched namespace are watched by informers of their own.
+type serviceListers []corelisters.ServiceLister
+
+func (l serviceListers) List(selector labels.Selector) ([]*corev1.Service, error) {
+	svcs := make([]*corev1.Service, 0)
+	for _, lister := range l {
+		found, err := lister.List(selector)
+		if err != nil {
+			return nil, err
+		}
+		svcs = append(svcs, found...)
+	}
+	return svcs, nil
+}
+
+func (l serviceListers) Services(namespace string) corelisters.ServiceNamespaceLister {
+	listers := make(serviceNamespaceListers, 0, len(l))
+	for _, lister := range l {
+		listers = append(listers, lister.Services(namespace))
+	}
+	return listers
+}
+
+type serviceNamespaceListers []corelisters.ServiceNamespaceLister
+
+func (l serviceNamespaceListers) List(selector labels.Selector) ([]*corev1.Service, error) {
+	svcs := make([]*corev1.Service, 0)
+	for _, lister := range l {
+		found, err := lister.List(selector)
+		if err != nil {
+			return nil, err
+		}
+		svcs = append(svcs, found...)
+	}
+	return svcs, nil
+}
+
+func (l serviceNamespaceListers) Get(name string) (*corev1.Service, error) {
+	for _, lister := range l {
+		svc, err := lister.Get(name)
+		if !apiError.IsNotFound(err) {
+			return svc, err
+		}
+	}
+	return nil, apiError.NewNotFound(corev1.Resource("services"), name)
+}
+
+// endpointSliceListers reads endpointslices from caches of several informers, like serviceListers.
+type endpointSliceListers []discoverylisters.EndpointSliceLister
+
+func (l endpointSliceListers) List(selector labels.Selector) ([]*discoveryv1.EndpointSlice, error) {
+	slices := make([]*discoveryv1.EndpointSlice, 0)
+	for _, lister := range l {
+		found, err := lister.List(selector)
+		if err != nil {
+			return nil, err
+		}
+		slices = append(slices, found...)
+	}
+	return slices, nil
+}
+
+func (l endpointSliceListers) EndpointSlices(namespace string) discoverylisters.EndpointSliceNamespaceLister {
+	listers := make(endpointSliceNamespaceListers, 0, len(l))
+	for _, lister := range l {
+		listers = append(listers, lister.EndpointSlices(namespace))
+	}
+	return listers
+}
+
+type endpointSliceNamespaceListers []discoverylisters.EndpointSliceNamespaceLister
+
+func (l endpointSliceNamespaceListers) List(selector labels.Selector) ([]*discoveryv1.EndpointSlice, error) {
+	slices := make([]*discoveryv1.EndpointSlice, 0)
+	for _, lister := range l {
+		found, err := lister.List(selector)
+		if err != nil {
+			return nil, err
+		}
+		slices = append(slices, found...)
+	}
+	return slices, nil
+}
+
+func (l endpointSliceNamespaceListers) Get(name string) (*discoveryv1.EndpointSlice, error) {
+	for _, lister := range l {
+		eps, err := lister.Get(name)
+		if !apiError.IsNotFound(err) {
+			return eps, err
+		}
+	}
+	return nil, apiError.NewNotFound(discoveryv1.Resource("endpointslices"), name)
+}
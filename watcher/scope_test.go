@@ -0,0 +1,175 @@
+package watcher
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestWatchedNamespaces(t *testing.T) {
+	tests := []struct {
+		name       string
+		namespaces []string
+		want       []string
+	}{
+		{name: "all", want: []string{metav1.NamespaceAll}},
+		{name: "some", namespaces: []string{"b", "a", "b"}, want: []string{"a", "b"}},
+		{name: "all among some", namespaces: []string{"a", metav1.NamespaceAll}, want: []string{metav1.NamespaceAll}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := watchedNamespaces(tt.namespaces); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("watchedNamespaces() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileScope(t *testing.T) {
+	namespace := func(nsLabels map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: testNamespace, Labels: nsLabels}}
+	}
+	svc := func(extra map[string]string) *corev1.Service {
+		svc := mirroredService("x", "target1", servicePort("http", 80, 8080))
+		for k, v := range extra {
+			svc.Labels[k] = v
+		}
+		return svc
+	}
+
+	tests := []struct {
+		name    string
+		opts    Options
+		objects []runtime.Object
+		want    bool
+	}{
+		{name: "everything", objects: []runtime.Object{svc(nil)}, want: true},
+		{name: "opted out", objects: []runtime.Object{svc(map[string]string{globalOptOutLabel: "false"})}},
+		{
+			name:    "watched namespace",
+			opts:    Options{WatchNamespaces: []string{testNamespace}},
+			objects: []runtime.Object{svc(nil)},
+			want:    true,
+		},
+		{name: "namespace not watched", opts: Options{WatchNamespaces: []string{"other"}}, objects: []runtime.Object{svc(nil)}},
+		{
+			name:    "namespace selected",
+			opts:    Options{NamespaceSelector: labels.SelectorFromSet(labels.Set{"team": "a"})},
+			objects: []runtime.Object{namespace(map[string]string{"team": "a"}), svc(nil)},
+			want:    true,
+		},
+		{
+			name:    "namespace not selected",
+			opts:    Options{NamespaceSelector: labels.SelectorFromSet(labels.Set{"team": "a"})},
+			objects: []runtime.Object{namespace(map[string]string{"team": "b"}), svc(nil)},
+		},
+		{
+			name:    "service selected",
+			opts:    Options{ServiceSelector: labels.SelectorFromSet(labels.Set{globalOptOutLabel: "true"})},
+			objects: []runtime.Object{svc(map[string]string{globalOptOutLabel: "true"})},
+			want:    true,
+		},
+		{
+			name:    "service not selected",
+			opts:    Options{ServiceSelector: labels.SelectorFromSet(labels.Set{globalOptOutLabel: "true"})},
+			objects: []runtime.Object{svc(nil)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.AutoAggregate = true
+			// Endpointslices don't have labels of their service, it's up to the service whether they are aggregated.
+			objects := append(tt.objects, mirroredEndpointSlice("x", "target1", "x-0"))
+			w := newTestWatcher(t, tt.opts, objects...)
+			if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+				t.Fatalf("reconcileGlobalService() error = %v", err)
+			}
+			syncCache(t, w)
+
+			_, err := w.clientset.CoreV1().Services(testGlobalNamespace).Get(context.Background(), "x-global", metav1.GetOptions{})
+			if got := err == nil; got != tt.want {
+				t.Errorf("global service created = %v, want %v", got, tt.want)
+			}
+			if got := len(globalSlicesOf(t, w, "x-target1")) > 0; got != tt.want {
+				t.Errorf("global endpointslices created = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMirroredIndexes(t *testing.T) {
+	naming, err := NewTemplateNaming("all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newTestWatcher(t, Options{
+		NamespaceMode:    NamespacePreserve,
+		NamespaceMapping: map[string]string{"b": "shared", "c": "shared"},
+		WatchNamespaces:  []string{"a", "b", "c"},
+		Naming:           naming,
+	},
+		inNamespace(mirroredService("x", "target1"), "a"),
+		inNamespace(mirroredService("x", "target2"), "a"),
+		inNamespace(mirroredService("y", "target1"), "a"),
+		inNamespace(mirroredEndpointSlice("z", "target1", ""), "a"),
+		inNamespace(mirroredService("x", "target1"), "b"),
+		inNamespace(mirroredEndpointSlice("x", "target2", ""), "c"),
+	)
+	syncCache(t, w)
+
+	tests := []struct {
+		namespace string
+		service   string
+		wantSvcs  []string
+		wantEps   []string
+	}{
+		{namespace: "a", service: "x", wantSvcs: []string{"a/x-target1", "a/x-target2"}, wantEps: []string{}},
+		{namespace: "a", service: "z", wantSvcs: []string{}, wantEps: []string{"a/z-target1-abcde"}},
+		{namespace: "shared", service: "x", wantSvcs: []string{"b/x-target1"}, wantEps: []string{"c/x-target2-abcde"}},
+		{namespace: "b", service: "x", wantSvcs: []string{}, wantEps: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(globalKey(tt.namespace, tt.service), func(t *testing.T) {
+			agg := &aggregation{namespace: tt.namespace, service: tt.service}
+			svcs, err := w.mirroredServices(agg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotSvcs := make([]string, 0, len(svcs))
+			for _, svc := range svcs {
+				gotSvcs = append(gotSvcs, globalKey(svc.Namespace, svc.Name))
+			}
+			sort.Strings(gotSvcs)
+			if !reflect.DeepEqual(gotSvcs, tt.wantSvcs) {
+				t.Errorf("mirroredServices() = %v, want %v", gotSvcs, tt.wantSvcs)
+			}
+
+			slices, err := w.mirroredEndpointSlices(agg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotEps := make([]string, 0, len(slices))
+			for _, eps := range slices {
+				gotEps = append(gotEps, globalKey(eps.Namespace, eps.Name))
+			}
+			sort.Strings(gotEps)
+			if !reflect.DeepEqual(gotEps, tt.wantEps) {
+				t.Errorf("mirroredEndpointSlices() = %v, want %v", gotEps, tt.wantEps)
+			}
+		})
+	}
+
+	// Every service is named "all", so they collide within the global namespace.
+	if got, want := w.autoAggregatedServices("a", "all", nil), []string{"x", "y", "z"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("autoAggregatedServices() = %v, want %v", got, want)
+	}
+	if got, want := w.autoAggregatedServices("shared", "all", nil), []string{"x"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("autoAggregatedServices() = %v, want %v", got, want)
+	}
+}
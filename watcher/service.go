@@ -4,220 +4,210 @@ package watcher
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
 
 	corev1 "k8s.io/api/core/v1"
 	apiError "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
 )
 
-func (svcW *Watcher) checkNameSpaceExists() {
+func (svcW *Watcher) checkNameSpaceExists(namespace string) error {
 
 	// Check if the namespace already exists
-	namespace := svcW.namespace
-	_, err := svcW.clientset.CoreV1().Namespaces().Get(svcW.Context, namespace, metav1.GetOptions{})
-	if err != nil {
-		if apiError.IsAlreadyExists(err) {
-			svcW.log.Debugf("Skipped creating namespace '%v'; already exists", namespace)
-			return
-		}
-		svcW.log.Errorf("Failed to get namespace '%s': %v", err)
-		return
+	_, err := svcW.clientset.CoreV1().Namespaces().Get(svcW.workCtx, namespace, metav1.GetOptions{})
+	if err == nil {
+		svcW.log.Debugf("Skipped creating namespace '%v'; already exists", namespace)
+		return nil
 	}
-	// Create the namespace
-	newNamespace := &corev1.Namespace{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: namespace,
-		},
+	if !apiError.IsNotFound(err) {
+		countAPIError("get", "namespaces")
+		return fmt.Errorf("failed to get namespace '%s': %w", namespace, err)
 	}
-	_, err = svcW.clientset.CoreV1().Namespaces().Create(svcW.Context, newNamespace, metav1.CreateOptions{})
+	// Create the namespace
+	newNamespace := corev1ac.Namespace(namespace)
+	_, err = svcW.clientset.CoreV1().Namespaces().Apply(svcW.workCtx, newNamespace, svcW.applyOptions())
 	if err != nil {
-		svcW.log.Errorf("Issue creating namespace: %v", err)
-		return
+		countAPIError("apply", "namespaces")
+		return fmt.Errorf("issue creating namespace '%s': %w", namespace, svcW.explainConflict(err))
 	}
 
 	svcW.log.Infof("Namespace '%s' created", namespace)
+	return nil
 }
 
-// Check if the existing service is in sync [JUST PORTS FOR NOW, IF CHANGE RETURN UPDATED SLICE]
-func (svcW *Watcher) checkParityofService(targetSvc *corev1.Service, globalSvc *corev1.Service) ([]corev1.ServicePort, bool) {
-
-	targetSvcPort := targetSvc.Spec.DeepCopy().Ports
-	globalSvcPort := globalSvc.Spec.DeepCopy().Ports
-	if !reflect.DeepEqual(targetSvcPort, globalSvcPort) {
-		//Check port by port, to make sure there is no change.
-		//Create map to do efficient checking
-		/*
-						GlobalService              MirrorService1               mirrorservice2
-			            - port: 80                 - port: 80                    - port: 90
-						  targetPort: 7000           targetPort: 6000              targetport: 8000
-		*/
-		mapPort := make(map[corev1.ServicePort]corev1.ServicePort)
-		for _, port := range globalSvcPort {
-			port.Name = ""
-			mapPort[port] = corev1.ServicePort{}
-			mapPort[port] = port
-		}
-		// log.Debugf("Value of global svc ports after removing name: %v", mapPort)
-		for _, port := range targetSvcPort {
-			if _, ok := mapPort[port]; !ok {
-				svcW.log.Infof("Port= %v doesn't exist in global. Adding.", port)
-				globalSvcPort = append(globalSvcPort, *port.DeepCopy())
-			}
-		}
-
-		//Again make sure there is change in ports if there is change, update the ports.
-		//Checking now it should return true.
-		if !reflect.DeepEqual(globalSvcPort, globalSvc.Spec.Ports) {
-			//Only update ports.
-			svcW.log.Debugf("Looks like there is difference in Ports NewPorts=%v, ExistingPorts=%v  syncing config", globalSvcPort, globalSvc.Spec.Ports)
+/* -------------------- EVENT HANDLERS FOR SERVICE ---------------------- */
 
-			for i, p := range globalSvcPort {
-				//Create port name with index as API doenst allow to update port when there are multiple ones.
-				p.Name = "port-" + fmt.Sprint(i)
-				globalSvcPort[i] = p
-			}
+// handleServiceAdd creates the global service, returns nil service when it turns out to exist already.
+func (svcW *Watcher) handleServiceAdd(agg *aggregation, targetSvcs []*corev1.Service) (*corev1.Service, error) {
+	/*
+		- Spin up the new global service with cardinal index as x-global,
+		Which will be aggregator for mirrored services from targetSvc. cluster x-targetSvc.0, x-targetSvc.1
+		Global service is created in the namespace of aggregation, see NamespaceMode.
+	*/
+	globalSvcName := agg.name
+	if err := svcW.checkNameSpaceExists(agg.namespace); err != nil {
+		return nil, err
+	}
+	// Services of users aren't in cache, apply would take theirs over.
+	existing, err := svcW.clientset.CoreV1().Services(agg.namespace).Get(svcW.workCtx, globalSvcName, metav1.GetOptions{})
+	switch {
+	case err == nil && !svcW.isOwnGlobalService(existing):
+		namingErrorsTotal.WithLabelValues(agg.namespace, globalSvcName).Inc()
+		return nil, nameTakenError(existing)
+	case err != nil && !apiError.IsNotFound(err):
+		countAPIError("get", "services")
+		return nil, fmt.Errorf("unable to check if global service Name=%v exists: %w", globalSvcName, err)
+	}
+	svcW.log.Infof("New Global Service will be created Name=%v in Namespace=%v", globalSvcName, agg.namespace)
 
-			return globalSvcPort, true
-		}
-		return make([]corev1.ServicePort, 0), false
+	globalService := svcW.globalServiceApply(agg.namespace, globalSvcName, svcW.globalServicePorts(agg, &corev1.Service{}, targetSvcs), agg.headless, agg.ipFamilies, true)
+	created, err := svcW.clientset.CoreV1().Services(agg.namespace).Apply(svcW.workCtx, globalService, svcW.applyOptions())
+	if err != nil {
+		countAPIError("apply", "services")
+		return nil, fmt.Errorf("issue with service creation, Name=%v: %w", globalSvcName, svcW.explainConflict(err))
 	}
-	return make([]corev1.ServicePort, 0), false
+	return created, nil
 }
 
-/* -------------------- EVENT HANDLERS FOR SERVICE ---------------------- */
-
-func (svcW *Watcher) handleServiceAdd(service corev1.Service) {
-	/*
-		- Check if Global Service is added wrt current service.
-		- Global service name of svc: x-target will be x-global.
-
-	*/
+// globalServicePorts returns ports global service should have. Either the ones set on GlobalService
+// or the union of ports of all target services. Without any target service, ports are left as they are.
+func (svcW *Watcher) globalServicePorts(agg *aggregation, globalSvc *corev1.Service, targetSvcs []*corev1.Service) []corev1.ServicePort {
+	if len(agg.ports) > 0 {
+		return agg.ports
+	}
+	if len(targetSvcs) == 0 {
+		return globalSvc.Spec.Ports
+	}
+	return svcW.servicePortsUnion(agg, targetSvcs)
+}
 
-	svcW.log.Debugf("New Target Service Added, svcName= %v", service.Name)
-
-	targetClusterame := service.GetLabels()["mirror.linkerd.io/cluster-name"]
-
-	targetSvc := service.DeepCopy()
-
-	// Check if global/aggregator service exists.
-	// We dont have this object in Local Cache so check directly.
-	// TO DO: Add global object in local cache.
-	globalSvcName := strings.Split(targetSvc.Name, fmt.Sprintf("-%s", targetClusterame))[0]
-	globalSvcName = globalSvcName + "-global"
-	svcW.log.Debugf("Checking global Svc Named= %v  if exists", globalSvcName)
-	globalSvc, err := svcW.clientset.CoreV1().Services(svcW.namespace).Get(svcW.Context, globalSvcName, metav1.GetOptions{})
-
-	//If global service doesnt exist cerate it
-	if err != nil && !apiError.IsAlreadyExists(err) {
-
-		/*
-			- Spin up the new global service with cardinal index as x-global,
-			Which will be aggregator for mirrored services from targetSvc. cluster x-targetSvc.0, x-targetSvc.1
-			Global service will always be created in Default.
-		*/
-		svcW.checkNameSpaceExists()
-		svcW.log.Infof("New Global Service will be created Name=%v in Namespace=%v", globalSvcName, svcW.namespace)
-
-		svcMeta := &metav1.ObjectMeta{
-			Name:      globalSvcName,
-			Namespace: svcW.namespace,
-			Labels: map[string]string{
-				"mirror.linkerd.io/global-mirror": "true",
-			},
-		}
+// servicePortKey identifies a port of the global service, there can't be two ports with same port & protocol.
+type servicePortKey struct {
+	port     int32
+	protocol corev1.Protocol
+}
 
-		svcSpec := &corev1.ServiceSpec{
-			ClusterIP: "None",
-			Ports:     targetSvc.Spec.Ports,
-		}
-		globalService := &corev1.Service{
-			ObjectMeta: *svcMeta,
-			Spec:       *svcSpec,
+// servicePortsUnion returns union of ports of target services, sorted by port and protocol so it doesn't depend on
+// order of target services. When target services disagree on targetPort or appProtocol of the same port & protocol,
+// target service of the cluster which sorts first wins and conflict is reported.
+func (svcW *Watcher) servicePortsUnion(agg *aggregation, targetSvcs []*corev1.Service) []corev1.ServicePort {
+	sorted := append([]*corev1.Service(nil), targetSvcs...)
+	sort.Slice(sorted, func(i, j int) bool {
+		ci, cj := sorted[i].GetLabels()[clusterNameLabel], sorted[j].GetLabels()[clusterNameLabel]
+		if ci != cj {
+			return ci < cj
 		}
-		//Create clientSet to create Service,
-		defaultCreateOptions := metav1.CreateOptions{}
-		_, err := svcW.clientset.CoreV1().Services(svcW.namespace).Create(svcW.Context, globalService, defaultCreateOptions)
-		if !apiError.IsAlreadyExists(err) && err != nil {
-			svcW.log.Errorf("Issue with service creation, Name=%v", globalSvcName)
-			svcW.log.Error(err)
-			return
-		}
-
-	} else {
-		// If service already exists, check if the port from the target service are inside global svcW.
-		svcW.log.Debugf("Skipping Creation of New Global Service, Named=%v already exists", globalSvcName)
-		svcW.log.Debugf("Checking if the Spec is synced. [Currently only checks for ports.]")
-		globalSvcPort, diff := svcW.checkParityofService(targetSvc, globalSvc)
-		if diff {
-			// Update all the ports, cause its addition.
-			globalSvc.Spec.Ports = globalSvcPort
-			defaultCreateOptions := metav1.CreateOptions{}
-			_, err := svcW.clientset.CoreV1().Services(svcW.namespace).Update(svcW.Context, globalSvc, metav1.UpdateOptions(defaultCreateOptions))
-			if err != nil {
-				svcW.log.Errorf("Unable to update ports, for global service Name=%v", globalSvcName)
-				svcW.log.Error(err)
-				return
+		return sorted[i].Namespace+"/"+sorted[i].Name < sorted[j].Namespace+"/"+sorted[j].Name
+	})
+
+	union := make(map[servicePortKey]corev1.ServicePort)
+	for _, targetSvc := range sorted {
+		for _, port := range targetSvc.Spec.Ports {
+			key := servicePortKey{port.Port, port.Protocol}
+			existing, ok := union[key]
+			if !ok {
+				union[key] = port
+				continue
+			}
+			if existing.TargetPort != port.TargetPort || !reflect.DeepEqual(existing.AppProtocol, port.AppProtocol) {
+				portConflictsTotal.WithLabelValues(agg.namespace, agg.name, targetSvc.GetLabels()[clusterNameLabel]).Inc()
+				svcW.warnf(agg, reasonPortConflict, "Port %v/%v of %v/%v conflicts with the same port of other mirrored services, skipping it",
+					port.Port, port.Protocol, targetSvc.Namespace, targetSvc.Name)
 			}
-			svcW.log.Infof("Updated global service port: %v", globalSvc.Name)
 		}
-
 	}
 
+	ports := make([]corev1.ServicePort, 0, len(union))
+	for _, port := range union {
+		ports = append(ports, *port.DeepCopy())
+	}
+	sortServicePorts(ports)
+	nameServicePorts(ports)
+	return ports
 }
 
-// Function to handle service updates
-func (svcW *Watcher) handleServiceUpdate(oldService corev1.Service, newService corev1.Service) {
-
-	svcW.log.Infof("Handling update for Service: %v ", oldService.Name)
+// sortServicePorts sorts ports by port and protocol.
+func sortServicePorts(ports []corev1.ServicePort) {
+	sort.Slice(ports, func(i, j int) bool {
+		if ports[i].Port != ports[j].Port {
+			return ports[i].Port < ports[j].Port
+		}
+		return ports[i].Protocol < ports[j].Protocol
+	})
+}
 
-	//Get target clusters.
-	targetClusterame := newService.GetLabels()["mirror.linkerd.io/cluster-name"]
+// nameServicePorts makes sure every port has a unique name, as API requires when service has multiple ports.
+// Original names are kept, only unnamed ports and ones whose name is already taken get <protocol>-<port>.
+func nameServicePorts(ports []corev1.ServicePort) {
+	if len(ports) == 1 {
+		return
+	}
 
-	if !reflect.DeepEqual(oldService.Spec.Ports, newService.Spec.Ports) {
+	used := make(map[string]bool, len(ports))
+	rename := make([]int, 0)
+	for i, port := range ports {
+		if port.Name == "" || used[port.Name] {
+			rename = append(rename, i)
+			continue
+		}
+		used[port.Name] = true
+	}
 
-		// Assuming global/aggregator service exists.
-		// TO DO: Also add logic to handle
-		globalSvcName := strings.Split(newService.Name, fmt.Sprintf("-%s", targetClusterame))[0]
-		globalSvcName = globalSvcName + "-global"
+	for _, i := range rename {
+		name := fmt.Sprintf("%v-%v", strings.ToLower(string(ports[i].Protocol)), ports[i].Port)
+		for n := 1; used[name]; n++ {
+			name = fmt.Sprintf("%v-%v-%v", strings.ToLower(string(ports[i].Protocol)), ports[i].Port, n)
+		}
+		used[name] = true
+		ports[i].Name = name
+	}
+}
 
-		svcW.log.Debugf("Checking global Svc Named=%v if exists", globalSvcName)
+// Function to handle service updates, makes sure global svc has the union of ports of all target services.
+func (svcW *Watcher) handleServiceUpdate(agg *aggregation, globalSvc *corev1.Service, targetSvcs []*corev1.Service) error {
 
-		globalSvc, err := svcW.clientset.CoreV1().Services("default").Get(svcW.Context, globalSvcName, metav1.GetOptions{})
+	svcW.log.Debugf("Checking if the Spec is synced for global service Name=%v. [Currently only checks for ports.]", globalSvc.Name)
 
-		if !apiError.IsAlreadyExists(err) && err != nil {
-			svcW.log.Errorf("Issue in retrieving global svc Name=%v", globalSvcName)
-			svcW.log.Error(err)
-			svcW.log.Infof("Skipping update for now.")
-			return
-		}
+	headless := globalSvc.Spec.ClusterIP == corev1.ClusterIPNone
 
-		globalSvcPort, diff := svcW.checkParityofService(&newService, globalSvc)
-		if diff {
-			//Update all the ports, cause its addition.
-			globalSvc.Spec.Ports = globalSvcPort
-			defaultCreateOptions := metav1.CreateOptions{}
-			//Again make sure there is change in ports and its different from new service.
-			svcW.log.Debugf("Updating Global service, Ports to update=%v, existing ports=%v", globalSvcPort, globalSvc.Spec.Ports)
-			_, err := svcW.clientset.CoreV1().Services(svcW.namespace).Update(svcW.Context, globalSvc, metav1.UpdateOptions(defaultCreateOptions))
-			if err != nil {
-				svcW.log.Errorf("Unable to update ports, for global service, Name=%v", globalSvcName)
-				svcW.log.Error(err)
-			}
-		}
+	globalSvcPort := svcW.globalServicePorts(agg, globalSvc, targetSvcs)
+	if reflect.DeepEqual(globalSvcPort, globalSvc.Spec.Ports) && ipFamiliesSynced(globalSvc, agg.ipFamilies) &&
+		hasFinalizer(globalSvc.ObjectMeta) && globalSvc.Labels[globalMirrorLabel] == "true" {
+		return nil
+	}
 
+	svcW.log.Debugf("Updating Global service, Ports to update=%v, existing ports=%v", globalSvcPort, globalSvc.Spec.Ports)
+	// Finalizer is applied as well, in case global service was created before finalizers were added.
+	apply := svcW.globalServiceApply(globalSvc.Namespace, globalSvc.Name, globalSvcPort, headless, agg.ipFamilies, true)
+	_, err := svcW.clientset.CoreV1().Services(globalSvc.Namespace).Apply(svcW.workCtx, apply, svcW.applyOptions())
+	if err != nil {
+		countAPIError("apply", "services")
+		return fmt.Errorf("unable to update ports, for global service Name=%v: %w", globalSvc.Name, svcW.explainConflict(err))
 	}
-	svcW.log.Infof("Handled update for service: %v", newService.Name)
+	svcW.log.Infof("Updated global service port: %v", globalSvc.Name)
+	return nil
 }
 
-func (svcW *Watcher) handleServiceDelete(service corev1.Service) {
-	// Remove respective global service if there are not endpointslices attached to it
+// Remove global service, called once there are no more mirrored services or endpointslices attached to it.
+// Service only goes away once finalizeGlobalService removed our finalizer.
+func (svcW *Watcher) handleServiceDelete(namespace, globalSvcName string) error {
 
-	targetClusterame := service.GetLabels()["mirror.linkerd.io/cluster-name"]
-	globalSvcName := strings.Split(service.Name, fmt.Sprintf("-%s", targetClusterame))[0]
-	globalSvcName = globalSvcName + "-global"
+	if svcW.dryRun {
+		svcW.log.Infof("[dry-run] Would delete global service: %v/%v, no more mirrored services or endpointslices attached to it.", namespace, globalSvcName)
+		return nil
+	}
 
-	svcW.log.Infof("Service being deleted: %v", service.Name)
+	err := svcW.clientset.CoreV1().Services(namespace).Delete(svcW.workCtx, globalSvcName, metav1.DeleteOptions{})
+	if apiError.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		countAPIError("delete", "services")
+		return fmt.Errorf("issue deleting global service Name=%v: %w", globalSvcName, err)
+	}
 
-	svcW.log.Infof("Global service: %v will also be deleted. If there are no more endpointslices attached to global service", globalSvcName)
+	svcW.log.Infof("Global service: %v is deleted as there are no more mirrored services or endpointslices attached to it.", globalSvcName)
+	return nil
 }
@@ -0,0 +1,279 @@
+package watcher
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	mirrorv1alpha1 "github.com/rushi47/service-mirror-prototype/apis/mirror/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apiError "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNameServicePorts(t *testing.T) {
+	udp := func(name string, port int32) corev1.ServicePort {
+		p := servicePort(name, port, port)
+		p.Protocol = corev1.ProtocolUDP
+		return p
+	}
+	tests := []struct {
+		name  string
+		ports []corev1.ServicePort
+		want  []string
+	}{
+		{name: "single unnamed port stays unnamed", ports: []corev1.ServicePort{servicePort("", 80, 8080)}, want: []string{""}},
+		{name: "unique names are kept", ports: []corev1.ServicePort{servicePort("http", 80, 8080), servicePort("metrics", 9090, 9090)}, want: []string{"http", "metrics"}},
+		{name: "unnamed ports get protocol and port", ports: []corev1.ServicePort{servicePort("", 80, 8080), udp("", 53)}, want: []string{"tcp-80", "udp-53"}},
+		{name: "colliding name is renamed", ports: []corev1.ServicePort{servicePort("http", 80, 8080), servicePort("http", 8080, 8080)}, want: []string{"http", "tcp-8080"}},
+		{name: "generated name doesn't take original one", ports: []corev1.ServicePort{servicePort("", 80, 80), servicePort("tcp-80", 81, 81)}, want: []string{"tcp-80-1", "tcp-80"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nameServicePorts(tt.ports)
+			got := make([]string, 0, len(tt.ports))
+			for _, port := range tt.ports {
+				got = append(got, port.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("names = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGlobalServicePorts(t *testing.T) {
+	grpc := servicePort("grpc", 50051, 50051)
+	appProtocol := "kubernetes.io/h2c"
+	grpc.AppProtocol = &appProtocol
+
+	tests := []struct {
+		name         string
+		aggPorts     []corev1.ServicePort
+		globalPorts  []corev1.ServicePort
+		targets      [][]corev1.ServicePort
+		wantPorts    []corev1.ServicePort
+		wantProblems int
+	}{
+		{
+			name:      "single cluster keeps its port names",
+			targets:   [][]corev1.ServicePort{{servicePort("http", 80, 8080)}},
+			wantPorts: []corev1.ServicePort{servicePort("http", 80, 8080)},
+		},
+		{
+			name: "clusters with same ports",
+			targets: [][]corev1.ServicePort{
+				{servicePort("http", 80, 8080)},
+				{servicePort("http", 80, 8080)},
+			},
+			wantPorts: []corev1.ServicePort{servicePort("http", 80, 8080)},
+		},
+		{
+			name: "union of ports across clusters, sorted by port",
+			targets: [][]corev1.ServicePort{
+				{servicePort("metrics", 9090, 9090), servicePort("http", 80, 8080)},
+				{servicePort("http", 80, 8080), grpc},
+			},
+			wantPorts: []corev1.ServicePort{servicePort("http", 80, 8080), servicePort("metrics", 9090, 9090), grpc},
+		},
+		{
+			name: "same port with different name in other cluster",
+			targets: [][]corev1.ServicePort{
+				{servicePort("http", 80, 8080)},
+				{servicePort("web", 80, 8080)},
+			},
+			wantPorts: []corev1.ServicePort{servicePort("http", 80, 8080)},
+		},
+		{
+			name: "conflicting targetPort is left out",
+			targets: [][]corev1.ServicePort{
+				{servicePort("http", 80, 8080)},
+				{servicePort("http", 80, 9000), servicePort("metrics", 9090, 9090)},
+			},
+			wantPorts:    []corev1.ServicePort{servicePort("http", 80, 8080), servicePort("metrics", 9090, 9090)},
+			wantProblems: 1,
+		},
+		{
+			name: "conflicting appProtocol is left out",
+			targets: [][]corev1.ServicePort{
+				{servicePort("grpc", 50051, 50051)},
+				{grpc},
+			},
+			wantPorts:    []corev1.ServicePort{servicePort("grpc", 50051, 50051)},
+			wantProblems: 1,
+		},
+		{
+			name:        "stale ports of global service are removed",
+			globalPorts: []corev1.ServicePort{servicePort("http", 80, 8080), servicePort("legacy", 90, 90)},
+			targets:     [][]corev1.ServicePort{{servicePort("http", 80, 8080)}},
+			wantPorts:   []corev1.ServicePort{servicePort("http", 80, 8080)},
+		},
+		{
+			name:        "without target services ports are kept",
+			globalPorts: []corev1.ServicePort{servicePort("http", 80, 8080)},
+			wantPorts:   []corev1.ServicePort{servicePort("http", 80, 8080)},
+		},
+		{
+			name:      "ports of GlobalService win",
+			aggPorts:  []corev1.ServicePort{servicePort("web", 443, 8443)},
+			targets:   [][]corev1.ServicePort{{servicePort("http", 80, 8080)}},
+			wantPorts: []corev1.ServicePort{servicePort("web", 443, 8443)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWatcher(t, Options{})
+			agg := &aggregation{name: "x-global", service: "x", ports: tt.aggPorts, endpoints: make(map[string]int)}
+			targets := make([]*corev1.Service, 0, len(tt.targets))
+			for i, ports := range tt.targets {
+				targets = append(targets, mirroredService("x", "target"+string(rune('1'+i)), ports...))
+			}
+
+			ports := w.globalServicePorts(agg, &corev1.Service{Spec: corev1.ServiceSpec{Ports: tt.globalPorts}}, targets)
+			if !reflect.DeepEqual(ports, tt.wantPorts) {
+				t.Errorf("globalServicePorts() = %v, want %v", ports, tt.wantPorts)
+			}
+			if len(agg.problems) != tt.wantProblems {
+				t.Errorf("problems = %v, want %v of them", agg.problems, tt.wantProblems)
+			}
+
+			// Order of target services doesn't matter.
+			for i, j := 0, len(targets)-1; i < j; i, j = i+1, j-1 {
+				targets[i], targets[j] = targets[j], targets[i]
+			}
+			if reversed := w.globalServicePorts(agg, &corev1.Service{Spec: corev1.ServiceSpec{Ports: tt.globalPorts}}, targets); !reflect.DeepEqual(reversed, ports) {
+				t.Errorf("globalServicePorts() with target services reversed = %v, want %v", reversed, ports)
+			}
+		})
+	}
+}
+
+func TestHandleServiceAdd(t *testing.T) {
+	tests := []struct {
+		name          string
+		headless      bool
+		wantClusterIP string
+	}{
+		{name: "headless", headless: true, wantClusterIP: corev1.ClusterIPNone},
+		{name: "cluster ip", headless: false, wantClusterIP: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWatcher(t, Options{})
+			agg := &aggregation{namespace: testGlobalNamespace, name: "x-global", service: "x", headless: tt.headless, endpoints: make(map[string]int)}
+			targets := []*corev1.Service{mirroredService("x", "target1", servicePort("http", 80, 8080))}
+
+			created, err := w.handleServiceAdd(agg, targets)
+			if err != nil {
+				t.Fatalf("handleServiceAdd() error = %v", err)
+			}
+			if created == nil {
+				t.Fatalf("handleServiceAdd() returned no service")
+			}
+
+			ctx := context.Background()
+			if _, err := w.clientset.CoreV1().Namespaces().Get(ctx, testGlobalNamespace, metav1.GetOptions{}); err != nil {
+				t.Errorf("global namespace not created: %v", err)
+			}
+			svc, err := w.clientset.CoreV1().Services(testGlobalNamespace).Get(ctx, "x-global", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("global service not created: %v", err)
+			}
+			if !hasFinalizer(svc.ObjectMeta) {
+				t.Errorf("finalizers = %v, want %v", svc.Finalizers, globalServiceFinalizer)
+			}
+			if svc.Labels[globalMirrorLabel] != "true" {
+				t.Errorf("labels = %v, want %v=true", svc.Labels, globalMirrorLabel)
+			}
+			if svc.Spec.ClusterIP != tt.wantClusterIP {
+				t.Errorf("clusterIP = %q, want %q", svc.Spec.ClusterIP, tt.wantClusterIP)
+			}
+			if !reflect.DeepEqual(svc.Spec.Ports, targets[0].Spec.Ports) {
+				t.Errorf("ports = %v, want %v", svc.Spec.Ports, targets[0].Spec.Ports)
+			}
+
+			// Applying it again, when cache is behind, leaves it as it is.
+			again, err := w.handleServiceAdd(agg, targets)
+			if err != nil {
+				t.Fatalf("handleServiceAdd() of existing service error = %v", err)
+			}
+			if !reflect.DeepEqual(again.Spec, svc.Spec) {
+				t.Errorf("handleServiceAdd() of existing service = %v, want %v", again.Spec, svc.Spec)
+			}
+		})
+	}
+}
+
+func TestHandleServiceDelete(t *testing.T) {
+	globalSvc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "x-global", Namespace: testGlobalNamespace}}
+
+	tests := []struct {
+		name        string
+		dryRun      bool
+		exists      bool
+		wantDeleted bool
+	}{
+		{name: "deletes global service", exists: true, wantDeleted: true},
+		{name: "already deleted", exists: false, wantDeleted: true},
+		{name: "dry run keeps it", dryRun: true, exists: true, wantDeleted: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWatcher(t, Options{DryRun: tt.dryRun})
+			if tt.exists {
+				w = newTestWatcher(t, Options{DryRun: tt.dryRun}, globalSvc.DeepCopy())
+			}
+
+			if err := w.handleServiceDelete(globalSvc.Namespace, globalSvc.Name); err != nil {
+				t.Fatalf("handleServiceDelete() error = %v", err)
+			}
+
+			_, err := w.clientset.CoreV1().Services(testGlobalNamespace).Get(context.Background(), globalSvc.Name, metav1.GetOptions{})
+			if deleted := apiError.IsNotFound(err); deleted != tt.wantDeleted {
+				t.Errorf("deleted = %v, want %v (err = %v)", deleted, tt.wantDeleted, err)
+			}
+		})
+	}
+}
+
+func TestReconcileGlobalServicePortsOfGlobalService(t *testing.T) {
+	gs := &mirrorv1alpha1.GlobalService{
+		ObjectMeta: metav1.ObjectMeta{Name: "x", Namespace: testGlobalNamespace},
+		Spec: mirrorv1alpha1.GlobalServiceSpec{Service: "x", Ports: []corev1.ServicePort{
+			{Port: 443},
+			{Port: 80, NodePort: 30080},
+		}},
+	}
+	w := newTestWatcher(t, Options{}, gs,
+		mirroredService("x", "target1", servicePort("http", 80, 8080)), mirroredEndpointSlice("x", "target1", "x-0"))
+	if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+		t.Fatalf("reconcileGlobalService() error = %v", err)
+	}
+	syncCache(t, w)
+
+	globalSvc, err := w.clientset.CoreV1().Services(testGlobalNamespace).Get(context.Background(), "x-global", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting global service: %v", err)
+	}
+	want := []corev1.ServicePort{
+		{Name: "tcp-80", Protocol: corev1.ProtocolTCP, Port: 80, TargetPort: intstr.FromInt(80)},
+		{Name: "tcp-443", Protocol: corev1.ProtocolTCP, Port: 443, TargetPort: intstr.FromInt(443)},
+	}
+	if !reflect.DeepEqual(globalSvc.Spec.Ports, want) {
+		t.Errorf("ports = %v, want %v", globalSvc.Spec.Ports, want)
+	}
+
+	// Nothing changed, so global service isn't written again.
+	client := w.clientset.(*fake.Clientset)
+	client.ClearActions()
+	if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+		t.Fatalf("reconcileGlobalService() error = %v", err)
+	}
+	for _, action := range client.Actions() {
+		if action.GetResource().Resource == "services" && action.GetVerb() == "patch" {
+			t.Errorf("global service applied again: %v", action)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package watcher
+
+import (
+	"fmt"
+	"sort"
+
+	mirrorv1alpha1 "github.com/rushi47/service-mirror-prototype/apis/mirror/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apiError "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Type of global service asked for by mirrored service, Headless or ClusterIP.
+const serviceTypeAnnotation = "mirror.linkerd.io/global-service-type"
+
+// ParseServiceType returns the type of global service, empty string being Headless.
+func ParseServiceType(serviceType string) (mirrorv1alpha1.ServiceType, error) {
+	switch mirrorv1alpha1.ServiceType(serviceType) {
+	case "", mirrorv1alpha1.ServiceTypeHeadless:
+		return mirrorv1alpha1.ServiceTypeHeadless, nil
+	case mirrorv1alpha1.ServiceTypeClusterIP:
+		return mirrorv1alpha1.ServiceTypeClusterIP, nil
+	}
+	return "", fmt.Errorf("unknown global service type %q, use %v or %v", serviceType, mirrorv1alpha1.ServiceTypeHeadless, mirrorv1alpha1.ServiceTypeClusterIP)
+}
+
+// globalServiceType returns type of global service: the one GlobalService declares, otherwise the one mirrored
+// services ask for with annotation, otherwise the default one. When mirrored services disagree, the cluster
+// which sorts first wins.
+func (w *Watcher) globalServiceType(agg *aggregation, targetSvcs []*corev1.Service) mirrorv1alpha1.ServiceType {
+	if agg.serviceType != "" {
+		return agg.serviceType
+	}
+
+	sorted := append([]*corev1.Service(nil), targetSvcs...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].GetLabels()[clusterNameLabel] < sorted[j].GetLabels()[clusterNameLabel]
+	})
+
+	var requested mirrorv1alpha1.ServiceType
+	var requestedBy string
+	for _, svc := range sorted {
+		value, ok := svc.GetAnnotations()[serviceTypeAnnotation]
+		if !ok {
+			continue
+		}
+		serviceType, err := ParseServiceType(value)
+		if err != nil {
+			w.warnf(agg, reasonServiceTypeConflict, "Ignoring %v annotation of %v/%v: %v", serviceTypeAnnotation, svc.Namespace, svc.Name, err)
+			continue
+		}
+		if requested == "" {
+			requested, requestedBy = serviceType, svc.Namespace+"/"+svc.Name
+			continue
+		}
+		if serviceType != requested {
+			w.warnf(agg, reasonServiceTypeConflict, "%v/%v asks for %v global service, but %v asks for %v, which wins", svc.Namespace, svc.Name, serviceType, requestedBy, requested)
+		}
+	}
+	if requested != "" {
+		return requested
+	}
+	return w.serviceType
+}
+
+// recreateGlobalService deletes global service whose type or primary IP family doesn't match, as cluster IP can't
+// be added or removed in place, neither can primary family change. It goes through the usual cleanup, and the next
+// reconcile creates it again once it's gone.
+func (svcW *Watcher) recreateGlobalService(agg *aggregation, globalSvc *corev1.Service) error {
+	serviceType := mirrorv1alpha1.ServiceTypeClusterIP
+	if agg.headless {
+		serviceType = mirrorv1alpha1.ServiceTypeHeadless
+	}
+
+	if svcW.dryRun {
+		svcW.log.Infof("[dry-run] Would recreate global service: %v as %v %v", globalSvc.Name, serviceType, agg.ipFamilies)
+		return nil
+	}
+
+	svcW.eventf(agg, reasonServiceRecreated, "Recreating global service as %v %v, type and primary IP family of service can't be changed in place", serviceType, agg.ipFamilies)
+	// Only the service we looked at, not one which got recreated already.
+	uid := globalSvc.UID
+	err := svcW.clientset.CoreV1().Services(globalSvc.Namespace).Delete(svcW.workCtx, globalSvc.Name, metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &uid}})
+	if apiError.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		countAPIError("delete", "services")
+		return fmt.Errorf("unable to delete global service Name=%v to recreate it as %v: %w", globalSvc.Name, serviceType, err)
+	}
+	serviceRecreatesTotal.WithLabelValues(globalSvc.Namespace, globalSvc.Name).Inc()
+	return nil
+}
@@ -0,0 +1,138 @@
+package watcher
+
+import (
+	"context"
+	"testing"
+
+	mirrorv1alpha1 "github.com/rushi47/service-mirror-prototype/apis/mirror/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apiError "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseServiceType(t *testing.T) {
+	tests := []struct {
+		serviceType string
+		want        mirrorv1alpha1.ServiceType
+		wantErr     bool
+	}{
+		{serviceType: "", want: mirrorv1alpha1.ServiceTypeHeadless},
+		{serviceType: "Headless", want: mirrorv1alpha1.ServiceTypeHeadless},
+		{serviceType: "ClusterIP", want: mirrorv1alpha1.ServiceTypeClusterIP},
+		{serviceType: "LoadBalancer", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.serviceType, func(t *testing.T) {
+			got, err := ParseServiceType(tt.serviceType)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ParseServiceType(%q) = %q, %v, want %q, wantErr %v", tt.serviceType, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+// withServiceType sets global service type annotation on mirrored service.
+func withServiceType(svc *corev1.Service, serviceType string) *corev1.Service {
+	svc.Annotations = map[string]string{serviceTypeAnnotation: serviceType}
+	return svc
+}
+
+func TestGlobalServiceType(t *testing.T) {
+	tests := []struct {
+		name         string
+		defaultType  mirrorv1alpha1.ServiceType
+		declared     mirrorv1alpha1.ServiceType
+		svcs         []*corev1.Service
+		want         mirrorv1alpha1.ServiceType
+		wantConflict bool
+	}{
+		{
+			name: "default",
+			svcs: []*corev1.Service{mirroredService("x", "target1")},
+			want: mirrorv1alpha1.ServiceTypeHeadless,
+		},
+		{
+			name:        "default from options",
+			defaultType: mirrorv1alpha1.ServiceTypeClusterIP,
+			svcs:        []*corev1.Service{mirroredService("x", "target1")},
+			want:        mirrorv1alpha1.ServiceTypeClusterIP,
+		},
+		{
+			name: "annotation wins over default",
+			svcs: []*corev1.Service{mirroredService("x", "target1"), withServiceType(mirroredService("x", "target2"), "ClusterIP")},
+			want: mirrorv1alpha1.ServiceTypeClusterIP,
+		},
+		{
+			name:     "GlobalService wins over annotation",
+			declared: mirrorv1alpha1.ServiceTypeHeadless,
+			svcs:     []*corev1.Service{withServiceType(mirroredService("x", "target1"), "ClusterIP")},
+			want:     mirrorv1alpha1.ServiceTypeHeadless,
+		},
+		{
+			name:         "first cluster wins conflict",
+			svcs:         []*corev1.Service{withServiceType(mirroredService("x", "target2"), "Headless"), withServiceType(mirroredService("x", "target1"), "ClusterIP")},
+			want:         mirrorv1alpha1.ServiceTypeClusterIP,
+			wantConflict: true,
+		},
+		{
+			name:         "invalid annotation",
+			svcs:         []*corev1.Service{withServiceType(mirroredService("x", "target1"), "NodePort")},
+			want:         mirrorv1alpha1.ServiceTypeHeadless,
+			wantConflict: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWatcher(t, Options{ServiceType: tt.defaultType})
+			agg := &aggregation{name: "x-global", serviceType: tt.declared, endpoints: make(map[string]int)}
+			if got := w.globalServiceType(agg, tt.svcs); got != tt.want {
+				t.Errorf("globalServiceType() = %v, want %v", got, tt.want)
+			}
+			if got := len(agg.problems) > 0; got != tt.wantConflict {
+				t.Errorf("problems = %v, want conflict %v", agg.problems, tt.wantConflict)
+			}
+		})
+	}
+}
+
+func TestReconcileServiceTypeSwitch(t *testing.T) {
+	w := newTestWatcher(t, Options{AutoAggregate: true},
+		mirroredService("x", "target1", servicePort("http", 80, 8080)),
+		mirroredEndpointSlice("x", "target1", "x-0"))
+	ctx := context.Background()
+	reconcile := func() {
+		t.Helper()
+		if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+			t.Fatalf("reconcileGlobalService() error = %v", err)
+		}
+		syncCache(t, w)
+	}
+
+	reconcile()
+	globalSvc, err := w.clientset.CoreV1().Services(testGlobalNamespace).Get(ctx, "x-global", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting global service: %v", err)
+	}
+	if globalSvc.Spec.ClusterIP != corev1.ClusterIPNone {
+		t.Fatalf("clusterIP = %q, want headless global service", globalSvc.Spec.ClusterIP)
+	}
+
+	applyObject(t, w.clientset, withServiceType(mirroredService("x", "target1", servicePort("http", 80, 8080)), "ClusterIP"))
+	syncCache(t, w)
+	reconcile()
+	if _, err := w.clientset.CoreV1().Services(testGlobalNamespace).Get(ctx, "x-global", metav1.GetOptions{}); !apiError.IsNotFound(err) {
+		t.Fatalf("headless global service not deleted to be recreated: %v", err)
+	}
+	if !hasEvent(w, reasonServiceRecreated) {
+		t.Errorf("no %v event recorded", reasonServiceRecreated)
+	}
+
+	reconcile()
+	globalSvc, err = w.clientset.CoreV1().Services(testGlobalNamespace).Get(ctx, "x-global", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("global service not recreated: %v", err)
+	}
+	if globalSvc.Spec.ClusterIP == corev1.ClusterIPNone {
+		t.Errorf("global service recreated headless, want ClusterIP")
+	}
+}
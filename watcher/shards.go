@@ -0,0 +1,228 @@
+package watcher
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// Default and upper limit of endpoints per global endpointslice, same as the upstream EndpointSlice controller.
+const (
+	defaultMaxEndpointsPerSlice = 100
+	maxEndpointsPerSliceLimit   = 1000
+)
+
+// Global endpointslice names are kept as short as the ones apiserver generates.
+const maxShardNameLength = 63
+
+// shardName is the name of n-th global endpointslice of mirrored endpointslice, <mirrored endpointslice>-global-<n>.
+// Long names of mirrored endpointslices are cut, with hash of the full name keeping them apart.
+func shardName(source string, n int) string {
+	suffix := fmt.Sprintf("-global-%d", n)
+	if len(source)+len(suffix) > maxShardNameLength {
+		h := fnv.New32a()
+		h.Write([]byte(source))
+		hash := fmt.Sprintf("-%08x", h.Sum32())
+		source = source[:maxShardNameLength-len(suffix)-len(hash)] + hash
+	}
+	return source + suffix
+}
+
+// shardNames gives out names of new global endpointslices of mirrored endpointslice, lowest n not taken by
+// existing ones first. Names only depend on what is in cache, so reconciling again before the cache has global
+// endpointslices just created applies them again under the same names, rather than creating duplicates.
+type shardNames struct {
+	source string
+	taken  map[string]bool
+	next   int
+}
+
+func newShardNames(source string, existing []*discoveryv1.EndpointSlice) *shardNames {
+	taken := make(map[string]bool, len(existing))
+	for _, eps := range existing {
+		taken[eps.Name] = true
+	}
+	return &shardNames{source: source, taken: taken}
+}
+
+func (n *shardNames) name() string {
+	for {
+		name := shardName(n.source, n.next)
+		n.next++
+		if !n.taken[name] {
+			n.taken[name] = true
+			return name
+		}
+	}
+}
+
+// shardUpdate is an existing global endpointslice with the endpoints it should hold.
+type shardUpdate struct {
+	slice     *discoveryv1.EndpointSlice
+	endpoints []discoveryv1.Endpoint
+}
+
+// shardPlan is how endpoints of mirrored endpointslice get spread over global endpointslices.
+type shardPlan struct {
+	update []shardUpdate
+	create [][]discoveryv1.Endpoint
+	delete []*discoveryv1.EndpointSlice
+}
+
+// endpointKey identifies endpoint across updates, so it stays in the global endpointslice it is in.
+func endpointKey(ep discoveryv1.Endpoint) string {
+	return strings.Join(ep.Addresses, ",")
+}
+
+// planShards spreads endpoints over existing global endpointslices, with at most maxPerSlice endpoints in each.
+// Like the upstream EndpointSlice controller, it tries to touch as few endpointslices as possible:
+//   - endpoints stay in the endpointslice they already are in, unless it has too many of them,
+//   - new endpoints go first to endpointslices which are updated anyway, then to ones with room left,
+//   - only then new endpointslices are created, and the ones left empty are deleted.
+func planShards(shards []*discoveryv1.EndpointSlice, endpoints []discoveryv1.Endpoint, maxPerSlice int) shardPlan {
+	sorted := append([]*discoveryv1.EndpointSlice(nil), shards...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	desired := make(map[string]discoveryv1.Endpoint, len(endpoints))
+	order := make([]string, 0, len(endpoints))
+	for _, ep := range endpoints {
+		key := endpointKey(ep)
+		if _, ok := desired[key]; ok {
+			continue
+		}
+		desired[key] = ep
+		order = append(order, key)
+	}
+
+	// Keep endpoints where they are.
+	placed := make(map[string]bool, len(desired))
+	kept := make([]shardUpdate, 0, len(sorted))
+	changed := make([]bool, 0, len(sorted))
+	for _, slice := range sorted {
+		eps := make([]discoveryv1.Endpoint, 0, len(slice.Endpoints))
+		for _, ep := range slice.Endpoints {
+			key := endpointKey(ep)
+			want, ok := desired[key]
+			if !ok || placed[key] || len(eps) >= maxPerSlice {
+				continue
+			}
+			placed[key] = true
+			eps = append(eps, want)
+		}
+		kept = append(kept, shardUpdate{slice: slice, endpoints: eps})
+		changed = append(changed, !equalEndpoints(eps, slice.Endpoints))
+	}
+
+	remaining := make([]discoveryv1.Endpoint, 0)
+	for _, key := range order {
+		if !placed[key] {
+			remaining = append(remaining, desired[key])
+		}
+	}
+
+	// Fill the endpointslices which are updated anyway first, then the rest.
+	fill := func(onlyChanged bool) {
+		for i := range kept {
+			if len(remaining) == 0 {
+				return
+			}
+			if onlyChanged && !changed[i] {
+				continue
+			}
+			room := maxPerSlice - len(kept[i].endpoints)
+			if room <= 0 {
+				continue
+			}
+			if room > len(remaining) {
+				room = len(remaining)
+			}
+			kept[i].endpoints = append(kept[i].endpoints, remaining[:room]...)
+			remaining = remaining[room:]
+			changed[i] = true
+		}
+	}
+	fill(true)
+	fill(false)
+
+	plan := shardPlan{}
+	for len(remaining) > 0 {
+		n := maxPerSlice
+		if n > len(remaining) {
+			n = len(remaining)
+		}
+		plan.create = append(plan.create, remaining[:n])
+		remaining = remaining[n:]
+	}
+	for _, shard := range kept {
+		if len(shard.endpoints) == 0 {
+			plan.delete = append(plan.delete, shard.slice)
+			continue
+		}
+		plan.update = append(plan.update, shard)
+	}
+	return plan
+}
+
+func equalEndpoints(a, b []discoveryv1.Endpoint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if endpointKey(a[i]) != endpointKey(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// syncShards makes the global endpointslices of mirrored endpointslice hold its endpoints, as planned by planShards.
+// IPv4 and IPv6 endpoints go to global endpointslices of their own, planned separately.
+func (epsW *Watcher) syncShards(globalSvc *corev1.Service, eps *discoveryv1.EndpointSlice, shards []*discoveryv1.EndpointSlice, endpoints []discoveryv1.Endpoint) error {
+	split := splitByAddressType(eps.AddressType, endpoints)
+	shardsOf := make(map[discoveryv1.AddressType][]*discoveryv1.EndpointSlice)
+	for _, shard := range shards {
+		shardsOf[shard.AddressType] = append(shardsOf[shard.AddressType], shard)
+	}
+
+	// Names are given out across address types, global endpointslices of both are named after the same mirrored one.
+	// Names of other global endpointslices are taken too, mirrored endpointslices of different namespaces can
+	// have the same name.
+	inNamespace, err := epsW.epsLister.EndpointSlices(globalSvc.Namespace).List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("unable to list endpointslices of namespace %v from cache: %w", globalSvc.Namespace, err)
+	}
+	names := newShardNames(eps.Name, append(inNamespace, shards...))
+	var errs []error
+	for _, addressType := range addressTypes {
+		errs = append(errs, epsW.syncAddressTypeShards(globalSvc, eps, addressType, shardsOf[addressType], split[addressType], names))
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func (epsW *Watcher) syncAddressTypeShards(globalSvc *corev1.Service, eps *discoveryv1.EndpointSlice, addressType discoveryv1.AddressType, shards []*discoveryv1.EndpointSlice, endpoints []discoveryv1.Endpoint, names *shardNames) error {
+	plan := planShards(shards, endpoints, epsW.maxEndpointsPerSlice)
+
+	var errs []error
+	for _, shard := range plan.update {
+		shard := shard
+		errs = append(errs, runHandler(handlerEpsUpdate, func() error {
+			return epsW.handleEpsUpdate(globalSvc, shard.slice.DeepCopy(), *eps.DeepCopy(), shard.endpoints)
+		}))
+	}
+	for _, endpoints := range plan.create {
+		endpoints := endpoints
+		name := names.name()
+		errs = append(errs, runHandler(handlerEpsAdd, func() error { return epsW.handleEpsAdd(globalSvc, *eps.DeepCopy(), name, addressType, endpoints) }))
+	}
+	for _, shard := range plan.delete {
+		shard := shard
+		errs = append(errs, runHandler(handlerEpsDelete, func() error { return epsW.handleEpsDelete(*shard) }))
+	}
+	return utilerrors.NewAggregate(errs)
+}
@@ -0,0 +1,271 @@
+package watcher
+
+import (
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// testEndpoints returns endpoints with addresses 10.0.0.<n> for n in ns.
+func testEndpoints(ns ...int) []discoveryv1.Endpoint {
+	endpoints := make([]discoveryv1.Endpoint, 0, len(ns))
+	for _, n := range ns {
+		hostname := fmt.Sprintf("x-%v-target1", n)
+		endpoints = append(endpoints, discoveryv1.Endpoint{Addresses: []string{fmt.Sprintf("10.0.0.%v", n)}, Hostname: &hostname})
+	}
+	return endpoints
+}
+
+func testShard(name string, ns ...int) *discoveryv1.EndpointSlice {
+	return &discoveryv1.EndpointSlice{ObjectMeta: metav1.ObjectMeta{Name: name}, Endpoints: testEndpoints(ns...)}
+}
+
+func endpointNumbers(endpoints []discoveryv1.Endpoint) []int {
+	ns := make([]int, 0, len(endpoints))
+	for _, ep := range endpoints {
+		var n int
+		fmt.Sscanf(ep.Addresses[0], "10.0.0.%d", &n)
+		ns = append(ns, n)
+	}
+	return ns
+}
+
+func TestPlanShards(t *testing.T) {
+	tests := []struct {
+		name      string
+		shards    []*discoveryv1.EndpointSlice
+		endpoints []discoveryv1.Endpoint
+		max       int
+		// Endpoints each existing shard ends up with, by name.
+		wantUpdate map[string][]int
+		wantCreate [][]int
+		wantDelete []string
+	}{
+		{
+			name:       "nothing exists",
+			endpoints:  testEndpoints(1, 2, 3, 4, 5),
+			max:        2,
+			wantUpdate: map[string][]int{},
+			wantCreate: [][]int{{1, 2}, {3, 4}, {5}},
+		},
+		{
+			name:       "nothing changes",
+			shards:     []*discoveryv1.EndpointSlice{testShard("a", 1, 2), testShard("b", 3)},
+			endpoints:  testEndpoints(1, 2, 3),
+			max:        2,
+			wantUpdate: map[string][]int{"a": {1, 2}, "b": {3}},
+		},
+		{
+			name:       "new endpoint goes to shard with room",
+			shards:     []*discoveryv1.EndpointSlice{testShard("a", 1, 2), testShard("b", 3)},
+			endpoints:  testEndpoints(1, 2, 3, 4),
+			max:        2,
+			wantUpdate: map[string][]int{"a": {1, 2}, "b": {3, 4}},
+		},
+		{
+			name:       "new endpoint prefers shard updated anyway",
+			shards:     []*discoveryv1.EndpointSlice{testShard("a", 1, 2, 3), testShard("b", 4, 5, 6)},
+			endpoints:  testEndpoints(1, 2, 3, 4, 6, 7),
+			max:        3,
+			wantUpdate: map[string][]int{"a": {1, 2, 3}, "b": {4, 6, 7}},
+		},
+		{
+			name:       "removed endpoint only touches its shard",
+			shards:     []*discoveryv1.EndpointSlice{testShard("a", 1, 2), testShard("b", 3, 4)},
+			endpoints:  testEndpoints(1, 2, 4),
+			max:        2,
+			wantUpdate: map[string][]int{"a": {1, 2}, "b": {4}},
+		},
+		{
+			name:       "emptied shard is deleted",
+			shards:     []*discoveryv1.EndpointSlice{testShard("a", 1, 2), testShard("b", 3, 4)},
+			endpoints:  testEndpoints(1, 2),
+			max:        2,
+			wantUpdate: map[string][]int{"a": {1, 2}},
+			wantDelete: []string{"b"},
+		},
+		{
+			name:       "overflow creates new shard",
+			shards:     []*discoveryv1.EndpointSlice{testShard("a", 1, 2)},
+			endpoints:  testEndpoints(1, 2, 3),
+			max:        2,
+			wantUpdate: map[string][]int{"a": {1, 2}},
+			wantCreate: [][]int{{3}},
+		},
+		{
+			name:       "lowered max splits shard",
+			shards:     []*discoveryv1.EndpointSlice{testShard("a", 1, 2, 3)},
+			endpoints:  testEndpoints(1, 2, 3),
+			max:        2,
+			wantUpdate: map[string][]int{"a": {1, 2}},
+			wantCreate: [][]int{{3}},
+		},
+		{
+			name:       "no endpoints left",
+			shards:     []*discoveryv1.EndpointSlice{testShard("a", 1)},
+			max:        2,
+			wantUpdate: map[string][]int{},
+			wantDelete: []string{"a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := planShards(tt.shards, tt.endpoints, tt.max)
+
+			gotUpdate := make(map[string][]int)
+			for _, shard := range plan.update {
+				gotUpdate[shard.slice.Name] = endpointNumbers(shard.endpoints)
+			}
+			if !reflect.DeepEqual(gotUpdate, tt.wantUpdate) {
+				t.Errorf("update = %v, want %v", gotUpdate, tt.wantUpdate)
+			}
+
+			var gotCreate [][]int
+			for _, endpoints := range plan.create {
+				gotCreate = append(gotCreate, endpointNumbers(endpoints))
+			}
+			if !reflect.DeepEqual(gotCreate, tt.wantCreate) {
+				t.Errorf("create = %v, want %v", gotCreate, tt.wantCreate)
+			}
+
+			var gotDelete []string
+			for _, shard := range plan.delete {
+				gotDelete = append(gotDelete, shard.Name)
+			}
+			if !reflect.DeepEqual(gotDelete, tt.wantDelete) {
+				t.Errorf("delete = %v, want %v", gotDelete, tt.wantDelete)
+			}
+		})
+	}
+}
+
+func TestReconcileShardsEndpoints(t *testing.T) {
+	hosts := func(ns ...int) []string {
+		hostnames := make([]string, 0, len(ns))
+		for _, n := range ns {
+			hostnames = append(hostnames, fmt.Sprintf("x-%v", n))
+		}
+		return hostnames
+	}
+	eps := mirroredEndpointSlice("x", "target1", hosts(0, 1, 2, 3, 4)...)
+	w := newTestWatcher(t, Options{AutoAggregate: true, MaxEndpointsPerSlice: 2},
+		mirroredService("x", "target1", servicePort("http", 80, 8080)), eps)
+
+	if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+		t.Fatalf("reconcileGlobalService() error = %v", err)
+	}
+	syncCache(t, w)
+	if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+		t.Fatalf("reconcileGlobalService() error = %v", err)
+	}
+
+	shards := globalSlicesOf(t, w, "x-target1")
+	if len(shards) != 3 {
+		t.Fatalf("got %v global endpointslices, want 3", len(shards))
+	}
+	got := make([]string, 0)
+	for _, shard := range shards {
+		if len(shard.Endpoints) > 2 {
+			t.Errorf("global endpointslice %v has %v endpoints, want at most 2", shard.Name, len(shard.Endpoints))
+		}
+		if shard.Labels[serviceNameLabel] != "x-global" || shard.Labels[clusterNameLabel] != "target1" {
+			t.Errorf("global endpointslice %v labels = %v", shard.Name, shard.Labels)
+		}
+		for _, ep := range shard.Endpoints {
+			got = append(got, *ep.Hostname)
+		}
+	}
+	sort.Strings(got)
+	want := []string{"x-0-target1", "x-1-target1", "x-2-target1", "x-3-target1", "x-4-target1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("hostnames = %v, want %v", got, want)
+	}
+
+	// Pod going away only touches the global endpointslice it was in.
+	client := w.clientset.(*fake.Clientset)
+	client.ClearActions()
+	eps.Endpoints = eps.Endpoints[1:]
+	applyObject(t, w.clientset, eps)
+	syncCache(t, w)
+	if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+		t.Fatalf("reconcileGlobalService() error = %v", err)
+	}
+	writes := 0
+	for _, action := range client.Actions() {
+		if action.GetResource().Resource == "endpointslices" && action.GetNamespace() == testGlobalNamespace && action.GetVerb() != "list" {
+			writes++
+		}
+	}
+	if writes != 1 {
+		t.Errorf("got %v writes to global endpointslices, want 1", writes)
+	}
+}
+
+func TestShardName(t *testing.T) {
+	long := strings.Repeat("a", 70)
+	tests := []struct {
+		name   string
+		source string
+		n      int
+		want   string
+	}{
+		{name: "short", source: "x-target1-abcde", n: 0, want: "x-target1-abcde-global-0"},
+		{name: "long is cut", source: long, n: 12, want: long[:maxShardNameLength-len("-global-12")-9] + "-" + fmt.Sprintf("%08x", fnv32a(long)) + "-global-12"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shardName(tt.source, tt.n); got != tt.want || len(got) > maxShardNameLength {
+				t.Errorf("shardName(%q, %v) = %q, want %q", tt.source, tt.n, got, tt.want)
+			}
+		})
+	}
+	if a, b := shardName(long+"-a", 0), shardName(long+"-b", 0); a == b {
+		t.Errorf("shardName() = %q for both long names, want them apart", a)
+	}
+}
+
+func fnv32a(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+func TestShardNamesSkipTakenNames(t *testing.T) {
+	names := newShardNames("x-target1-abcde", []*discoveryv1.EndpointSlice{testShard("x-target1-abcde-global-0"), testShard("x-target1-abcde-global-2")})
+	got := []string{names.name(), names.name()}
+	want := []string{"x-target1-abcde-global-1", "x-target1-abcde-global-3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("names = %v, want %v", got, want)
+	}
+}
+
+func TestReconcileShardsBeforeCacheSync(t *testing.T) {
+	w := newTestWatcher(t, Options{AutoAggregate: true, MaxEndpointsPerSlice: 1},
+		mirroredService("x", "target1", servicePort("http", 80, 8080)), mirroredEndpointSlice("x", "target1", "x-0", "x-1"))
+	if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+		t.Fatalf("reconcileGlobalService() error = %v", err)
+	}
+	syncCache(t, w)
+	// New endpoint gets a global endpointslice of its own, which isn't in cache when it's reconciled again.
+	eps := mirroredEndpointSlice("x", "target1", "x-0", "x-1", "x-2")
+	applyObject(t, w.clientset, eps)
+	if err := w.MirroredFactories[metav1.NamespaceAll].Discovery().V1().EndpointSlices().Informer().GetIndexer().Update(eps); err != nil {
+		t.Fatalf("updating cache: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+			t.Fatalf("reconcileGlobalService() error = %v", err)
+		}
+	}
+
+	if shards := globalSlicesOf(t, w, "x-target1"); len(shards) != 3 {
+		t.Errorf("got %v global endpointslices, want 3", len(shards))
+	}
+}
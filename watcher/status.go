@@ -0,0 +1,85 @@
+package watcher
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	mirrorv1alpha1 "github.com/rushi47/service-mirror-prototype/apis/mirror/v1alpha1"
+	mirrorac "github.com/rushi47/service-mirror-prototype/generated/applyconfiguration/mirror/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apiError "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// updateGlobalServiceStatus reports how the last reconcile of global service went on its GlobalService.
+func (w *Watcher) updateGlobalServiceStatus(agg *aggregation, syncErr error) error {
+	gs := agg.globalService.DeepCopy()
+
+	clusters := make([]string, 0, len(agg.endpoints))
+	endpointCount := 0
+	for cluster, count := range agg.endpoints {
+		endpointCount += count
+		if count > 0 {
+			clusters = append(clusters, cluster)
+		}
+	}
+	sort.Strings(clusters)
+
+	gs.Status.ObservedGeneration = gs.Generation
+	gs.Status.ClustersContributing = clusters
+	gs.Status.EndpointCount = int32(endpointCount)
+
+	ready := metav1.Condition{
+		Type:               mirrorv1alpha1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Synced",
+		Message:            fmt.Sprintf("Global service %v has %v endpoints from %v clusters", agg.name, endpointCount, len(clusters)),
+		ObservedGeneration: gs.Generation,
+	}
+	switch {
+	case syncErr != nil:
+		ready.Status, ready.Reason, ready.Message = metav1.ConditionFalse, "SyncFailed", syncErr.Error()
+	case agg.mirroredServices == 0:
+		ready.Status, ready.Reason, ready.Message = metav1.ConditionFalse, "NoMirroredServices", fmt.Sprintf("No mirrored services found for %v", agg.service)
+	case endpointCount == 0:
+		ready.Status, ready.Reason, ready.Message = metav1.ConditionFalse, "NoEndpoints", fmt.Sprintf("Global service %v has no endpoints", agg.name)
+	}
+	meta.SetStatusCondition(&gs.Status.Conditions, ready)
+
+	degraded := metav1.Condition{
+		Type:               mirrorv1alpha1.ConditionDegraded,
+		Status:             metav1.ConditionFalse,
+		Reason:             "AllAggregated",
+		Message:            "All mirrored services and endpointslices are aggregated",
+		ObservedGeneration: gs.Generation,
+	}
+	if len(agg.problems) > 0 {
+		// Keep the message stable, so status doesn't change on every reconcile.
+		problems := append([]string(nil), agg.problems...)
+		sort.Strings(problems)
+		degraded.Status, degraded.Reason, degraded.Message = metav1.ConditionTrue, "PartiallyAggregated", strings.Join(problems, "; ")
+	}
+	meta.SetStatusCondition(&gs.Status.Conditions, degraded)
+
+	if equality.Semantic.DeepEqual(agg.globalService.Status, gs.Status) {
+		return nil
+	}
+
+	status := mirrorac.GlobalServiceStatus().
+		WithObservedGeneration(gs.Status.ObservedGeneration).
+		WithClustersContributing(gs.Status.ClustersContributing...).
+		WithEndpointCount(gs.Status.EndpointCount).
+		WithConditions(gs.Status.Conditions...)
+	apply := mirrorac.GlobalService(gs.Name, gs.Namespace).WithStatus(status)
+	_, err := w.mirrorClient.MirrorV1alpha1().GlobalServices(gs.Namespace).ApplyStatus(w.workCtx, apply, w.applyOptions())
+	if apiError.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		countAPIError("apply", "globalservices/status")
+		return fmt.Errorf("unable to update status of GlobalService %v: %w", gs.Name, w.explainConflict(err))
+	}
+	return nil
+}
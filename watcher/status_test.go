@@ -0,0 +1,90 @@
+package watcher
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	mirrorv1alpha1 "github.com/rushi47/service-mirror-prototype/apis/mirror/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestUpdateGlobalServiceStatus(t *testing.T) {
+	gs := &mirrorv1alpha1.GlobalService{
+		ObjectMeta: metav1.ObjectMeta{Name: "x", Namespace: testGlobalNamespace, Generation: 3},
+		Spec:       mirrorv1alpha1.GlobalServiceSpec{Service: "x"},
+	}
+
+	tests := []struct {
+		name         string
+		objects      []runtime.Object
+		wantReady    metav1.ConditionStatus
+		wantReason   string
+		wantCount    int32
+		wantClusters []string
+	}{
+		{
+			name: "healthy",
+			objects: []runtime.Object{
+				mirroredService("x", "target1", servicePort("http", 80, 8080)), mirroredEndpointSlice("x", "target1", "x-0", "x-1"),
+				mirroredService("x", "target2", servicePort("http", 80, 8080)), mirroredEndpointSlice("x", "target2", "x-0"),
+			},
+			wantReady:    metav1.ConditionTrue,
+			wantReason:   "Synced",
+			wantCount:    3,
+			wantClusters: []string{"target1", "target2"},
+		},
+		{
+			name:         "no endpoints",
+			objects:      []runtime.Object{mirroredService("x", "target1", servicePort("http", 80, 8080))},
+			wantReady:    metav1.ConditionFalse,
+			wantReason:   "NoEndpoints",
+			wantClusters: []string{},
+		},
+		{
+			name:         "no mirrored services",
+			wantReady:    metav1.ConditionFalse,
+			wantReason:   "NoMirroredServices",
+			wantClusters: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWatcher(t, Options{}, append([]runtime.Object{gs}, tt.objects...)...)
+			if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+				t.Fatalf("reconcileGlobalService() error = %v", err)
+			}
+
+			got, err := w.mirrorClient.MirrorV1alpha1().GlobalServices(testGlobalNamespace).Get(context.Background(), "x", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("getting GlobalService: %v", err)
+			}
+			if got.Status.ObservedGeneration != gs.Generation {
+				t.Errorf("observedGeneration = %v, want %v", got.Status.ObservedGeneration, gs.Generation)
+			}
+			if got.Status.EndpointCount != tt.wantCount {
+				t.Errorf("endpointCount = %v, want %v", got.Status.EndpointCount, tt.wantCount)
+			}
+			clusters := got.Status.ClustersContributing
+			if clusters == nil {
+				clusters = []string{}
+			}
+			if !reflect.DeepEqual(clusters, tt.wantClusters) {
+				t.Errorf("clustersContributing = %v, want %v", clusters, tt.wantClusters)
+			}
+			ready := meta.FindStatusCondition(got.Status.Conditions, mirrorv1alpha1.ConditionReady)
+			if ready == nil {
+				t.Fatalf("no %v condition in %v", mirrorv1alpha1.ConditionReady, got.Status.Conditions)
+			}
+			if ready.Status != tt.wantReady || ready.Reason != tt.wantReason || ready.ObservedGeneration != gs.Generation {
+				t.Errorf("%v = %v/%v at generation %v, want %v/%v at %v", ready.Type, ready.Status, ready.Reason, ready.ObservedGeneration,
+					tt.wantReady, tt.wantReason, gs.Generation)
+			}
+			if degraded := meta.FindStatusCondition(got.Status.Conditions, mirrorv1alpha1.ConditionDegraded); degraded == nil || degraded.Status != metav1.ConditionFalse {
+				t.Errorf("%v = %v, want False", mirrorv1alpha1.ConditionDegraded, degraded)
+			}
+		})
+	}
+}
@@ -0,0 +1,432 @@
+package watcher
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apiError "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/selection"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+)
+
+// TrafficSplitKind is the resource splitting traffic of apex service across mirrored services, next to global service.
+type TrafficSplitKind string
+
+const (
+	// TrafficSplitNone doesn't create apex service, global service is the only way to reach mirrored services.
+	TrafficSplitNone TrafficSplitKind = "none"
+	// TrafficSplitSMI creates SMI TrafficSplit, split.smi-spec.io/v1alpha2.
+	TrafficSplitSMI TrafficSplitKind = "smi"
+	// TrafficSplitHTTPRoute creates Gateway API HTTPRoute for every port, gateway.networking.k8s.io/v1beta1.
+	TrafficSplitHTTPRoute TrafficSplitKind = "httproute"
+)
+
+var (
+	trafficSplitResource = schema.GroupVersionResource{Group: "split.smi-spec.io", Version: "v1alpha2", Resource: "trafficsplits"}
+	httpRouteResource    = schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1beta1", Resource: "httproutes"}
+)
+
+const (
+	// Set on apex service and traffic split resources, to the global service they are next to and its namespace.
+	trafficSplitLabel          = "mirror.linkerd.io/traffic-split-of"
+	trafficSplitNamespaceLabel = "mirror.linkerd.io/traffic-split-of-namespace"
+	// Weight of mirrored service in traffic split, set on mirrored service. Defaults to its ready endpoints.
+	weightAnnotation = "mirror.linkerd.io/global-weight"
+)
+
+// ParseTrafficSplitKind returns the kind, empty string being none.
+func ParseTrafficSplitKind(kind string) (TrafficSplitKind, error) {
+	switch TrafficSplitKind(kind) {
+	case "", TrafficSplitNone:
+		return TrafficSplitNone, nil
+	case TrafficSplitSMI, TrafficSplitHTTPRoute:
+		return TrafficSplitKind(kind), nil
+	}
+	return "", fmt.Errorf("unknown traffic split %q, use one of %v, %v or %v", kind, TrafficSplitNone, TrafficSplitSMI, TrafficSplitHTTPRoute)
+}
+
+// apexName is the name of ClusterIP service traffic split applies to, x-global-split for x-global.
+func apexName(globalSvcName string) string {
+	return globalSvcName + "-split"
+}
+
+// backend is mirrored service traffic is split to.
+type backend struct {
+	service string
+	weight  int
+}
+
+// trafficSplitBackends returns mirrored services with their weights, sorted by cluster. Weight comes from annotation
+// of the mirrored service, otherwise it's the number of its ready endpoints, see splitReadyEndpoints. Clusters which are withdrawn from
+// global service get none of the traffic.
+func (w *Watcher) trafficSplitBackends(agg *aggregation, targetSvcs []*corev1.Service, ready map[string]int, withdrawn map[string]bool) []backend {
+	sorted := append([]*corev1.Service(nil), targetSvcs...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].GetLabels()[clusterNameLabel] < sorted[j].GetLabels()[clusterNameLabel]
+	})
+
+	backends := make([]backend, 0, len(sorted))
+	for _, svc := range sorted {
+		cluster := svc.GetLabels()[clusterNameLabel]
+		weight := ready[cluster]
+		if value, ok := svc.GetAnnotations()[weightAnnotation]; ok {
+			if n, err := strconv.Atoi(value); err != nil || n < 0 {
+				w.warnf(agg, reasonTrafficSplitSkipped, "Ignoring %v annotation %q of %v/%v, it isn't a non-negative number", weightAnnotation, value, svc.Namespace, svc.Name)
+			} else {
+				weight = n
+			}
+		}
+		if withdrawn[cluster] {
+			weight = 0
+		}
+		backends = append(backends, backend{service: svc.Name, weight: weight})
+	}
+	return backends
+}
+
+// withoutSplitOnly leaves out mirrored endpointslices of ClusterIP mirrors when traffic split is on. They only have
+// the gateway IP without hostname, so traffic split is the only way global service reaches them, not global
+// endpointslices.
+func (w *Watcher) withoutSplitOnly(targetSvcs []*corev1.Service, targetEps []*discoveryv1.EndpointSlice) []*discoveryv1.EndpointSlice {
+	if w.trafficSplit == TrafficSplitNone {
+		return targetEps
+	}
+	clusterIP := clusterIPMirrors(targetSvcs)
+	filtered := make([]*discoveryv1.EndpointSlice, 0, len(targetEps))
+	for _, eps := range targetEps {
+		if !clusterIP[mirrorOf(eps)] {
+			filtered = append(filtered, eps)
+		}
+	}
+	return filtered
+}
+
+// syncTrafficSplit creates ClusterIP apex service next to mirrored services, and traffic split resource
+// splitting its traffic across them. They are in namespace of mirrored services, as traffic split
+// can only point at services in its own namespace.
+func (w *Watcher) syncTrafficSplit(agg *aggregation, globalSvc *corev1.Service, targetSvcs []*corev1.Service, ready map[string]int, withdrawn map[string]bool) error {
+	if len(targetSvcs) == 0 {
+		return nil
+	}
+	// Mirrored services of one global service are all in the same namespace, see sourceNamespace.
+	namespace := targetSvcs[0].Namespace
+	apex := apexName(globalSvc.Name)
+	if err := validateGlobalName(apex); err != nil {
+		w.warnf(agg, reasonTrafficSplitSkipped, "Traffic split not created: %v", err)
+		return nil
+	}
+
+	if err := w.applyApexService(globalSvc, namespace, apex); err != nil {
+		return err
+	}
+
+	backends := w.trafficSplitBackends(agg, targetSvcs, ready, withdrawn)
+	objects := make([]*unstructured.Unstructured, 0)
+	switch w.trafficSplit {
+	case TrafficSplitSMI:
+		objects = append(objects, smiTrafficSplit(globalSvc, namespace, apex, backends))
+	case TrafficSplitHTTPRoute:
+		for _, port := range globalSvc.Spec.Ports {
+			objects = append(objects, httpRoute(globalSvc, namespace, apex, port.Port, backends))
+		}
+	}
+
+	existing, err := w.cachedSplitObjects(namespace, globalSvc)
+	if err != nil {
+		return err
+	}
+	cached := make(map[string]*unstructured.Unstructured, len(existing))
+	for _, obj := range existing {
+		cached[obj.GetName()] = obj
+	}
+	resource := w.splitResource()
+	desired := make(map[string]bool, len(objects))
+	for _, obj := range objects {
+		desired[obj.GetName()] = true
+		if splitObjectSynced(obj, cached[obj.GetName()]) {
+			continue
+		}
+		if _, err := w.dynamicClient.Resource(resource).Namespace(namespace).Apply(w.workCtx, obj.GetName(), obj, w.applyOptions()); err != nil {
+			countAPIError("apply", resource.Resource)
+			return fmt.Errorf("unable to apply %v %v/%v: %w", obj.GetKind(), namespace, obj.GetName(), w.explainConflict(err))
+		}
+	}
+
+	// Routes of ports global service doesn't have anymore.
+	var errs []error
+	for _, obj := range existing {
+		if !desired[obj.GetName()] {
+			errs = append(errs, w.deleteTrafficSplitObject(resource, namespace, obj.GetName()))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// splitObjectSynced reports if cached traffic split resource has everything we apply. Fields apiserver defaults
+// and ones of other field managers don't count.
+func splitObjectSynced(desired, cached *unstructured.Unstructured) bool {
+	if cached == nil {
+		return false
+	}
+	for k, v := range desired.GetLabels() {
+		if cached.GetLabels()[k] != v {
+			return false
+		}
+	}
+	return hasAppliedFields(desired.Object["spec"], cached.Object["spec"])
+}
+
+// hasAppliedFields reports if got has every field of want, with the same value. Lists have to be of the same
+// length, as we apply them whole.
+func hasAppliedFields(want, got interface{}) bool {
+	switch want := want.(type) {
+	case map[string]interface{}:
+		got, ok := got.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for k, v := range want {
+			if !hasAppliedFields(v, got[k]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		got, ok := got.([]interface{})
+		if !ok || len(got) != len(want) {
+			return false
+		}
+		for i := range want {
+			if !hasAppliedFields(want[i], got[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return equality.Semantic.DeepEqual(want, got)
+}
+
+// applyApexService applies ClusterIP service with ports of global service, without selector.
+// Traffic to it is split by the mesh, so it doesn't need endpoints.
+func (w *Watcher) applyApexService(globalSvc *corev1.Service, namespace, name string) error {
+	cached, err := w.apexLister.Services(namespace).Get(name)
+	switch {
+	case err == nil && apexSynced(globalSvc, cached):
+		return nil
+	case err != nil && !apiError.IsNotFound(err):
+		return fmt.Errorf("unable to get apex service %v/%v from cache: %w", namespace, name, err)
+	}
+
+	spec := corev1ac.ServiceSpec().WithType(corev1.ServiceTypeClusterIP)
+	for _, port := range globalSvc.Spec.Ports {
+		p := corev1ac.ServicePort().WithPort(port.Port).WithTargetPort(port.TargetPort)
+		if port.Name != "" {
+			p.WithName(port.Name)
+		}
+		if port.Protocol != "" {
+			p.WithProtocol(port.Protocol)
+		}
+		if port.AppProtocol != nil {
+			p.WithAppProtocol(*port.AppProtocol)
+		}
+		spec.WithPorts(p)
+	}
+	apply := corev1ac.Service(name, namespace).
+		WithLabels(trafficSplitLabels(globalSvc)).
+		WithSpec(spec)
+	if _, err := w.clientset.CoreV1().Services(namespace).Apply(w.workCtx, apply, w.applyOptions()); err != nil {
+		countAPIError("apply", "services")
+		return fmt.Errorf("unable to apply apex service %v/%v of %v: %w", namespace, name, globalSvc.Name, w.explainConflict(err))
+	}
+	return nil
+}
+
+// apexSynced reports if cached apex service has the labels and ports we apply. Ports of global service are
+// defaulted already, so they compare as they are.
+func apexSynced(globalSvc, cached *corev1.Service) bool {
+	for k, v := range trafficSplitLabels(globalSvc) {
+		if cached.Labels[k] != v {
+			return false
+		}
+	}
+	if cached.Spec.Type != corev1.ServiceTypeClusterIP || len(cached.Spec.Ports) != len(globalSvc.Spec.Ports) {
+		return false
+	}
+	for i, port := range globalSvc.Spec.Ports {
+		got := cached.Spec.Ports[i]
+		if got.Name != port.Name || got.Port != port.Port || got.TargetPort != port.TargetPort || got.Protocol != port.Protocol ||
+			!reflect.DeepEqual(got.AppProtocol, port.AppProtocol) {
+			return false
+		}
+	}
+	return true
+}
+
+func smiTrafficSplit(globalSvc *corev1.Service, namespace, apex string, backends []backend) *unstructured.Unstructured {
+	refs := make([]interface{}, 0, len(backends))
+	for _, b := range backends {
+		refs = append(refs, map[string]interface{}{"service": b.service, "weight": int64(b.weight)})
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": trafficSplitResource.GroupVersion().String(),
+		"kind":       "TrafficSplit",
+		"metadata":   trafficSplitMeta(globalSvc, namespace, apex),
+		"spec": map[string]interface{}{
+			"service":  apex,
+			"backends": refs,
+		},
+	}}
+}
+
+// httpRoute routes the port of apex service, backendRefs have to name the port as routes are attached to services.
+func httpRoute(globalSvc *corev1.Service, namespace, apex string, port int32, backends []backend) *unstructured.Unstructured {
+	refs := make([]interface{}, 0, len(backends))
+	for _, b := range backends {
+		refs = append(refs, map[string]interface{}{"name": b.service, "port": int64(port), "weight": int64(b.weight)})
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": httpRouteResource.GroupVersion().String(),
+		"kind":       "HTTPRoute",
+		"metadata":   trafficSplitMeta(globalSvc, namespace, fmt.Sprintf("%v-%v", apex, port)),
+		"spec": map[string]interface{}{
+			"parentRefs": []interface{}{
+				map[string]interface{}{"group": "core", "kind": "Service", "name": apex, "port": int64(port)},
+			},
+			"rules": []interface{}{
+				map[string]interface{}{"backendRefs": refs},
+			},
+		},
+	}}
+}
+
+func trafficSplitMeta(globalSvc *corev1.Service, namespace, name string) map[string]interface{} {
+	objLabels := make(map[string]interface{})
+	for k, v := range trafficSplitLabels(globalSvc) {
+		objLabels[k] = v
+	}
+	return map[string]interface{}{
+		"name":      name,
+		"namespace": namespace,
+		"labels":    objLabels,
+	}
+}
+
+// trafficSplitLabels tell which global service apex service and traffic split are next to. Global services
+// of different namespaces can have the same name.
+func trafficSplitLabels(globalSvc *corev1.Service) map[string]string {
+	return map[string]string{trafficSplitLabel: globalSvc.Name, trafficSplitNamespaceLabel: globalSvc.Namespace}
+}
+
+// splitObjectSelector selects apex services and traffic split resources of every global service.
+func splitObjectSelector() labels.Selector {
+	return labels.NewSelector().Add(requirement(trafficSplitLabel, selection.Exists))
+}
+
+// splitResource is the resource splitting traffic of apex services.
+func (w *Watcher) splitResource() schema.GroupVersionResource {
+	if w.trafficSplit == TrafficSplitHTTPRoute {
+		return httpRouteResource
+	}
+	return trafficSplitResource
+}
+
+// addSplitInformers adds informers of apex services and traffic split resources, in the namespaces mirrored
+// services are watched in, as that's where they are written.
+func (w *Watcher) addSplitInformers(client kubernetes.Interface, dynamicClient dynamic.Interface, watchNamespaces []string) {
+	w.splitFactories = make(map[string]informers.SharedInformerFactory)
+	w.splitDynamicFactories = make(map[string]dynamicinformer.DynamicSharedInformerFactory)
+	for _, namespace := range watchedNamespaces(watchNamespaces) {
+		factory := informers.NewSharedInformerFactoryWithOptions(client, informerResync,
+			informers.WithNamespace(namespace), withSelector(splitObjectSelector()))
+		dynamicFactory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynamicClient, informerResync, namespace, func(opts *metav1.ListOptions) {
+			opts.LabelSelector = splitObjectSelector().String()
+		})
+		w.splitFactories[namespace] = factory
+		w.splitDynamicFactories[namespace] = dynamicFactory
+		w.apexLister = append(w.apexLister, factory.Core().V1().Services().Lister())
+		w.splitListers = append(w.splitListers, dynamicFactory.ForResource(w.splitResource()).Lister())
+	}
+}
+
+// cachedSplitObjects returns traffic split resources of global service in the namespace, in all of them when empty.
+func (w *Watcher) cachedSplitObjects(namespace string, globalSvc *corev1.Service) ([]*unstructured.Unstructured, error) {
+	selector := labels.SelectorFromSet(trafficSplitLabels(globalSvc))
+	objects := make([]*unstructured.Unstructured, 0)
+	for _, lister := range w.splitListers {
+		var found []runtime.Object
+		var err error
+		if namespace == metav1.NamespaceAll {
+			found, err = lister.List(selector)
+		} else {
+			found, err = lister.ByNamespace(namespace).List(selector)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to list %v of %v from cache: %w", w.splitResource().Resource, globalSvc.Name, err)
+		}
+		for _, obj := range found {
+			if u, ok := obj.(*unstructured.Unstructured); ok {
+				objects = append(objects, u)
+			}
+		}
+	}
+	return objects, nil
+}
+
+func (w *Watcher) deleteTrafficSplitObject(resource schema.GroupVersionResource, namespace, name string) error {
+	if w.dryRun {
+		w.log.Infof("[dry-run] Would delete %v %v/%v", resource.Resource, namespace, name)
+		return nil
+	}
+	err := w.dynamicClient.Resource(resource).Namespace(namespace).Delete(w.workCtx, name, metav1.DeleteOptions{})
+	if err != nil && !apiError.IsNotFound(err) {
+		countAPIError("delete", resource.Resource)
+		return fmt.Errorf("unable to delete %v %v/%v: %w", resource.Resource, namespace, name, err)
+	}
+	return nil
+}
+
+// deleteTrafficSplit is cleanup hook removing apex service and traffic split of global service. They can be in
+// other namespace than global service, so they can't be owned by it.
+func (w *Watcher) deleteTrafficSplit(globalSvc *corev1.Service) error {
+	var errs []error
+	resource := w.splitResource()
+	existing, err := w.cachedSplitObjects(metav1.NamespaceAll, globalSvc)
+	if err != nil {
+		return err
+	}
+	for _, obj := range existing {
+		errs = append(errs, w.deleteTrafficSplitObject(resource, obj.GetNamespace(), obj.GetName()))
+	}
+
+	apexes, err := w.apexLister.List(labels.SelectorFromSet(trafficSplitLabels(globalSvc)))
+	if err != nil {
+		return fmt.Errorf("unable to list apex services of %v from cache: %w", globalSvc.Name, err)
+	}
+	for _, apex := range apexes {
+		if w.dryRun {
+			w.log.Infof("[dry-run] Would delete apex service %v/%v", apex.Namespace, apex.Name)
+			continue
+		}
+		err := w.clientset.CoreV1().Services(apex.Namespace).Delete(w.workCtx, apex.Name, metav1.DeleteOptions{})
+		if err != nil && !apiError.IsNotFound(err) {
+			countAPIError("delete", "services")
+			errs = append(errs, fmt.Errorf("unable to delete apex service %v/%v: %w", apex.Namespace, apex.Name, err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
@@ -0,0 +1,237 @@
+package watcher
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apiError "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseTrafficSplitKind(t *testing.T) {
+	tests := []struct {
+		kind    string
+		want    TrafficSplitKind
+		wantErr bool
+	}{
+		{kind: "", want: TrafficSplitNone},
+		{kind: "none", want: TrafficSplitNone},
+		{kind: "smi", want: TrafficSplitSMI},
+		{kind: "httproute", want: TrafficSplitHTTPRoute},
+		{kind: "grpcroute", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			got, err := ParseTrafficSplitKind(tt.kind)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ParseTrafficSplitKind(%q) = %q, %v, want %q, wantErr %v", tt.kind, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+// withWeight sets weight annotation on mirrored service.
+func withWeight(svc *corev1.Service, weight string) *corev1.Service {
+	svc.Annotations = map[string]string{weightAnnotation: weight}
+	return svc
+}
+
+func TestTrafficSplitBackends(t *testing.T) {
+	tests := []struct {
+		name      string
+		svcs      []*corev1.Service
+		ready     map[string]int
+		withdrawn map[string]bool
+		want      []backend
+	}{
+		{
+			name:  "ready endpoints",
+			svcs:  []*corev1.Service{mirroredService("x", "target2"), mirroredService("x", "target1")},
+			ready: map[string]int{"target1": 3, "target2": 1},
+			want:  []backend{{service: "x-target1", weight: 3}, {service: "x-target2", weight: 1}},
+		},
+		{
+			name:  "annotation wins",
+			svcs:  []*corev1.Service{withWeight(mirroredService("x", "target1"), "10"), mirroredService("x", "target2")},
+			ready: map[string]int{"target1": 3, "target2": 1},
+			want:  []backend{{service: "x-target1", weight: 10}, {service: "x-target2", weight: 1}},
+		},
+		{
+			name:  "invalid annotation",
+			svcs:  []*corev1.Service{withWeight(mirroredService("x", "target1"), "-1")},
+			ready: map[string]int{"target1": 3},
+			want:  []backend{{service: "x-target1", weight: 3}},
+		},
+		{
+			name:      "withdrawn cluster",
+			svcs:      []*corev1.Service{withWeight(mirroredService("x", "target1"), "10"), mirroredService("x", "target2")},
+			ready:     map[string]int{"target1": 3, "target2": 1},
+			withdrawn: map[string]bool{"target1": true},
+			want:      []backend{{service: "x-target1", weight: 0}, {service: "x-target2", weight: 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWatcher(t, Options{})
+			agg := &aggregation{name: "x-global", endpoints: make(map[string]int)}
+			if got := w.trafficSplitBackends(agg, tt.svcs, tt.ready, tt.withdrawn); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("trafficSplitBackends() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileTrafficSplit(t *testing.T) {
+	tests := []struct {
+		kind TrafficSplitKind
+		// Traffic split resources, with the spec they should have.
+		want map[string]interface{}
+	}{
+		{kind: TrafficSplitSMI, want: map[string]interface{}{
+			"x-global-split": map[string]interface{}{
+				"service": "x-global-split",
+				"backends": []interface{}{
+					map[string]interface{}{"service": "x-target1", "weight": int64(2)},
+					map[string]interface{}{"service": "x-target2", "weight": int64(5)},
+				},
+			},
+		}},
+		{kind: TrafficSplitHTTPRoute, want: map[string]interface{}{
+			"x-global-split-80": map[string]interface{}{
+				"parentRefs": []interface{}{
+					map[string]interface{}{"group": "core", "kind": "Service", "name": "x-global-split", "port": int64(80)},
+				},
+				"rules": []interface{}{
+					map[string]interface{}{"backendRefs": []interface{}{
+						map[string]interface{}{"name": "x-target1", "port": int64(80), "weight": int64(2)},
+						map[string]interface{}{"name": "x-target2", "port": int64(80), "weight": int64(5)},
+					}},
+				},
+			},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.kind), func(t *testing.T) {
+			w := newTestWatcher(t, Options{AutoAggregate: true, TrafficSplit: tt.kind},
+				mirroredService("x", "target1", servicePort("http", 80, 8080)),
+				withWeight(mirroredService("x", "target2", servicePort("http", 80, 8080)), "5"),
+				mirroredEndpointSlice("x", "target1", "x-0", "x-1"),
+				mirroredEndpointSlice("x", "target2", "x-0"))
+			for i := 0; i < 2; i++ {
+				if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+					t.Fatalf("reconcileGlobalService() error = %v", err)
+				}
+				syncCache(t, w)
+			}
+
+			ctx := context.Background()
+			apex, err := w.clientset.CoreV1().Services(testNamespace).Get(ctx, "x-global-split", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("getting apex service: %v", err)
+			}
+			if apex.Spec.Type != corev1.ServiceTypeClusterIP || len(apex.Spec.Ports) != 1 || apex.Spec.Ports[0].Port != 80 {
+				t.Errorf("apex service spec = %+v, want ClusterIP with port 80", apex.Spec)
+			}
+
+			resource := trafficSplitResource
+			if tt.kind == TrafficSplitHTTPRoute {
+				resource = httpRouteResource
+			}
+			list, err := w.dynamicClient.Resource(resource).Namespace(testNamespace).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				t.Fatalf("listing %v: %v", resource.Resource, err)
+			}
+			got := make(map[string]interface{})
+			for _, obj := range list.Items {
+				spec, _, _ := unstructured.NestedFieldNoCopy(obj.Object, "spec")
+				got[obj.GetName()] = spec
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%v = %v, want %v", resource.Resource, got, tt.want)
+			}
+
+			// Nothing changed, so neither apex service nor traffic split is written or listed again.
+			client := w.clientset.(*fake.Clientset)
+			dynamicClient := w.dynamicClient.(*dynamicfake.FakeDynamicClient)
+			client.ClearActions()
+			dynamicClient.ClearActions()
+			if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+				t.Fatalf("reconcileGlobalService() error = %v", err)
+			}
+			for _, action := range client.Actions() {
+				if action.GetResource().Resource == "services" && action.GetNamespace() == testNamespace {
+					t.Errorf("apex service accessed again: %v", action)
+				}
+			}
+			for _, action := range dynamicClient.Actions() {
+				t.Errorf("%v accessed again: %v", resource.Resource, action)
+			}
+
+			// Cleaned up along with global service.
+			globalSvc, err := w.svcLister.Services(testGlobalNamespace).Get("x-global")
+			if err != nil {
+				t.Fatalf("getting global service: %v", err)
+			}
+			if err := w.deleteTrafficSplit(globalSvc); err != nil {
+				t.Fatalf("deleteTrafficSplit() error = %v", err)
+			}
+			if _, err := w.clientset.CoreV1().Services(testNamespace).Get(ctx, "x-global-split", metav1.GetOptions{}); !apiError.IsNotFound(err) {
+				t.Errorf("apex service not deleted: %v", err)
+			}
+			list, err = w.dynamicClient.Resource(resource).Namespace(testNamespace).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				t.Fatalf("listing %v: %v", resource.Resource, err)
+			}
+			if len(list.Items) != 0 {
+				t.Errorf("%v left after cleanup: %v", resource.Resource, len(list.Items))
+			}
+		})
+	}
+}
+
+func TestReconcileTrafficSplitClusterIPMirror(t *testing.T) {
+	// Mirror of ClusterIP service only has the gateway IP, without hostname.
+	clusterIPMirror := mirroredService("x", "target2", servicePort("http", 80, 8080))
+	clusterIPMirror.Spec.ClusterIP = "10.96.0.10"
+	w := newTestWatcher(t, Options{AutoAggregate: true, TrafficSplit: TrafficSplitSMI, GatewayIPRetryPeriod: time.Minute},
+		mirroredService("x", "target1", servicePort("http", 80, 8080)),
+		clusterIPMirror,
+		mirroredEndpointSlice("x", "target1", "x-0", "x-1"),
+		mirroredEndpointSlice("x", "target2", ""))
+	requeues := recordRequeues(w)
+	for i := 0; i < 2; i++ {
+		if err := w.reconcileGlobalService(globalKey(testGlobalNamespace, "x-global")); err != nil {
+			t.Fatalf("reconcileGlobalService() error = %v", err)
+		}
+		syncCache(t, w)
+	}
+
+	split, err := w.dynamicClient.Resource(trafficSplitResource).Namespace(testNamespace).Get(context.Background(), "x-global-split", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting traffic split: %v", err)
+	}
+	backends, _, _ := unstructured.NestedSlice(split.Object, "spec", "backends")
+	want := []interface{}{
+		map[string]interface{}{"service": "x-target1", "weight": int64(2)},
+		map[string]interface{}{"service": "x-target2", "weight": int64(1)},
+	}
+	if !reflect.DeepEqual(backends, want) {
+		t.Errorf("backends = %v, want %v", backends, want)
+	}
+
+	if slices := globalSlicesOf(t, w, "x-target2"); len(slices) != 0 {
+		t.Errorf("global endpointslices of ClusterIP mirror = %v, want none", len(slices))
+	}
+	if hasEvent(w, reasonEndpointSliceSkipped) {
+		t.Errorf("%v event recorded, want ClusterIP mirror left to traffic split", reasonEndpointSliceSkipped)
+	}
+	if requeued := requeues.requeued(); len(requeued) != 0 {
+		t.Errorf("requeued %v, want none", requeued)
+	}
+}
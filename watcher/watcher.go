@@ -2,52 +2,340 @@ package watcher
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	mirrorv1alpha1 "github.com/rushi47/service-mirror-prototype/apis/mirror/v1alpha1"
+	"github.com/rushi47/service-mirror-prototype/generated/clientset/versioned"
+	mirrorinformers "github.com/rushi47/service-mirror-prototype/generated/informers/externalversions"
+	mirrorlisters "github.com/rushi47/service-mirror-prototype/generated/listers/mirror/v1alpha1"
 	"github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
 	discoveryv1 "k8s.io/api/discovery/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/utils/clock"
 )
 
+// Labels set by the Linkerd service mirror on mirrored objects, and by us on global objects.
+const (
+	mirroredServiceLabel     = "mirror.linkerd.io/mirrored-service"
+	headlessMirrorLabel      = "mirror.linkerd.io/headless-mirror-svc-name"
+	clusterNameLabel         = "mirror.linkerd.io/cluster-name"
+	targetMirrorSvcNameLabel = "mirror.linkerd.io/target-mirror-svc-name"
+	// Mirrored endpointslice, endpoints of global endpointslice come from, and its namespace.
+	sourceEndpointSliceLabel          = "mirror.linkerd.io/source-endpointslice"
+	sourceEndpointSliceNamespaceLabel = "mirror.linkerd.io/source-endpointslice-namespace"
+	globalMirrorLabel                 = "mirror.linkerd.io/global-mirror"
+	serviceNameLabel                  = discoveryv1.LabelServiceName
+)
+
+// How often informers pass everything in their caches to the handlers again.
+const informerResync = 3 * time.Second
+
+// Options configures the Watcher.
+type Options struct {
+	// Namespace in which all the global services and endpointslices are created, in single NamespaceMode.
+	// GlobalServices are watched in it too.
+	Namespace string
+	// Workers is the number of goroutines reconciling global services in parallel.
+	Workers int
+	// ResyncPeriod is how often all global objects are compared against mirrored ones, to clean up orphans.
+	ResyncPeriod time.Duration
+	// DryRun only reports global objects which would be deleted, without deleting them.
+	DryRun bool
+	// AutoAggregate aggregates every mirrored service which isn't declared by a GlobalService,
+	// into <service>-global, or what Naming generates.
+	AutoAggregate bool
+	// StallTimeout is how long a worker can be stuck on a key, or informers can keep on failing to list and watch,
+	// before liveness probe fails.
+	StallTimeout time.Duration
+	// Clock is what StallTimeout and failback delay are measured by, the real clock when nil.
+	Clock clock.PassiveClock
+	// ShutdownGracePeriod is how long in-flight reconciles get to finish once workers are stopped,
+	// before their API calls are cancelled.
+	ShutdownGracePeriod time.Duration
+	// MaxEndpointsPerSlice is the most endpoints a global endpointslice holds, mirrored endpointslices with
+	// more endpoints are spread over several of them.
+	MaxEndpointsPerSlice int
+	// ForceConflicts takes over fields we set on global objects, when they are owned by another field manager.
+	// Without it such writes fail.
+	ForceConflicts bool
+	// Naming tells which service mirrored services come from and names global services, DefaultNaming when nil.
+	Naming NamingStrategy
+	// Hostnames decides hostnames of endpoints in global endpointslices, ClusterHostnames adding cluster as suffix when nil.
+	Hostnames HostnamePolicy
+	// GatewayIPPolicy is what happens to mirrored endpointslices with endpoints without hostname, keep-last-known when empty.
+	GatewayIPPolicy GatewayIPPolicy
+	// GatewayIPRetryPeriod is how soon global service is reconciled again, after it had endpoints without hostname.
+	GatewayIPRetryPeriod time.Duration
+	// ReadinessPolicy is what happens to endpoints which aren't ready, publish-all when empty.
+	ReadinessPolicy ReadinessPolicy
+	// MinReadyEndpoints is how many ready endpoints a cluster needs, for its endpoints to be part of global service.
+	MinReadyEndpoints int
+	// ClusterMinReadyEndpoints overrides MinReadyEndpoints for some clusters.
+	ClusterMinReadyEndpoints map[string]int
+	// FailbackDelay is how long higher priority cluster of global service in failover mode has to have ready
+	// endpoints, before it switches back to it. GlobalService or annotation of global service can override it.
+	FailbackDelay time.Duration
+	// TrafficSplit creates ClusterIP apex service next to every global service, with traffic split across
+	// mirrored services by this kind of resource. None when empty.
+	TrafficSplit TrafficSplitKind
+	// NamespaceMode tells whether global services are created in Namespace, or next to their mirrored services.
+	// Single when empty.
+	NamespaceMode NamespaceMode
+	// NamespaceMapping maps namespace of mirrored services to namespace of their global services, in preserve mode.
+	NamespaceMapping map[string]string
+	// WatchNamespaces limits mirrored services and endpointslices which are watched to these namespaces,
+	// all namespaces when empty.
+	WatchNamespaces []string
+	// NamespaceSelector limits mirrored services which are aggregated to namespaces with matching labels.
+	NamespaceSelector labels.Selector
+	// ServiceSelector limits mirrored services which are aggregated to the ones with matching labels. Mirrored
+	// services labelled mirror.linkerd.io/global=false never are.
+	ServiceSelector labels.Selector
+	// ServiceType of global services, unless GlobalService or annotation of mirrored services says otherwise.
+	// Headless when empty.
+	ServiceType mirrorv1alpha1.ServiceType
+	// CleanupHooks run before a global service goes away, after its global endpointslices are deleted.
+	CleanupHooks []CleanupHook
+}
+
 type Watcher struct {
+	// Informers for global objects we created, only watches the global namespace unless namespaces are preserved.
 	InformersFactory informers.SharedInformerFactory
-	log              *logrus.Logger
-	clientset        kubernetes.Clientset
-	namespace        string
-	Context          context.Context
+	// Informers for mirrored services and endpointslices, by watched namespace, empty one watching all namespaces.
+	MirroredFactories map[string]informers.SharedInformerFactory
+	// Informers for GlobalServices, only watches the global namespace unless namespaces are preserved.
+	MirrorInformersFactory mirrorinformers.SharedInformerFactory
+	// Informer for namespaces NamespaceSelector matches, nil without it.
+	namespaceFactory informers.SharedInformerFactory
+	// Informers for apex services and traffic split resources, by watched namespace, nil without traffic split.
+	splitFactories        map[string]informers.SharedInformerFactory
+	splitDynamicFactories map[string]dynamicinformer.DynamicSharedInformerFactory
+	apexLister            serviceListers
+	splitListers          []cache.GenericLister
+	// What the informers select on the apiserver, nil when everything.
+	serviceSelector   labels.Selector
+	namespaceSelector labels.Selector
+	log               *logrus.Logger
+	clientset         kubernetes.Interface
+	mirrorClient      versioned.Interface
+	// Writes traffic split resources, which we don't have clientset for.
+	dynamicClient dynamic.Interface
+	namespace     string
+	// Namespaces of global services, see NamespaceMode.
+	namespaceMode    NamespaceMode
+	namespaceMapping map[string]string
+	workers          int
+	resyncPeriod     time.Duration
+	dryRun           bool
+	autoAggregate    bool
+	// Queue of global service keys, namespace/name, waiting to be reconciled.
+	queue     workqueue.RateLimitingInterface
+	svcLister corelisters.ServiceLister
+	epsLister discoverylisters.EndpointSliceLister
+	gsLister  mirrorlisters.GlobalServiceLister
+	// Namespaces mirrored services are aggregated from, nil when they all are.
+	nsLister corelisters.NamespaceLister
+	// Events on global services and GlobalServices, so problems are visible from the cluster.
+	broadcaster record.EventBroadcaster
+	recorder    record.EventRecorder
+	registry    *prometheus.Registry
+	// What the liveness and readiness probes report.
+	health       *health
+	stallTimeout time.Duration
+	// What stall timeout and failback delay are measured by.
+	clock clock.PassiveClock
+	// Root context, cancelling it stops the informers.
+	Context context.Context
+	// Context of API calls made by workers. It outlives Context by the shutdown grace period,
+	// so in-flight reconciles aren't cut off half way on shutdown.
+	workCtx             context.Context
+	cancelWork          context.CancelFunc
+	shutdownGracePeriod time.Duration
+	cleanupHooks        []CleanupHook
+	// Most endpoints a global endpointslice holds.
+	maxEndpointsPerSlice int
+	forceConflicts       bool
+	naming               NamingStrategy
+	hostnames            HostnamePolicy
+	gatewayIPPolicy      GatewayIPPolicy
+	gatewayIPRetryPeriod time.Duration
+	readinessPolicy      ReadinessPolicy
+	// Clusters with less ready endpoints are withdrawn from global services.
+	defaultMinReadyEndpoints int
+	clusterMinReadyEndpoints map[string]int
+	failbackDelay            time.Duration
+	// Active clusters of global services in failover mode, by global service name.
+	failoverMu sync.Mutex
+	failovers  map[string]*failoverState
+	// Clusters withdrawn from global services, by global service name, and whether their event was recorded.
+	withdrawalsMu sync.Mutex
+	withdrawals   map[string]map[string]bool
+	trafficSplit  TrafficSplitKind
+	serviceType   mirrorv1alpha1.ServiceType
 }
 
-func NewWatch(ctx context.Context, client kubernetes.Clientset, log *logrus.Logger, namespace string) Watcher {
+func NewWatch(ctx context.Context, client kubernetes.Interface, mirrorClient versioned.Interface, dynamicClient dynamic.Interface, log *logrus.Logger, opts Options) *Watcher {
+	// GlobalServices and global objects are next to the global services they declare.
+	globalNamespace := opts.Namespace
+	if opts.NamespaceMode == NamespacePreserve {
+		globalNamespace = metav1.NamespaceAll
+	}
+	factory := informers.NewSharedInformerFactoryWithOptions(client, informerResync,
+		informers.WithNamespace(globalNamespace), withSelector(globalObjectSelector()))
+	mirroredFactories := make(map[string]informers.SharedInformerFactory)
+	svcListers := serviceListers{factory.Core().V1().Services().Lister()}
+	epsListers := endpointSliceListers{factory.Discovery().V1().EndpointSlices().Lister()}
+	for _, namespace := range watchedNamespaces(opts.WatchNamespaces) {
+		mirroredFactory := newMirroredFactory(client, namespace, mirroredServiceSelector(opts.ServiceSelector))
+		mirroredFactories[namespace] = mirroredFactory
+		svcListers = append(svcListers, mirroredFactory.Core().V1().Services().Lister())
+		epsListers = append(epsListers, mirroredFactory.Discovery().V1().EndpointSlices().Lister())
+	}
+	var namespaceFactory informers.SharedInformerFactory
+	var nsLister corelisters.NamespaceLister
+	if opts.NamespaceSelector != nil {
+		namespaceFactory = informers.NewSharedInformerFactoryWithOptions(client, informerResync, withSelector(opts.NamespaceSelector))
+		nsLister = namespaceFactory.Core().V1().Namespaces().Lister()
+	}
+	mirrorFactory := mirrorinformers.NewSharedInformerFactoryWithOptions(mirrorClient, informerResync, mirrorinformers.WithNamespace(globalNamespace))
+	broadcaster := record.NewBroadcaster()
+	workCtx, cancelWork := context.WithCancel(context.Background())
 	watch := &Watcher{
-		Context:          ctx,
-		InformersFactory: informers.NewSharedInformerFactory(&client, time.Second*3),
-		log:              log,
-		clientset:        client,
-		namespace:        namespace,
+		Context:                  ctx,
+		workCtx:                  workCtx,
+		cancelWork:               cancelWork,
+		shutdownGracePeriod:      opts.ShutdownGracePeriod,
+		maxEndpointsPerSlice:     opts.MaxEndpointsPerSlice,
+		forceConflicts:           opts.ForceConflicts,
+		naming:                   opts.Naming,
+		hostnames:                opts.Hostnames,
+		gatewayIPPolicy:          opts.GatewayIPPolicy,
+		gatewayIPRetryPeriod:     opts.GatewayIPRetryPeriod,
+		readinessPolicy:          opts.ReadinessPolicy,
+		defaultMinReadyEndpoints: opts.MinReadyEndpoints,
+		clusterMinReadyEndpoints: opts.ClusterMinReadyEndpoints,
+		failbackDelay:            opts.FailbackDelay,
+		failovers:                make(map[string]*failoverState),
+		withdrawals:              make(map[string]map[string]bool),
+		trafficSplit:             opts.TrafficSplit,
+		serviceType:              opts.ServiceType,
+		InformersFactory:         factory,
+		MirroredFactories:        mirroredFactories,
+		MirrorInformersFactory:   mirrorFactory,
+		namespaceFactory:         namespaceFactory,
+		serviceSelector:          opts.ServiceSelector,
+		namespaceSelector:        opts.NamespaceSelector,
+		log:                      log,
+		clientset:                client,
+		mirrorClient:             mirrorClient,
+		dynamicClient:            dynamicClient,
+		namespace:                opts.Namespace,
+		namespaceMode:            opts.NamespaceMode,
+		namespaceMapping:         opts.NamespaceMapping,
+		workers:                  opts.Workers,
+		resyncPeriod:             opts.ResyncPeriod,
+		dryRun:                   opts.DryRun,
+		autoAggregate:            opts.AutoAggregate,
+		queue:                    workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "global-mirror"),
+		svcLister:                svcListers,
+		epsLister:                epsListers,
+		gsLister:                 mirrorFactory.Mirror().V1alpha1().GlobalServices().Lister(),
+		nsLister:                 nsLister,
+		broadcaster:              broadcaster,
+		recorder:                 newEventRecorder(broadcaster),
+		health:                   &health{failing: make(map[cache.SharedIndexInformer]informerFailure), inFlight: make(map[string]time.Time)},
+		stallTimeout:             opts.StallTimeout,
+		clock:                    opts.Clock,
+	}
+	if watch.workers < 1 {
+		watch.workers = 1
+	}
+	if watch.resyncPeriod <= 0 {
+		watch.resyncPeriod = 5 * time.Minute
+	}
+	if watch.stallTimeout <= 0 {
+		watch.stallTimeout = 2 * time.Minute
+	}
+	if watch.clock == nil {
+		watch.clock = clock.RealClock{}
+	}
+	watch.health.clock = watch.clock
+	if watch.shutdownGracePeriod <= 0 {
+		watch.shutdownGracePeriod = 30 * time.Second
+	}
+	if watch.maxEndpointsPerSlice <= 0 {
+		watch.maxEndpointsPerSlice = defaultMaxEndpointsPerSlice
+	}
+	if watch.maxEndpointsPerSlice > maxEndpointsPerSliceLimit {
+		log.Warnf("EndpointSlices can't hold more than %v endpoints, using that instead of %v", maxEndpointsPerSliceLimit, watch.maxEndpointsPerSlice)
+		watch.maxEndpointsPerSlice = maxEndpointsPerSliceLimit
+	}
+	if watch.naming == nil {
+		watch.naming = DefaultNaming{}
+	}
+	if watch.gatewayIPPolicy == "" {
+		watch.gatewayIPPolicy = GatewayIPKeepLastKnown
+	}
+	if watch.gatewayIPRetryPeriod <= 0 {
+		watch.gatewayIPRetryPeriod = 10 * time.Second
+	}
+	if watch.failbackDelay <= 0 {
+		watch.failbackDelay = 30 * time.Second
+	}
+	if watch.readinessPolicy == "" {
+		watch.readinessPolicy = ReadinessPublishAll
+	}
+	if watch.hostnames == nil {
+		watch.hostnames = ClusterHostnames{Mode: HostnameSuffix}
+	}
+	if watch.namespaceMode == "" {
+		watch.namespaceMode = NamespaceSingle
+	}
+	if watch.serviceType == "" {
+		watch.serviceType = mirrorv1alpha1.ServiceTypeHeadless
+	}
+	if watch.trafficSplit == "" {
+		watch.trafficSplit = TrafficSplitNone
 	}
-	return *watch
+	watch.cleanupHooks = []CleanupHook{watch.recordDeletion}
+	if watch.trafficSplit != TrafficSplitNone {
+		watch.addSplitInformers(client, dynamicClient, opts.WatchNamespaces)
+		watch.cleanupHooks = append(watch.cleanupHooks, watch.deleteTrafficSplit)
+	}
+	watch.cleanupHooks = append(watch.cleanupHooks, opts.CleanupHooks...)
+	watch.addMirroredIndexers()
+	watch.registry = newRegistry(watch)
+	return watch
 }
 
+// Informer callbacks only work out which global service is affected and enqueue its name,
+// all the API calls happen in reconcileGlobalService from the workers.
 func (w *Watcher) RegisterHandlers() {
 
-	//Create informer for service
-	svcInformer := w.InformersFactory.Core().V1().Services().Informer()
-	svcInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+	svcHandler := cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
 			service, ok := obj.(*corev1.Service)
 			if !ok {
 				w.log.Errorf("Failed to cast Service in Add")
 				return
 			}
-			//If obj doesnt match the filter return
-			if !w.Filter(service.ObjectMeta) {
-				return
-			}
-			w.handleServiceAdd(*service.DeepCopy())
+			w.enqueueService(service)
 		},
 		UpdateFunc: func(oldobj, obj interface{}) {
 			newSvc, ok := obj.(*corev1.Service)
@@ -60,44 +348,33 @@ func (w *Watcher) RegisterHandlers() {
 				w.log.Errorf("Failed to cast Service in Update")
 				return
 			}
-			// If obj doesnt match the filter return or it doesnt match resource version return
+			// If it doesnt match the resource version return
 			// https://github.com/kubernetes/client-go/issues/529
-			if !w.Filter(newSvc.ObjectMeta) {
-				return
-			}
 			if newSvc.ResourceVersion == oldSvc.ResourceVersion {
 				return
 			}
-
-			w.handleServiceUpdate(*oldSvc.DeepCopy(), *newSvc.DeepCopy())
+			w.enqueueService(newSvc)
 		},
 		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
 			svc, ok := obj.(*corev1.Service)
 			if !ok {
 				w.log.Errorf("Failed to cast Service in Delete")
 				return
 			}
-			// If obj doesnt match the filter return
-			if !w.Filter(svc.ObjectMeta) {
-				return
-			}
-			w.handleServiceDelete(*svc.DeepCopy())
+			w.enqueueService(svc)
 		},
-	})
-	//Create Informer for endpointslice
-	epsInformer := w.InformersFactory.Discovery().V1().EndpointSlices().Informer()
-	epsInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+	}
+	epsHandler := cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
 			eps, ok := obj.(*discoveryv1.EndpointSlice)
 			if !ok {
 				w.log.Errorf("Failed to cast Endpointslice")
 				return
 			}
-			// If obj doesnt match the filter return
-			if !w.Filter(eps.ObjectMeta) {
-				return
-			}
-			w.handleEpsAdd(*eps.DeepCopy())
+			w.enqueueEndpointSlice(eps)
 		},
 		UpdateFunc: func(oldobj, obj interface{}) {
 			newEps, ok := obj.(*discoveryv1.EndpointSlice)
@@ -110,52 +387,237 @@ func (w *Watcher) RegisterHandlers() {
 				w.log.Errorf("Failed to cast Endpointslice")
 				return
 			}
-			// If obj doesnt match the filter return or it doesnt match resource version return
+			// If it doesnt match the resource version return
 			// https://github.com/kubernetes/client-go/issues/529
-			if !w.Filter(newEps.ObjectMeta) {
-				return
-			}
-
 			if newEps.ResourceVersion == oldEps.ResourceVersion {
 				return
 			}
-
-			w.handleEpsUpdate(*oldEps.DeepCopy(), *newEps.DeepCopy())
+			w.enqueueEndpointSlice(newEps)
 		},
 		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
 			eps, ok := obj.(*discoveryv1.EndpointSlice)
 			if !ok {
 				w.log.Errorf("Failed to cast Endpointslice")
 				return
 			}
-			// If obj doesnt match the filter return
-			if !w.Filter(eps.ObjectMeta) {
-				return
-			}
-			w.handleEpsDelete(*eps.DeepCopy())
+			w.enqueueEndpointSlice(eps)
 		},
-	})
+	}
+
+	for _, factory := range w.objectFactories() {
+		factory.Core().V1().Services().Informer().AddEventHandler(svcHandler)
+		factory.Discovery().V1().EndpointSlices().Informer().AddEventHandler(epsHandler)
+	}
+
+	w.registerNamespaceHandlers()
+	w.registerGlobalServiceHandlers()
 }
 
+// Filter reports if the object is mirrored service or endpointslice we aggregate.
 func (w *Watcher) Filter(obj metav1.ObjectMeta) bool {
+	return isMirroredObject(obj) && w.inScope(obj)
+}
+
+// isMirroredObject reports if the object is mirrored service or endpointslice of Linkerd, in or out of scope.
+func isMirroredObject(obj metav1.ObjectMeta) bool {
 	labels := obj.GetLabels()
 
 	// Service should have label: mirrored-service
-	if _, ok := labels["mirror.linkerd.io/mirrored-service"]; !ok {
+	if _, ok := labels[mirroredServiceLabel]; !ok {
 		return false
 	}
 
 	// Service should not have label, as it means its not parent target service
-	if _, ok := labels["mirror.linkerd.io/headless-mirror-svc-name"]; ok {
+	if _, ok := labels[headlessMirrorLabel]; ok {
 		return false
 	}
 
 	return true
 }
 
-func (w *Watcher) Run(stopCh chan struct{}) {
+// isGlobalObject reports if the object is one of the global objects managed by us.
+func (w *Watcher) isGlobalObject(obj metav1.ObjectMeta) bool {
+	return w.managesNamespace(obj.Namespace) && obj.GetLabels()[globalMirrorLabel] == "true"
+}
+
+// enqueueService queues the global services which are affected by change in this service.
+func (w *Watcher) enqueueService(svc *corev1.Service) {
+	switch {
+	// Mirrored service going out of scope still has to be cleaned up after.
+	case isMirroredObject(svc.ObjectMeta):
+		for _, key := range w.globalServiceKeys(svc.Namespace, svc.Name, svc.GetLabels()[clusterNameLabel]) {
+			w.queue.Add(key)
+		}
+	case w.isGlobalObject(svc.ObjectMeta):
+		// Someone else touched global service, make sure it still looks like what we want.
+		w.queue.Add(globalKey(svc.Namespace, svc.Name))
+	}
+}
+
+// enqueueEndpointSlice queues the global services which are affected by change in this endpointslice.
+func (w *Watcher) enqueueEndpointSlice(eps *discoveryv1.EndpointSlice) {
+	labels := eps.GetLabels()
+	switch {
+	case isMirroredObject(eps.ObjectMeta):
+		for _, key := range w.globalServiceKeys(eps.Namespace, labels[serviceNameLabel], labels[clusterNameLabel]) {
+			w.queue.Add(key)
+		}
+	case w.isGlobalObject(eps.ObjectMeta):
+		if svcName, ok := labels[serviceNameLabel]; ok {
+			w.queue.Add(globalKey(eps.Namespace, svcName))
+		}
+	}
+}
+
+// objectFactories returns factories of informers for services and endpointslices. Global objects and mirrored
+// objects of every watched namespace have informers of their own.
+func (w *Watcher) objectFactories() []informers.SharedInformerFactory {
+	factories := []informers.SharedInformerFactory{w.InformersFactory}
+	for _, factory := range w.MirroredFactories {
+		factories = append(factories, factory)
+	}
+	return factories
+}
+
+// informerFactories returns every factory of Kubernetes informers.
+func (w *Watcher) informerFactories() []informers.SharedInformerFactory {
+	factories := w.objectFactories()
+	if w.namespaceFactory != nil {
+		factories = append(factories, w.namespaceFactory)
+	}
+	for _, factory := range w.splitFactories {
+		factories = append(factories, factory)
+	}
+	return factories
+}
+
+// informers returns every informer we run.
+func (w *Watcher) informers() []cache.SharedIndexInformer {
+	all := []cache.SharedIndexInformer{w.MirrorInformersFactory.Mirror().V1alpha1().GlobalServices().Informer()}
+	for _, factory := range w.objectFactories() {
+		all = append(all, factory.Core().V1().Services().Informer(), factory.Discovery().V1().EndpointSlices().Informer())
+	}
+	if w.namespaceFactory != nil {
+		all = append(all, w.namespaceFactory.Core().V1().Namespaces().Informer())
+	}
+	for namespace, factory := range w.splitFactories {
+		all = append(all, factory.Core().V1().Services().Informer(), w.splitDynamicFactories[namespace].ForResource(w.splitResource()).Informer())
+	}
+	return all
+}
+
+// Run starts the informers, waits for their caches to sync and does the initial resync. Every replica
+// runs informers, so caches are already warm when a replica takes over the leadership.
+// Informers keep on running until Context is cancelled.
+func (w *Watcher) Run() error {
+	stopCh := w.Context.Done()
+	w.broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: w.clientset.CoreV1().Events("")})
+
+	for _, informer := range w.informers() {
+		if err := informer.SetWatchErrorHandler(w.watchErrorHandler(informer)); err != nil {
+			return fmt.Errorf("unable to set watch error handler: %w", err)
+		}
+	}
 	// Start all the shared Informers
-	w.InformersFactory.Start(stopCh)
+	for _, factory := range w.informerFactories() {
+		factory.Start(stopCh)
+	}
+	w.MirrorInformersFactory.Start(stopCh)
+	for _, factory := range w.splitDynamicFactories {
+		factory.Start(stopCh)
+	}
 	// Wait for the cache sync
-	w.InformersFactory.WaitForCacheSync(stopCh)
+	for _, factory := range w.informerFactories() {
+		for informerType, synced := range factory.WaitForCacheSync(stopCh) {
+			if !synced {
+				return fmt.Errorf("failed to sync cache for %v", informerType)
+			}
+		}
+	}
+	for informerType, synced := range w.MirrorInformersFactory.WaitForCacheSync(stopCh) {
+		if !synced {
+			return fmt.Errorf("failed to sync cache for %v", informerType)
+		}
+	}
+	for _, factory := range w.splitDynamicFactories {
+		for resource, synced := range factory.WaitForCacheSync(stopCh) {
+			if !synced {
+				return fmt.Errorf("failed to sync cache for %v", resource)
+			}
+		}
+	}
+
+	w.log.Info("Caches synced")
+
+	// Every replica does the initial resync, so whoever leads has the queue filled up.
+	w.resync()
+	w.health.ready.Store(true)
+	return nil
+}
+
+// Shutdown stops the informers and event recording, once workers are done. Context has to be cancelled
+// before calling it, as it waits for informer goroutines to exit.
+func (w *Watcher) Shutdown() {
+	w.health.ready.Store(false)
+	w.queue.ShutDown()
+	w.cancelWork()
+	for _, factory := range w.informerFactories() {
+		factory.Shutdown()
+	}
+	w.MirrorInformersFactory.Shutdown()
+	for _, factory := range w.splitDynamicFactories {
+		factory.Shutdown()
+	}
+	// Flushes events recorded by the last reconciles.
+	w.broadcaster.Shutdown()
+	w.log.Info("Informers stopped")
+}
+
+// RunWorkers runs the reconcile workers and the periodic resync, until stopCh is closed.
+// When running multiple replicas only the leader runs it. It returns once in-flight reconciles are done,
+// the queue is shut down by then, so it can only be run once.
+func (w *Watcher) RunWorkers(stopCh <-chan struct{}) {
+	if w.dryRun {
+		w.log.Warn("Running in dry-run mode, global objects will not be deleted")
+	}
+
+	w.log.Infof("Starting %v workers", w.workers)
+	var wg sync.WaitGroup
+	for i := 0; i < w.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait.Until(func() { w.runWorker(stopCh) }, time.Second, stopCh)
+		}()
+	}
+
+	// First resync happens right away, cleaning up whatever got orphaned while we were down.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		wait.Until(w.resync, w.resyncPeriod, stopCh)
+	}()
+
+	<-stopCh
+	w.log.Infof("Stopping workers, waiting up to %v for in-flight reconciles", w.shutdownGracePeriod)
+	// Wakes up the workers waiting for keys.
+	w.queue.ShutDown()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		w.log.Info("Workers stopped")
+	case <-time.After(w.shutdownGracePeriod):
+		w.log.Warnf("In-flight reconciles didn't finish within %v, cancelling them", w.shutdownGracePeriod)
+		w.cancelWork()
+		<-done
+		w.log.Info("Workers stopped")
+	}
 }
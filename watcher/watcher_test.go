@@ -0,0 +1,466 @@
+package watcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mirrorv1alpha1 "github.com/rushi47/service-mirror-prototype/apis/mirror/v1alpha1"
+	mirrorfake "github.com/rushi47/service-mirror-prototype/generated/clientset/versioned/fake"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apiError "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	testGlobalNamespace = "global"
+	testNamespace       = "test"
+)
+
+// newTestWatcher returns watcher backed by fake clientsets, with informer caches filled from objects.
+// Informers aren't started, syncCache has to be called to make changes made through clientsets visible.
+func newTestWatcher(t *testing.T, opts Options, objects ...runtime.Object) *Watcher {
+	t.Helper()
+
+	kubeObjects := make([]runtime.Object, 0)
+	mirrorObjects := make([]runtime.Object, 0)
+	for _, obj := range objects {
+		if _, ok := obj.(*mirrorv1alpha1.GlobalService); ok {
+			mirrorObjects = append(mirrorObjects, obj)
+			continue
+		}
+		kubeObjects = append(kubeObjects, obj)
+	}
+
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	if opts.Namespace == "" {
+		opts.Namespace = testGlobalNamespace
+	}
+
+	client := fake.NewSimpleClientset(kubeObjects...)
+	client.PrependReactor("patch", "*", applyReactor(client.Tracker(), opts.ForceConflicts))
+	mirrorClient := mirrorfake.NewSimpleClientset(mirrorObjects...)
+	mirrorClient.PrependReactor("patch", "*", applyReactor(mirrorClient.Tracker(), opts.ForceConflicts))
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		trafficSplitResource: "TrafficSplitList",
+		httpRouteResource:    "HTTPRouteList",
+	})
+	dynamicClient.PrependReactor("patch", "*", applyReactor(dynamicClient.Tracker(), opts.ForceConflicts))
+
+	w := NewWatch(context.Background(), client, mirrorClient, dynamicClient, log, opts)
+	w.recorder = record.NewFakeRecorder(100)
+	syncCache(t, w)
+	return w
+}
+
+// applyReactor does server-side apply on fake clientsets, which they don't support. Applied spec replaces the
+// existing one, as if we were its only field manager. Labels and annotations are merged the way apiserver does:
+// ones set by others stay, ones we stopped applying go away, and changing the value of one we don't own yet
+// is a conflict unless forced.
+func applyReactor(tracker clienttesting.ObjectTracker, force bool) clienttesting.ReactionFunc {
+	// Label and annotation keys we applied, by object.
+	owned := make(map[string]map[string]bool)
+	return func(action clienttesting.Action) (bool, runtime.Object, error) {
+		patch, ok := action.(clienttesting.PatchAction)
+		if !ok || patch.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+
+		var applied runtime.Object
+		switch patch.GetResource().Resource {
+		case "services":
+			applied = &corev1.Service{}
+		case "endpointslices":
+			applied = &discoveryv1.EndpointSlice{}
+		case "namespaces":
+			applied = &corev1.Namespace{}
+		case "globalservices":
+			applied = &mirrorv1alpha1.GlobalService{}
+		case "trafficsplits", "httproutes":
+			applied = &unstructured.Unstructured{}
+		default:
+			return true, nil, fmt.Errorf("apply of %v isn't supported", patch.GetResource().Resource)
+		}
+		if err := json.Unmarshal(patch.GetPatch(), applied); err != nil {
+			return true, nil, err
+		}
+
+		gvr, ns := patch.GetResource(), patch.GetNamespace()
+		objKey := gvr.String() + "/" + globalKey(ns, patch.GetName())
+		appliedMeta, _ := meta.Accessor(applied)
+		existing, err := tracker.Get(gvr, ns, patch.GetName())
+		if apiError.IsNotFound(err) && patch.GetSubresource() == "" {
+			owned[objKey+"/labels"] = keysOf(appliedMeta.GetLabels())
+			owned[objKey+"/annotations"] = keysOf(appliedMeta.GetAnnotations())
+			return true, applied, tracker.Create(gvr, applied, ns)
+		}
+		if err != nil {
+			return true, nil, err
+		}
+
+		if patch.GetSubresource() == "status" {
+			gs := existing.(*mirrorv1alpha1.GlobalService)
+			gs.Status = applied.(*mirrorv1alpha1.GlobalService).Status
+			return true, gs, tracker.Update(gvr, gs, ns)
+		}
+		existingMeta, _ := meta.Accessor(existing)
+		objLabels, err := mergeApplied(gvr.GroupResource(), patch.GetName(), "labels", existingMeta.GetLabels(), appliedMeta.GetLabels(), owned[objKey+"/labels"], force)
+		if err != nil {
+			return true, nil, err
+		}
+		annotations, err := mergeApplied(gvr.GroupResource(), patch.GetName(), "annotations", existingMeta.GetAnnotations(), appliedMeta.GetAnnotations(), owned[objKey+"/annotations"], force)
+		if err != nil {
+			return true, nil, err
+		}
+		owned[objKey+"/labels"] = keysOf(appliedMeta.GetLabels())
+		owned[objKey+"/annotations"] = keysOf(appliedMeta.GetAnnotations())
+		appliedMeta.SetLabels(objLabels)
+		appliedMeta.SetAnnotations(annotations)
+		// Fields set by apiserver stay.
+		appliedMeta.SetUID(existingMeta.GetUID())
+		appliedMeta.SetCreationTimestamp(existingMeta.GetCreationTimestamp())
+		appliedMeta.SetDeletionTimestamp(existingMeta.GetDeletionTimestamp())
+		return true, applied, tracker.Update(gvr, applied, ns)
+	}
+}
+
+// mergeApplied returns labels or annotations of object after apply, see applyReactor.
+func mergeApplied(resource schema.GroupResource, name, field string, existing, applied map[string]string, owned map[string]bool, force bool) (map[string]string, error) {
+	merged := make(map[string]string)
+	for k, v := range existing {
+		if _, ok := applied[k]; ok || !owned[k] {
+			merged[k] = v
+		}
+	}
+	for k, v := range applied {
+		if old, ok := existing[k]; ok && old != v && !owned[k] && !force {
+			return nil, apiError.NewConflict(resource, name, fmt.Errorf("Apply failed with 1 conflict: conflict with another manager: .metadata.%v.%v", field, k))
+		}
+		merged[k] = v
+	}
+	if len(merged) == 0 {
+		return nil, nil
+	}
+	return merged, nil
+}
+
+func keysOf(m map[string]string) map[string]bool {
+	keys := make(map[string]bool, len(m))
+	for k := range m {
+		keys[k] = true
+	}
+	return keys
+}
+
+// syncCache replaces content of informer caches with what is in the fake clientsets, like informers would do.
+// Every informer only gets objects it selects on the apiserver.
+func syncCache(t *testing.T, w *Watcher) {
+	t.Helper()
+	ctx := context.Background()
+
+	globalNamespace := w.namespace
+	if w.namespaceMode == NamespacePreserve {
+		globalNamespace = metav1.NamespaceAll
+	}
+	type scope struct {
+		factory     informers.SharedInformerFactory
+		namespace   string
+		svcSelector labels.Selector
+		epsSelector labels.Selector
+	}
+	scopes := []scope{{w.InformersFactory, globalNamespace, globalObjectSelector(), globalObjectSelector()}}
+	for namespace, factory := range w.MirroredFactories {
+		scopes = append(scopes, scope{factory, namespace, mirroredServiceSelector(w.serviceSelector), mirroredObjectSelector()})
+	}
+	selects := func(sc scope, selector labels.Selector, obj metav1.Object) bool {
+		return (sc.namespace == metav1.NamespaceAll || sc.namespace == obj.GetNamespace()) && selector.Matches(labels.Set(obj.GetLabels()))
+	}
+
+	svcs, err := w.clientset.CoreV1().Services("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("listing services: %v", err)
+	}
+	slices, err := w.clientset.DiscoveryV1().EndpointSlices("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("listing endpointslices: %v", err)
+	}
+	for _, sc := range scopes {
+		items := make([]interface{}, 0)
+		for i := range svcs.Items {
+			if selects(sc, sc.svcSelector, &svcs.Items[i]) {
+				items = append(items, &svcs.Items[i])
+			}
+		}
+		if err := sc.factory.Core().V1().Services().Informer().GetIndexer().Replace(items, ""); err != nil {
+			t.Fatalf("filling services cache: %v", err)
+		}
+
+		items = make([]interface{}, 0)
+		for i := range slices.Items {
+			if selects(sc, sc.epsSelector, &slices.Items[i]) {
+				items = append(items, &slices.Items[i])
+			}
+		}
+		if err := sc.factory.Discovery().V1().EndpointSlices().Informer().GetIndexer().Replace(items, ""); err != nil {
+			t.Fatalf("filling endpointslices cache: %v", err)
+		}
+	}
+
+	if w.namespaceFactory != nil {
+		namespaces, err := w.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("listing namespaces: %v", err)
+		}
+		items := make([]interface{}, 0)
+		for i := range namespaces.Items {
+			if w.namespaceSelector.Matches(labels.Set(namespaces.Items[i].Labels)) {
+				items = append(items, &namespaces.Items[i])
+			}
+		}
+		if err := w.namespaceFactory.Core().V1().Namespaces().Informer().GetIndexer().Replace(items, ""); err != nil {
+			t.Fatalf("filling namespaces cache: %v", err)
+		}
+	}
+
+	for namespace, factory := range w.splitFactories {
+		sc := scope{factory, namespace, splitObjectSelector(), splitObjectSelector()}
+		items := make([]interface{}, 0)
+		for i := range svcs.Items {
+			if selects(sc, sc.svcSelector, &svcs.Items[i]) {
+				items = append(items, &svcs.Items[i])
+			}
+		}
+		if err := factory.Core().V1().Services().Informer().GetIndexer().Replace(items, ""); err != nil {
+			t.Fatalf("filling apex services cache: %v", err)
+		}
+		resource := w.splitResource()
+		splits, err := w.dynamicClient.Resource(resource).Namespace(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("listing %v: %v", resource.Resource, err)
+		}
+		items = make([]interface{}, 0)
+		for i := range splits.Items {
+			if selects(sc, sc.epsSelector, &splits.Items[i]) {
+				items = append(items, &splits.Items[i])
+			}
+		}
+		if err := w.splitDynamicFactories[namespace].ForResource(resource).Informer().GetIndexer().Replace(items, ""); err != nil {
+			t.Fatalf("filling %v cache: %v", resource.Resource, err)
+		}
+	}
+
+	items := make([]interface{}, 0)
+	gss, err := w.mirrorClient.MirrorV1alpha1().GlobalServices("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("listing globalservices: %v", err)
+	}
+	for i := range gss.Items {
+		items = append(items, &gss.Items[i])
+	}
+	if err := w.MirrorInformersFactory.Mirror().V1alpha1().GlobalServices().Informer().GetIndexer().Replace(items, ""); err != nil {
+		t.Fatalf("filling globalservices cache: %v", err)
+	}
+}
+
+// mirroredService returns service the way Linkerd mirrors service from target cluster, i.e. x-target1.
+func mirroredService(service, cluster string, ports ...corev1.ServicePort) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      service + "-" + cluster,
+			Namespace: testNamespace,
+			Labels: map[string]string{
+				mirroredServiceLabel: "true",
+				clusterNameLabel:     cluster,
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: corev1.ClusterIPNone,
+			Ports:     ports,
+		},
+	}
+}
+
+// mirroredEndpointSlice returns endpointslice of mirrored service x-target1, endpoints with empty hostname get none.
+func mirroredEndpointSlice(service, cluster string, hostnames ...string) *discoveryv1.EndpointSlice {
+	endpoints := make([]discoveryv1.Endpoint, 0, len(hostnames))
+	for i, hostname := range hostnames {
+		ep := discoveryv1.Endpoint{Addresses: []string{"10.0.0." + string(rune('1'+i))}}
+		if hostname != "" {
+			hostname := hostname
+			ep.Hostname = &hostname
+		}
+		endpoints = append(endpoints, ep)
+	}
+	port := int32(80)
+	return &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      service + "-" + cluster + "-abcde",
+			Namespace: testNamespace,
+			Labels: map[string]string{
+				mirroredServiceLabel: "true",
+				clusterNameLabel:     cluster,
+				serviceNameLabel:     service + "-" + cluster,
+			},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints:   endpoints,
+		Ports:       []discoveryv1.EndpointPort{{Port: &port}},
+	}
+}
+
+// globalSlicesOf returns global endpointslices holding endpoints of mirrored service.
+func globalSlicesOf(t *testing.T, w *Watcher, targetSvcName string) []discoveryv1.EndpointSlice {
+	t.Helper()
+	selector := fmt.Sprintf("%v=%v", targetMirrorSvcNameLabel, targetSvcName)
+	slices, err := w.clientset.DiscoveryV1().EndpointSlices(testGlobalNamespace).List(context.Background(), metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		t.Fatalf("listing global endpointslices: %v", err)
+	}
+	return slices.Items
+}
+
+// clustersWithSlices returns which of the clusters have global endpointslices holding endpoints of the mirrored service.
+func clustersWithSlices(t *testing.T, w *Watcher, service string, clusters ...string) []string {
+	t.Helper()
+	found := make([]string, 0, len(clusters))
+	for _, cluster := range clusters {
+		if len(globalSlicesOf(t, w, service+"-"+cluster)) > 0 {
+			found = append(found, cluster)
+		}
+	}
+	return found
+}
+
+func servicePort(name string, port, targetPort int32) corev1.ServicePort {
+	return corev1.ServicePort{Name: name, Port: port, TargetPort: intstr.FromInt(int(targetPort)), Protocol: corev1.ProtocolTCP}
+}
+
+func TestEnqueue(t *testing.T) {
+	globalSvc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{
+		Name:      "x-global",
+		Namespace: testGlobalNamespace,
+		Labels:    map[string]string{globalMirrorLabel: "true"},
+	}}
+	headlessMirror := mirroredService("x-0", "target1")
+	headlessMirror.Labels[headlessMirrorLabel] = "x-target1"
+	globalEps := mirroredEndpointSlice("x", "target1", "x-0")
+	globalEps.Namespace = testGlobalNamespace
+	globalEps.Labels = map[string]string{serviceNameLabel: "x-global", globalMirrorLabel: "true"}
+	declared := &mirrorv1alpha1.GlobalService{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: testGlobalNamespace},
+		Spec:       mirrorv1alpha1.GlobalServiceSpec{Service: "web", Name: "web-everywhere"},
+	}
+
+	tests := []struct {
+		name          string
+		autoAggregate bool
+		svc           *corev1.Service
+		eps           *discoveryv1.EndpointSlice
+		want          []string
+	}{
+		{name: "mirrored service with auto aggregation", autoAggregate: true, svc: mirroredService("x", "target1"), want: []string{testGlobalNamespace + "/x-global"}},
+		{name: "mirrored service without auto aggregation", svc: mirroredService("x", "target1"), want: []string{}},
+		{name: "mirrored service declared by GlobalService", autoAggregate: true, svc: mirroredService("web", "target1"), want: []string{testGlobalNamespace + "/web-everywhere"}},
+		{name: "headless mirror of pod is ignored", autoAggregate: true, svc: headlessMirror, want: []string{}},
+		{name: "global service", svc: globalSvc, want: []string{testGlobalNamespace + "/x-global"}},
+		{name: "mirrored endpointslice", autoAggregate: true, eps: mirroredEndpointSlice("x", "target2", "x-0"), want: []string{testGlobalNamespace + "/x-global"}},
+		{name: "global endpointslice", eps: globalEps, want: []string{testGlobalNamespace + "/x-global"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWatcher(t, Options{AutoAggregate: tt.autoAggregate}, declared)
+			if tt.svc != nil {
+				w.enqueueService(tt.svc)
+			}
+			if tt.eps != nil {
+				w.enqueueEndpointSlice(tt.eps)
+			}
+
+			got := make([]string, 0)
+			for w.queue.Len() > 0 {
+				key, _ := w.queue.Get()
+				got = append(got, key.(string))
+				w.queue.Done(key)
+			}
+			sort.Strings(got)
+			if len(got) != len(tt.want) {
+				t.Fatalf("queued %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("queued %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// hasEvent drains events recorded so far, reporting if any of them has the reason.
+func hasEvent(w *Watcher, reason string) bool {
+	found := false
+	for {
+		select {
+		case event := <-w.recorder.(*record.FakeRecorder).Events:
+			if strings.Contains(event, " "+reason+" ") {
+				found = true
+			}
+		default:
+			return found
+		}
+	}
+}
+
+// requeueRecorder records global services requeued with a delay, instead of waiting for the delay to pass.
+type requeueRecorder struct {
+	workqueue.RateLimitingInterface
+	mu    sync.Mutex
+	after map[interface{}]time.Duration
+}
+
+func (q *requeueRecorder) AddAfter(item interface{}, duration time.Duration) {
+	q.mu.Lock()
+	q.after[item] = duration
+	q.mu.Unlock()
+	q.RateLimitingInterface.AddAfter(item, duration)
+}
+
+// requeued returns global services requeued with a delay so far, and the delay.
+func (q *requeueRecorder) requeued() map[interface{}]time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	requeued := make(map[interface{}]time.Duration, len(q.after))
+	for item, duration := range q.after {
+		requeued[item] = duration
+	}
+	return requeued
+}
+
+// recordRequeues makes watcher queue record requeues with a delay.
+func recordRequeues(w *Watcher) *requeueRecorder {
+	q := &requeueRecorder{RateLimitingInterface: w.queue, after: make(map[interface{}]time.Duration)}
+	w.queue = q
+	return q
+}